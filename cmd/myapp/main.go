@@ -4,23 +4,30 @@ import (
 	"context"
 	"log"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/provider"
+	filestore "github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/stores/file"
 )
 
 func main() {
-	_, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion("us-east-2"))
+	ctx := context.TODO()
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion("us-east-2"))
 	if err != nil {
 		log.Fatal("could not load aws config")
 	}
-	/*
-		//TODO; Create a Resource Store
-			myinfra := awsinfra.New(provider.NewResourceProvider(cfg))
-			myvpc, err := myinfra.CreateVPC("myvpc", infra.VPC{
-				CidrBlock: "10.0.0.0/16",
-			})
-			if err != nil {
-				log.Fatal("could not create vpc")
-			}
-			log.Printf("%s %v", myvpc.GetID(), myvpc.GetHandler())
-	*/
+	store, err := filestore.New("infra.json")
+	if err != nil {
+		log.Fatalf("could not open the resource store: %v", err)
+	}
+	myinfra := awsinfra.New(provider.NewResourceProvider(cfg), store, false)
+	myvpc, err := myinfra.CreateVPC(ctx, "myvpc", &awsinfra.VPCInput{
+		CreateVpcInput: &ec2.CreateVpcInput{CidrBlock: aws.String("10.0.0.0/16")},
+	})
+	if err != nil {
+		log.Fatalf("could not create vpc: %v", err)
+	}
+	log.Printf("%s %s", aws.ToString(myvpc.VpcId), aws.ToString(myvpc.CidrBlock))
 }
@@ -0,0 +1,66 @@
+package awsinfra
+
+import (
+	"fmt"
+	"slices"
+)
+
+// The route table of a Subnet is set either by SubnetInput.RouteTableId or by the RouteTableInput.SubnetIds
+// of a RouteTable, never by both, as each would move the association back on every execution.
+
+// claimRouteTable records that the Subnet id sets its RouteTableId, failing when a RouteTable of this
+// Infra lists the Subnet in its SubnetIds
+func (i *Infra) claimRouteTable(id InternalID, input *SubnetInput) error {
+	if input == nil || input.RouteTableId == nil {
+		return nil
+	}
+	subnetID, err := i.knownExternalID(id)
+	if err != nil {
+		return err
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if subnetID != nil {
+		if table, ok := i.associatedSubnets[*subnetID]; ok {
+			return fmt.Errorf("subnet %s sets its RouteTableId and is in the SubnetIds of %s, only one of them can associate it", *subnetID, table)
+		}
+	}
+	if i.routedSubnets == nil {
+		i.routedSubnets = make(map[InternalID]bool)
+	}
+	i.routedSubnets[id] = true
+	return nil
+}
+
+// claimSubnets records that the RouteTable id associates its SubnetIds, failing when one of them is
+// a Subnet of this Infra setting its RouteTableId
+func (i *Infra) claimSubnets(id InternalID, input *RouteTableInput) error {
+	if input == nil || len(input.SubnetIds) == 0 {
+		return nil
+	}
+	i.mu.Lock()
+	routed := make([]InternalID, 0, len(i.routedSubnets))
+	for subnet := range i.routedSubnets {
+		routed = append(routed, subnet)
+	}
+	i.mu.Unlock()
+	slices.Sort(routed)
+	for _, subnet := range routed {
+		subnetID, err := i.knownExternalID(subnet)
+		if err != nil {
+			return err
+		}
+		if subnetID != nil && slices.Contains(input.SubnetIds, *subnetID) {
+			return fmt.Errorf("subnet %s (%s) sets its RouteTableId, it can't be in SubnetIds too", *subnetID, subnet)
+		}
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.associatedSubnets == nil {
+		i.associatedSubnets = make(map[string]InternalID)
+	}
+	for _, subnetID := range input.SubnetIds {
+		i.associatedSubnets[subnetID] = id
+	}
+	return nil
+}
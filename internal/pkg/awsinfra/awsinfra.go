@@ -1,37 +1,63 @@
 package awsinfra
 
 import (
+	"context"
 	"fmt"
+	"sync"
+	"time"
 
-	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
 	autoscalingtypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
-	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
-	elbv2 "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
 	elbv2types "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
-	"github.com/aws/aws-sdk-go-v2/service/route53"
 	route53types "github.com/aws/aws-sdk-go-v2/service/route53/types"
 )
 
 // Infra manages the lifecycle of cloud infrastructure components, such as creation,
 // update, and deletion.
 type Infra struct {
-	defaultRollback  bool                      //Experimental
-	resourceProvider ResourceProvider          // Provider implements resource creation interfaces.
-	resourceStore    ResourceStore             //Permanent datastore the syncs the infra
-	localStore       map[InternalID]ExternalID // Tracks created resources and avoid duplicated resources
-	resourceStack    resourceStack             //remembers the sequence of created elements to rollback
+	defaultRollback   bool                      //Experimental
+	resourceProvider  ResourceProvider          // Provider implements resource creation interfaces.
+	resourceStore     ResourceStore             //Permanent datastore the syncs the infra
+	localStore        map[InternalID]ExternalID // Tracks created resources and avoid duplicated resources
+	resourceGraph     resourceGraph             //remembers the created elements and their dependencies to rollback
+	declared          resourceGraph             //resources declared to be applied by Apply
+	retired           []retiredResource         //resources replaced by an update, destroyed by Prune
+	routedSubnets     map[InternalID]bool       //Subnets setting their RouteTableId
+	associatedSubnets map[string]InternalID     //Subnet ids associated by the SubnetIds of a RouteTable
+	maxWorkers        int                       //maximum number of resources applied or destroyed in parallel
+	mu                sync.Mutex                //guards localStore, resourceGraph, declared and the Subnet associations
+	lockMu            sync.Mutex                //guards locks, held while the resource store is locked or unlocked
+	locks             int                       //running operations sharing the resource store lock
+}
+
+// DefaultMaxWorkers is the number of resources applied or destroyed in parallel, unless
+// WithMaxWorkers says otherwise.
+const DefaultMaxWorkers = 4
+
+// Option configures optional Infra settings
+type Option func(*Infra)
+
+// WithMaxWorkers limits the number of resources applied or destroyed in parallel.
+// Values lower than 1 are treated as 1, which applies resources one by one.
+func WithMaxWorkers(n int) Option {
+	return func(i *Infra) {
+		i.maxWorkers = n
+	}
 }
 
 // New initializes a new infrastructure manager with the specified provider.
-func New(resourceProvicer ResourceProvider, resourceStore ResourceStore, withRollback bool) *Infra {
-	return &Infra{
-		withRollback,
-		resourceProvicer,
-		resourceStore,
-		make(map[InternalID]ExternalID),
-		resourceStack{},
+func New(resourceProvicer ResourceProvider, resourceStore ResourceStore, withRollback bool, opts ...Option) *Infra {
+	infra := &Infra{
+		defaultRollback:  withRollback,
+		resourceProvider: resourceProvicer,
+		resourceStore:    resourceStore,
+		localStore:       make(map[InternalID]ExternalID),
+		maxWorkers:       DefaultMaxWorkers,
+	}
+	for _, opt := range opts {
+		opt(infra)
 	}
+	return infra
 }
 
 // ExternalID is a unique identifier for a resource in an external system.
@@ -41,70 +67,266 @@ type ExternalID = *string
 type InternalID = string
 
 // ResourceManager create or update resources
+// Every method receives the caller context, which must be used for all the calls
+// made to the cloud provider so cancellation, deadlines and request-scoped values
+// are honoured.
 type ResourceManager[Input any, Output any] interface {
-	Create(input Input) (ExternalID, Output, error)
-	Update(input Input, last Output) (ExternalID, Output, error)
-	Load(id ExternalID) (Output, error)
+	Create(ctx context.Context, input Input) (ExternalID, Output, error)
+	Update(ctx context.Context, input Input, last Output) (ExternalID, Output, error)
+	Load(ctx context.Context, id ExternalID) (Output, error)
 	ResourceDestroyer
 }
 
 // ResourceDestroyer destroy resources
 type ResourceDestroyer interface {
-	Destroy(id ExternalID) error
+	Destroy(ctx context.Context, id ExternalID) error
 }
 
-// ResourceStore helps with idempotency
+// ResourceStore helps with idempotency.
+// Apply may call it from several goroutines, so implementations must be safe for concurrent use.
 type ResourceStore interface {
 	Exists(internalID InternalID) (bool, error)
 	Get(internalID InternalID) (ExternalID, error)
 	Set(internalID InternalID, externalID ExternalID) error
 }
 
+// ResourceHistory is implemented by resource stores remembering every ExternalID an InternalID was mapped to
+type ResourceHistory interface {
+	ResourceStore
+	//History returns the mappings of the InternalID, oldest first
+	History(internalID InternalID) ([]Mapping, error)
+	//GetAt returns the ExternalID the InternalID was mapped to at the given time, nil when it had none
+	GetAt(internalID InternalID, at time.Time) (ExternalID, error)
+}
+
+// ResourceLocker is implemented by resource stores shared by several processes.
+// Infra holds the lock while it creates, updates or destroys resources, so two processes
+// never change the same resources at once. Implementations keep the lock alive until Unlock.
+type ResourceLocker interface {
+	//Lock acquires the lock, failing when another process holds it
+	Lock(ctx context.Context) error
+	//Unlock releases the lock
+	Unlock(ctx context.Context) error
+}
+
+// Mapping is an ExternalID an InternalID was mapped to
+type Mapping struct {
+	ExternalID ExternalID `json:"externalId"` //nil when the mapping was removed
+	SetAt      time.Time  `json:"setAt"`
+	DeployID   string     `json:"deployId,omitempty"` //the deploy that set it, if known
+}
+
 // ResourceProvider aggregates interfaces for creating cloud resources. Implementations of ResourceProvider
 // enable the creation of VPCs, DNS records, and subnets, along with managing their resource handlers.
 type ResourceProvider interface {
-	VPC() ResourceManager[*ec2.CreateVpcInput, *ec2types.Vpc]
-	DNSRecordSet() ResourceManager[*route53.ChangeResourceRecordSetsInput, *route53types.ChangeInfo]
-	Subnet() ResourceManager[*ec2.CreateSubnetInput, *ec2types.Subnet]
-	LoadBalancer() ResourceManager[*elbv2.CreateLoadBalancerInput, []elbv2types.LoadBalancer]
-	LaunchTemplate() ResourceManager[*ec2.CreateLaunchTemplateInput, *ec2types.LaunchTemplate]
-	AutoScalingGroup() ResourceManager[*autoscaling.CreateAutoScalingGroupInput, *autoscalingtypes.AutoScalingGroup]
+	VPC() ResourceManager[*VPCInput, *ec2types.Vpc]
+	DNSRecordSet() ResourceManager[*DNSRecordInput, *route53types.ResourceRecordSet]
+	Subnet() ResourceManager[*SubnetInput, *ec2types.Subnet]
+	SecurityGroup() ResourceManager[*SecurityGroupInput, *ec2types.SecurityGroup]
+	InternetGateway() ResourceManager[*InternetGatewayInput, *ec2types.InternetGateway]
+	NatGateway() ResourceManager[*NatGatewayInput, *ec2types.NatGateway]
+	RouteTable() ResourceManager[*RouteTableInput, *ec2types.RouteTable]
+	LoadBalancer() ResourceManager[*LoadBalancerInput, *LoadBalancer]
+	TargetGroup() ResourceManager[*TargetGroupInput, *TargetGroup]
+	Listener() ResourceManager[*ListenerInput, *Listener]
+	ListenerRule() ResourceManager[*ListenerRuleInput, *elbv2types.Rule]
+	LaunchTemplate() ResourceManager[*LaunchTemplateInput, *ec2types.LaunchTemplate]
+	AutoScalingGroup() ResourceManager[*AutoScalingGroupInput, *autoscalingtypes.AutoScalingGroup]
 }
 
 // CreateVPC requests the creation of a VPC resource in the cloud, using the provided definition.
-func (i *Infra) CreateVPC(id string, input *ec2.CreateVpcInput) (*ec2types.Vpc, error) {
-	return createWithRollback(i, id, input, i.resourceProvider.VPC())
+func (i *Infra) CreateVPC(ctx context.Context, id string, input *VPCInput) (*ec2types.Vpc, error) {
+	return createWithRollback(ctx, i, id, input, i.resourceProvider.VPC())
 }
 
 // CreateDNS requests the creation of a DNS record in the cloud, using the provided definition.
-func (i *Infra) CreateDNS(id string, input *route53.ChangeResourceRecordSetsInput) (*route53types.ChangeInfo, error) {
-	return createWithRollback(i, id, input, i.resourceProvider.DNSRecordSet())
+func (i *Infra) CreateDNS(ctx context.Context, id string, input *DNSRecordInput) (*route53types.ResourceRecordSet, error) {
+	return createWithRollback(ctx, i, id, input, i.resourceProvider.DNSRecordSet())
 }
 
 // CreateSubnet requests the creation of a Subnet resource in the cloud, using the provided definition.
-func (i *Infra) CreateSubnet(id string, input *ec2.CreateSubnetInput) (*ec2types.Subnet, error) {
-	return createWithRollback(i, id, input, i.resourceProvider.Subnet())
+// A Subnet setting its RouteTableId can't be in the SubnetIds of a RouteTable.
+func (i *Infra) CreateSubnet(ctx context.Context, id string, input *SubnetInput) (*ec2types.Subnet, error) {
+	if err := i.validateInitialization(); err != nil {
+		return nil, err
+	}
+	if err := i.claimRouteTable(id, input); err != nil {
+		return nil, &InfraError{ErrFailedResourceInput, fmt.Errorf("ID: %s, Caused by %v ", id, err)}
+	}
+	return createWithRollback(ctx, i, id, input, i.resourceProvider.Subnet())
+}
+
+// CreateInternetGateway requests the creation of an InternetGateway resource in the cloud, using the provided definition.
+func (i *Infra) CreateInternetGateway(ctx context.Context, id string, input *InternetGatewayInput) (*ec2types.InternetGateway, error) {
+	return createWithRollback(ctx, i, id, input, i.resourceProvider.InternetGateway())
 }
 
-// CreateLoadBalancer requests the creation of a Subnet resource in the cloud, using the provided definition.
-func (i *Infra) CreateLoadBalancer(id string, input *elbv2.CreateLoadBalancerInput) ([]elbv2types.LoadBalancer, error) {
-	return createWithRollback(i, id, input, i.resourceProvider.LoadBalancer())
+// CreateNatGateway requests the creation of a NatGateway resource in the cloud, using the provided definition.
+// It returns once the NatGateway is available.
+func (i *Infra) CreateNatGateway(ctx context.Context, id string, input *NatGatewayInput) (*ec2types.NatGateway, error) {
+	return createWithRollback(ctx, i, id, input, i.resourceProvider.NatGateway())
+}
+
+// CreateRouteTable requests the creation of a RouteTable resource in the cloud, using the provided definition.
+// Its SubnetIds can't include a Subnet setting its RouteTableId.
+func (i *Infra) CreateRouteTable(ctx context.Context, id string, input *RouteTableInput) (*ec2types.RouteTable, error) {
+	if err := i.validateInitialization(); err != nil {
+		return nil, err
+	}
+	if err := i.claimSubnets(id, input); err != nil {
+		return nil, &InfraError{ErrFailedResourceInput, fmt.Errorf("ID: %s, Caused by %v ", id, err)}
+	}
+	return createWithRollback(ctx, i, id, input, i.resourceProvider.RouteTable())
+}
+
+// CreateSecurityGroup requests the creation of a SecurityGroup resource in the cloud, using the provided definition.
+// The security groups referenced by InternalID in its rules must have been created before.
+func (i *Infra) CreateSecurityGroup(ctx context.Context, id string, input *SecurityGroupInput) (*ec2types.SecurityGroup, error) {
+	if err := i.validateInitialization(); err != nil {
+		return nil, err
+	}
+	resolved, err := i.resolveSecurityGroups(id, input)
+	if err != nil {
+		return nil, err
+	}
+	return createWithRollback(ctx, i, id, resolved, i.resourceProvider.SecurityGroup())
+}
+
+// CreateLoadBalancer requests the creation of a LoadBalancer resource in the cloud, using the provided definition.
+func (i *Infra) CreateLoadBalancer(ctx context.Context, id string, input *LoadBalancerInput) (*LoadBalancer, error) {
+	return createWithRollback(ctx, i, id, input, i.resourceProvider.LoadBalancer())
+}
+
+// CreateTargetGroup requests the creation of a TargetGroup resource in the cloud, using the provided definition.
+func (i *Infra) CreateTargetGroup(ctx context.Context, id string, input *TargetGroupInput) (*TargetGroup, error) {
+	return createWithRollback(ctx, i, id, input, i.resourceProvider.TargetGroup())
+}
+
+// CreateListener requests the creation of a Listener resource in the cloud, using the provided definition.
+func (i *Infra) CreateListener(ctx context.Context, id string, input *ListenerInput) (*Listener, error) {
+	return createWithRollback(ctx, i, id, input, i.resourceProvider.Listener())
+}
+
+// CreateListenerRule requests the creation of a listener Rule resource in the cloud, using the provided definition.
+func (i *Infra) CreateListenerRule(ctx context.Context, id string, input *ListenerRuleInput) (*elbv2types.Rule, error) {
+	return createWithRollback(ctx, i, id, input, i.resourceProvider.ListenerRule())
 }
 
 // CreateLaunchTemplate requests the creation of a LaunchTemplate resource in the cloud, using the provided definition.
-func (i *Infra) CreateLaunchTemplate(id string, input *ec2.CreateLaunchTemplateInput) (*ec2types.LaunchTemplate, error) {
-	return createWithRollback(i, id, input, i.resourceProvider.LaunchTemplate())
+func (i *Infra) CreateLaunchTemplate(ctx context.Context, id string, input *LaunchTemplateInput) (*ec2types.LaunchTemplate, error) {
+	return createWithRollback(ctx, i, id, input, i.resourceProvider.LaunchTemplate())
 }
 
 // CreateAutoScale requests the creation of a LaunchTemplate resource in the cloud, using the provided definition.
-func (i *Infra) CreateAutoScale(id string, input *autoscaling.CreateAutoScalingGroupInput) (*autoscalingtypes.AutoScalingGroup, error) {
-	return createWithRollback(i, id, input, i.resourceProvider.AutoScalingGroup())
+func (i *Infra) CreateAutoScale(ctx context.Context, id string, input *AutoScalingGroupInput) (*autoscalingtypes.AutoScalingGroup, error) {
+	return createWithRollback(ctx, i, id, input, i.resourceProvider.AutoScalingGroup())
+}
+
+// DeclareVPC declares a VPC resource to be created or updated by Apply once all the resources in dependsOn are applied.
+func (i *Infra) DeclareVPC(id InternalID, input InputFunc[*VPCInput], dependsOn ...InternalID) (*Resource[*ec2types.Vpc], error) {
+	return declare(i, id, input, i.resourceProvider.VPC(), dependsOn)
+}
+
+// DeclareDNS declares a DNS record to be created or updated by Apply once all the resources in dependsOn are applied.
+func (i *Infra) DeclareDNS(id InternalID, input InputFunc[*DNSRecordInput], dependsOn ...InternalID) (*Resource[*route53types.ResourceRecordSet], error) {
+	return declare(i, id, input, i.resourceProvider.DNSRecordSet(), dependsOn)
+}
+
+// DeclareSubnet declares a Subnet resource to be created or updated by Apply once all the resources in dependsOn are applied.
+// A Subnet setting its RouteTableId can't be in the SubnetIds of a RouteTable.
+func (i *Infra) DeclareSubnet(id InternalID, input InputFunc[*SubnetInput], dependsOn ...InternalID) (*Resource[*ec2types.Subnet], error) {
+	claimed := func(ctx context.Context) (*SubnetInput, error) {
+		in, err := input(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return in, i.claimRouteTable(id, in)
+	}
+	return declare(i, id, claimed, i.resourceProvider.Subnet(), dependsOn)
+}
+
+// DeclareInternetGateway declares an InternetGateway resource to be created or updated by Apply once all the resources in dependsOn are applied.
+func (i *Infra) DeclareInternetGateway(id InternalID, input InputFunc[*InternetGatewayInput], dependsOn ...InternalID) (*Resource[*ec2types.InternetGateway], error) {
+	return declare(i, id, input, i.resourceProvider.InternetGateway(), dependsOn)
+}
+
+// DeclareNatGateway declares a NatGateway resource to be created or updated by Apply once all the resources in dependsOn are applied.
+func (i *Infra) DeclareNatGateway(id InternalID, input InputFunc[*NatGatewayInput], dependsOn ...InternalID) (*Resource[*ec2types.NatGateway], error) {
+	return declare(i, id, input, i.resourceProvider.NatGateway(), dependsOn)
+}
+
+// DeclareRouteTable declares a RouteTable resource to be created or updated by Apply once all the resources in dependsOn are applied.
+// Its SubnetIds can't include a Subnet setting its RouteTableId.
+func (i *Infra) DeclareRouteTable(id InternalID, input InputFunc[*RouteTableInput], dependsOn ...InternalID) (*Resource[*ec2types.RouteTable], error) {
+	claimed := func(ctx context.Context) (*RouteTableInput, error) {
+		in, err := input(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return in, i.claimSubnets(id, in)
+	}
+	return declare(i, id, claimed, i.resourceProvider.RouteTable(), dependsOn)
+}
+
+// DeclareSecurityGroup declares a SecurityGroup resource to be created or updated by Apply once all the resources in dependsOn are applied.
+// The security groups referenced by InternalID in its rules must be in dependsOn, unless they already exist.
+func (i *Infra) DeclareSecurityGroup(id InternalID, input InputFunc[*SecurityGroupInput], dependsOn ...InternalID) (*Resource[*ec2types.SecurityGroup], error) {
+	resolved := func(ctx context.Context) (*SecurityGroupInput, error) {
+		in, err := input(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return i.resolveSecurityGroups(id, in)
+	}
+	return declare(i, id, resolved, i.resourceProvider.SecurityGroup(), dependsOn)
+}
+
+// DeclareLoadBalancer declares a LoadBalancer resource to be created or updated by Apply once all the resources in dependsOn are applied.
+func (i *Infra) DeclareLoadBalancer(id InternalID, input InputFunc[*LoadBalancerInput], dependsOn ...InternalID) (*Resource[*LoadBalancer], error) {
+	return declare(i, id, input, i.resourceProvider.LoadBalancer(), dependsOn)
+}
+
+// DeclareTargetGroup declares a TargetGroup resource to be created or updated by Apply once all the resources in dependsOn are applied.
+func (i *Infra) DeclareTargetGroup(id InternalID, input InputFunc[*TargetGroupInput], dependsOn ...InternalID) (*Resource[*TargetGroup], error) {
+	return declare(i, id, input, i.resourceProvider.TargetGroup(), dependsOn)
+}
+
+// DeclareListener declares a Listener resource to be created or updated by Apply once all the resources in dependsOn are applied.
+func (i *Infra) DeclareListener(id InternalID, input InputFunc[*ListenerInput], dependsOn ...InternalID) (*Resource[*Listener], error) {
+	return declare(i, id, input, i.resourceProvider.Listener(), dependsOn)
+}
+
+// DeclareListenerRule declares a listener Rule resource to be created or updated by Apply once all the resources in dependsOn are applied.
+func (i *Infra) DeclareListenerRule(id InternalID, input InputFunc[*ListenerRuleInput], dependsOn ...InternalID) (*Resource[*elbv2types.Rule], error) {
+	return declare(i, id, input, i.resourceProvider.ListenerRule(), dependsOn)
+}
+
+// DeclareLaunchTemplate declares a LaunchTemplate resource to be created or updated by Apply once all the resources in dependsOn are applied.
+func (i *Infra) DeclareLaunchTemplate(id InternalID, input InputFunc[*LaunchTemplateInput], dependsOn ...InternalID) (*Resource[*ec2types.LaunchTemplate], error) {
+	return declare(i, id, input, i.resourceProvider.LaunchTemplate(), dependsOn)
+}
+
+// DeclareAutoScale declares an AutoScalingGroup resource to be created or updated by Apply once all the resources in dependsOn are applied.
+func (i *Infra) DeclareAutoScale(id InternalID, input InputFunc[*AutoScalingGroupInput], dependsOn ...InternalID) (*Resource[*autoscalingtypes.AutoScalingGroup], error) {
+	return declare(i, id, input, i.resourceProvider.AutoScalingGroup(), dependsOn)
+}
+
+// LoadLoadBalancer loads the LoadBalancer created with the id, without changing it.
+func (i *Infra) LoadLoadBalancer(ctx context.Context, id InternalID) (*LoadBalancer, error) {
+	return load(ctx, i, id, i.resourceProvider.LoadBalancer())
+}
+
+// LoadAutoScale loads the AutoScalingGroup created with the id, without changing it.
+func (i *Infra) LoadAutoScale(ctx context.Context, id InternalID) (*autoscalingtypes.AutoScalingGroup, error) {
+	return load(ctx, i, id, i.resourceProvider.AutoScalingGroup())
 }
 
 func (i *Infra) validateID(id string) error {
 	if id == "" {
 		return &InfraError{ErrBlankResourceID, nil}
 	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
 	if _, exists := i.localStore[id]; exists {
 		return &InfraError{ErrResourceExists, nil}
 	}
@@ -124,39 +346,277 @@ func (i *Infra) validateInitialization() error {
 	return nil
 }
 
-// Destroy all elements under the resource stack
-func (i *Infra) Destroy() error {
-	for {
-		rs, err := i.resourceStack.Pop()
-		if err != nil {
-			break
+// Apply creates or updates every resource declared since the last Apply.
+// A resource starts as soon as all its dependencies have been applied, so independent
+// branches of the graph run in parallel, up to the configured number of workers.
+// Once everything is applied, the resources replaced by updates are pruned.
+// When rollback is enabled, a failure destroys every tracked resource in reverse
+// topological order.
+func (i *Infra) Apply(ctx context.Context) (err error) {
+	if err := i.validateInitialization(); err != nil {
+		return err
+	}
+	unlock, err := i.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if unlockErr := unlock(); err == nil {
+			err = unlockErr
 		}
+	}()
+	i.mu.Lock()
+	declared := i.declared
+	for _, id := range declared.ids() {
+		for _, dep := range declared.nodes[id].dependsOn {
+			if _, exists := i.localStore[dep]; !exists && !declared.has(dep) {
+				i.mu.Unlock()
+				return &InfraError{ErrUnknownDependency, fmt.Errorf("ID: %s, depends on %s", id, dep)}
+			}
+		}
+	}
+	ids, err := declared.topologicalOrder()
+	if err != nil {
+		i.mu.Unlock()
+		return &InfraError{ErrDependencyCycle, err}
+	}
+	//The declarations are kept until the graph is valid, so a missing dependency can still be declared
+	i.declared = resourceGraph{}
+	i.mu.Unlock()
+	err = walk(ctx, ids, declared.dependencies(), i.maxWorkers, func(ctx context.Context, id InternalID) error {
+		return declared.nodes[id].apply(ctx)
+	})
+	if err == nil {
+		return i.Prune(ctx)
+	}
+	if _, ok := err.(*InfraError); !ok {
+		err = &InfraError{ErrApplyInterrupted, err}
+	}
+	if i.defaultRollback {
+		if err := i.rollback(ctx); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// rollbackTimeout is the maximum time a rollback spends destroying the tracked resources
+const rollbackTimeout = 30 * time.Minute
+
+// rollback destroys all tracked resources. It goes on when ctx is done, often the reason the creation
+// failed, so the resources created so far aren't leaked; rollbackTimeout bounds it instead.
+func (i *Infra) rollback(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), rollbackTimeout)
+	defer cancel()
+	return i.Destroy(ctx)
+}
+
+// Destroy all tracked resources, walking the dependency graph in reverse topological
+// order: a resource is destroyed once every resource depending on it is gone.
+// Independent resources are destroyed in parallel. Cancellation of ctx stops new
+// destructions from starting, and the resources left are kept so Destroy can be resumed later.
+func (i *Infra) Destroy(ctx context.Context) (err error) {
+	unlock, err := i.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if unlockErr := unlock(); err == nil {
+			err = unlockErr
+		}
+	}()
+	i.mu.Lock()
+	ids, err := i.resourceGraph.topologicalOrder()
+	dependents := i.resourceGraph.dependents()
+	nodes := make(map[InternalID]*resourceNode, len(ids))
+	for _, id := range ids {
+		nodes[id] = i.resourceGraph.nodes[id]
+	}
+	i.mu.Unlock()
+	if err != nil {
+		return &InfraError{ErrDependencyCycle, err}
+	}
+	for l, r := 0, len(ids)-1; l < r; l, r = l+1, r-1 {
+		ids[l], ids[r] = ids[r], ids[l]
+	}
+	err = walk(ctx, ids, dependents, i.maxWorkers, func(ctx context.Context, id InternalID) error {
+		i.mu.Lock()
+		externalID := i.localStore[id]
+		i.mu.Unlock()
 		//Destroy the cloud resource. it takes the externalID from the localStore
-		if err := rs.resourceDestroyer.Destroy(i.localStore[rs.id]); err != nil {
-			return &InfraError{ErrFailedResourceManagerDestroy, fmt.Errorf("ID: %s, Caused by %v ", rs.id, err)}
+		if err := nodes[id].destroyer.Destroy(ctx, externalID); err != nil {
+			return &InfraError{ErrFailedResourceManagerDestroy, fmt.Errorf("ID: %s, Caused by %v ", id, err)}
 		}
-		delete(i.localStore, rs.id) //deletes the id from the localStore, so it can be reused
+		i.mu.Lock()
+		i.resourceGraph.remove(id)
+		delete(i.localStore, id) //deletes the id from the localStore, so it can be reused
+		i.mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		if _, ok := err.(*InfraError); !ok {
+			return &InfraError{ErrDestroyInterrupted, err}
+		}
+		return err
 	}
 	return nil
 }
 
-func createWithRollback[Input any, Output any](infra *Infra, id InternalID, input Input, resourceManager ResourceManager[Input, Output]) (Output, error) {
-	output, err := create(infra, id, input, resourceManager)
+// Prune destroys the resources replaced by updates, the most recently replaced first,
+// so replaced dependents are gone before the resources they depended on.
+// Apply prunes once every declared resource is applied; callers of the Create methods
+// must call Prune themselves once the dependents of a replaced resource were updated.
+func (i *Infra) Prune(ctx context.Context) (err error) {
+	unlock, err := i.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if unlockErr := unlock(); err == nil {
+			err = unlockErr
+		}
+	}()
+	for {
+		if err := ctx.Err(); err != nil {
+			return &InfraError{ErrDestroyInterrupted, err}
+		}
+		i.mu.Lock()
+		if len(i.retired) == 0 {
+			i.mu.Unlock()
+			return nil
+		}
+		r := i.retired[len(i.retired)-1]
+		i.mu.Unlock()
+		if err := r.destroyer.Destroy(ctx, r.externalID); err != nil {
+			return &InfraError{ErrFailedResourceManagerDestroy, fmt.Errorf("ID: %s, replaced %s, Caused by %v ", r.id, *r.externalID, err)}
+		}
+		i.mu.Lock()
+		i.retired = i.retired[:len(i.retired)-1]
+		i.mu.Unlock()
+	}
+}
+
+// Resource is a handle to a resource declared in the dependency graph.
+// Its output is available once the resource has been applied, so the input
+// functions of the resources depending on it can read it.
+type Resource[Output any] struct {
+	id     InternalID
+	output Output
+}
+
+// ID returns the internal id of the resource
+func (r *Resource[Output]) ID() InternalID {
+	return r.id
+}
+
+// Output returns the resource as created or updated by Apply
+func (r *Resource[Output]) Output() Output {
+	return r.output
+}
+
+// OutputFor returns the resource as seen by the execution running with ctx: the loaded
+// resource while a Plan runs, the output of Apply otherwise. Input functions should use it
+// to read their dependencies, so they work in both.
+func (r *Resource[Output]) OutputFor(ctx context.Context) Output {
+	if outputs := planOutputsFrom(ctx); outputs != nil {
+		if output, ok := outputs.get(r.id); ok {
+			return output.(Output)
+		}
+	}
+	return r.output
+}
+
+// InputFunc builds the input of a declared resource.
+// It is called by Apply once all the dependencies of the resource have been applied,
+// and by Plan once they have been loaded. Dependencies are read with Resource.OutputFor.
+type InputFunc[Input any] func(ctx context.Context) (Input, error)
+
+// declare adds a resource to the graph applied by Apply
+func declare[Input any, Output any](infra *Infra, id InternalID, input InputFunc[Input], resourceManager ResourceManager[Input, Output], dependsOn []InternalID) (*Resource[Output], error) {
+	if err := infra.validateInitialization(); err != nil {
+		return nil, err
+	}
+	if err := infra.validateID(id); err != nil {
+		return nil, err
+	}
+	infra.mu.Lock()
+	defer infra.mu.Unlock()
+	if infra.declared.has(id) {
+		return nil, &InfraError{ErrResourceExists, nil}
+	}
+	resource := &Resource[Output]{id: id}
+	infra.declared.add(&resourceNode{
+		id:        id,
+		dependsOn: dependsOn,
+		destroyer: resourceManager,
+		apply: func(ctx context.Context) error {
+			in, err := input(ctx)
+			if err != nil {
+				return &InfraError{ErrFailedResourceInput, fmt.Errorf("ID: %s, Caused by %v ", id, err)}
+			}
+			output, err := create(ctx, infra, id, in, resourceManager, dependsOn)
+			resource.output = output
+			return err
+		},
+		plan: func(ctx context.Context, unknown []InternalID) (ResourceChange, error) {
+			return planResource(ctx, infra, id, input, resourceManager, dependsOn, unknown)
+		},
+	})
+	return resource, nil
+}
+
+func createWithRollback[Input any, Output any](ctx context.Context, infra *Infra, id InternalID, input Input, resourceManager ResourceManager[Input, Output]) (output Output, err error) {
+	unlock, err := infra.lock(ctx)
+	if err != nil {
+		return output, err
+	}
+	defer func() {
+		if unlockErr := unlock(); err == nil {
+			err = unlockErr
+		}
+	}()
+	//Resources created outside of Apply depend on everything created before them,
+	//so they are destroyed in the reverse order of creation
+	output, err = create(ctx, infra, id, input, resourceManager, infra.trackedIDs())
 	if err != nil {
 		if !infra.defaultRollback {
 			return output, err
 		}
-		if err := infra.Destroy(); err != nil { //Destroy all stacked resources
+		if err := infra.rollback(ctx); err != nil {
 			return output, err
 		}
 	}
 	return output, err
 }
 
+// load reads a resource created with the id, looking its ExternalID up in the resource store
+func load[Input any, Output any](ctx context.Context, infra *Infra, id InternalID, resourceManager ResourceManager[Input, Output]) (Output, error) {
+	var output Output
+	if err := infra.validateInitialization(); err != nil {
+		return output, err
+	}
+	exists, err := infra.resourceStore.Exists(id)
+	if err != nil {
+		return output, &InfraError{ErrFailedResourceStoreExists, fmt.Errorf("ID: %s, Caused by %v ", id, err)}
+	}
+	if !exists {
+		return output, &InfraError{ErrResourceNotFound, fmt.Errorf("ID: %s", id)}
+	}
+	externalID, err := infra.resourceStore.Get(id)
+	if err != nil {
+		return output, &InfraError{ErrFailedResourceStoreGet, fmt.Errorf("ID: %s, Caused by %v ", id, err)}
+	}
+	output, err = resourceManager.Load(ctx, externalID)
+	if err != nil {
+		return output, &InfraError{ErrFailedResourceManagerLoad, fmt.Errorf("ID: %s, Caused by %v ", id, err)}
+	}
+	return output, nil
+}
+
 // create is a generic function that encapsulates common logic for resource creation.
 // It checks for the presence of a provider and resources, ensuring id uniqueness and provider
 // ability to innerCreate and store the resource.
-func create[Input any, Output any](infra *Infra, id InternalID, input Input, resourceManager ResourceManager[Input, Output]) (Output, error) {
+func create[Input any, Output any](ctx context.Context, infra *Infra, id InternalID, input Input, resourceManager ResourceManager[Input, Output], dependsOn []InternalID) (Output, error) {
 	var output Output
 	var outputID ExternalID
 
@@ -172,15 +632,22 @@ func create[Input any, Output any](infra *Infra, id InternalID, input Input, res
 	}
 	if !exists {
 		//Creates the resource
-		externalID, created, err := resourceManager.Create(input)
+		externalID, created, err := resourceManager.Create(ctx, input)
 		if err != nil {
-			return output, &InfraError{ErrFailedResourceManagerCreate, fmt.Errorf("ID: %s, Caused by %v ", id, err)}
+			createErr := &InfraError{ErrFailedResourceManagerCreate, fmt.Errorf("ID: %s, Caused by %v ", id, err)}
+			if externalID == nil || *externalID == "" { //nothing was created
+				return output, createErr
+			}
+			//The resource exists but wasn't completed: it is tracked so rollback destroys it,
+			//and stored so the next execution updates it instead of leaking it
+			infra.track(id, externalID, resourceManager, dependsOn)
+			if err := infra.resourceStore.Set(id, externalID); err != nil {
+				return output, &InfraError{ErrFailedResourceStoreSet, fmt.Errorf("ID: %s, created %s before failing with %v, Caused by %v ", id, *externalID, createErr.CausedBy, err)}
+			}
+			return output, createErr
 		}
-		//Pushes the resource to the resource stack, allowing for rollback in case of error
-		infra.resourceStack.Push(&ResourceState{
-			resourceDestroyer: resourceManager,
-			id:                id,
-		})
+		//Tracks the resource in the resource graph, allowing for rollback in case of error
+		infra.track(id, externalID, resourceManager, dependsOn)
 		//Set the externalID to the external resourceStore
 		if err := infra.resourceStore.Set(id, externalID); err != nil {
 			return output, &InfraError{ErrFailedResourceStoreSet, fmt.Errorf("ID: %s, Caused by %v ", id, err)}
@@ -195,24 +662,33 @@ func create[Input any, Output any](infra *Infra, id InternalID, input Input, res
 			return output, &InfraError{ErrFailedResourceStoreGet, fmt.Errorf("ID: %s, Caused by %v ", id, err)}
 		}
 		//Loads the resource using the last external ID
-		last, err := resourceManager.Load(lastID)
+		last, err := resourceManager.Load(ctx, lastID)
 		if err != nil {
 			return output, &InfraError{ErrFailedResourceManagerLoad, fmt.Errorf("ID: %s, Caused by %v ", id, err)}
 		}
 		//Updates the resource, merging the input with last element
 		//Sometimes update is not possible, then a deletion and creation may happen
 		//In that case externalID may change
-		externalID, updated, err := resourceManager.Update(input, last)
+		externalID, updated, err := resourceManager.Update(ctx, input, last)
 		if err != nil {
-			return output, &InfraError{ErrFailedResourceManagerUpdate, fmt.Errorf("ID: %s, Caused by %v ", id, err)}
+			updateErr := &InfraError{ErrFailedResourceManagerUpdate, fmt.Errorf("ID: %s, Caused by %v ", id, err)}
+			if externalID == nil || *externalID == "" || *externalID == *lastID {
+				return output, updateErr
+			}
+			//The replacement exists but wasn't completed, it is kept like a completed one
+			infra.track(id, externalID, resourceManager, dependsOn)
+			infra.retire(id, lastID, resourceManager)
+			if err := infra.resourceStore.Set(id, externalID); err != nil {
+				return output, &InfraError{ErrFailedResourceStoreSet, fmt.Errorf("ID: %s, replaced by %s before failing with %v, Caused by %v ", id, *externalID, updateErr.CausedBy, err)}
+			}
+			return output, updateErr
 		}
-		//Pushes the resource to the resource stack, allowing for rollback in case of error
-		infra.resourceStack.Push(&ResourceState{
-			resourceDestroyer: resourceManager,
-			id:                id,
-		})
+		//Tracks the resource in the resource graph, allowing for rollback in case of error
+		infra.track(id, externalID, resourceManager, dependsOn)
 		//Set the externalID to the external resourceStore only if it has changed
 		if *externalID != *lastID {
+			//The resource was replaced, the last one is destroyed by Prune
+			infra.retire(id, lastID, resourceManager)
 			if err := infra.resourceStore.Set(id, externalID); err != nil {
 				return output, &InfraError{ErrFailedResourceStoreSet, fmt.Errorf("ID: %s, Caused by %v ", id, err)}
 			}
@@ -221,31 +697,74 @@ func create[Input any, Output any](infra *Infra, id InternalID, input Input, res
 		outputID = externalID
 	}
 	//Store the new id into the localStore. this will prevent new creations with the same ID in the same execution
+	infra.mu.Lock()
 	infra.localStore[id] = outputID
+	infra.mu.Unlock()
 	return output, nil
 }
 
-// ResourceState represents the resource that was already processed
-type ResourceState struct {
-	resourceDestroyer ResourceDestroyer
-	id                InternalID
+// track adds a processed resource to the resource graph.
+// The externalID is kept in the localStore so the resource can be destroyed on rollback.
+func (i *Infra) track(id InternalID, externalID ExternalID, resourceDestroyer ResourceDestroyer, dependsOn []InternalID) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.resourceGraph.add(&resourceNode{
+		id:        id,
+		dependsOn: dependsOn,
+		destroyer: resourceDestroyer,
+	})
+	i.localStore[id] = externalID
 }
-type resourceStack []*ResourceState
 
-// Push adds an element to the top of the stack.
-func (s *resourceStack) Push(val *ResourceState) {
-	*s = append(*s, val)
+// retire remembers a replaced resource, so Prune can destroy it
+func (i *Infra) retire(id InternalID, externalID ExternalID, resourceDestroyer ResourceDestroyer) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.retired = append(i.retired, retiredResource{id, externalID, resourceDestroyer})
 }
 
-// Pop removes and returns the top element from the stack.
-func (s *resourceStack) Pop() (*ResourceState, error) {
-	if len(*s) == 0 {
-		return nil, fmt.Errorf("stack is empty")
+// lock acquires the resource store lock, when the store is a ResourceLocker, until the returned function is called.
+// Operations running at the same time, or nested in one another, share the lock: it is released by the last one.
+func (i *Infra) lock(ctx context.Context) (func() error, error) {
+	locker, ok := i.resourceStore.(ResourceLocker)
+	if !ok {
+		return func() error { return nil }, nil
 	}
-	lastIndex := len(*s) - 1
-	val := (*s)[lastIndex]
-	*s = (*s)[:lastIndex]
-	return val, nil
+	i.lockMu.Lock()
+	defer i.lockMu.Unlock()
+	if i.locks == 0 {
+		if err := locker.Lock(ctx); err != nil {
+			return nil, &InfraError{ErrFailedResourceStoreLock, err}
+		}
+	}
+	i.locks++
+	return func() error {
+		i.lockMu.Lock()
+		defer i.lockMu.Unlock()
+		i.locks--
+		if i.locks > 0 {
+			return nil
+		}
+		//Released even when ctx was cancelled, so other processes don't wait for the lease to expire
+		if err := locker.Unlock(context.WithoutCancel(ctx)); err != nil {
+			return &InfraError{ErrFailedResourceStoreUnlock, err}
+		}
+		return nil
+	}, nil
+}
+
+// retiredResource is a resource replaced by an update
+type retiredResource struct {
+	id         InternalID
+	externalID ExternalID
+	destroyer  ResourceDestroyer
+}
+
+// trackedIDs returns the ids of the resources in the resource graph
+func (i *Infra) trackedIDs() []InternalID {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.resourceGraph.ids()
 }
 
 // InfraError is the error generated by the infra package
@@ -281,6 +800,26 @@ func (e InfraError) Error() string {
 		return fmt.Sprintf("Failed to get resource from the store; %s", e.CausedBy)
 	case ErrFailedResourceStoreExists:
 		return fmt.Sprintf("Failed to check if resource exists in the store; %s", e.CausedBy)
+	case ErrDestroyInterrupted:
+		return fmt.Sprintf("Destroy was interrupted; %s", e.CausedBy)
+	case ErrApplyInterrupted:
+		return fmt.Sprintf("Apply was interrupted; %s", e.CausedBy)
+	case ErrPlanInterrupted:
+		return fmt.Sprintf("Plan was interrupted; %s", e.CausedBy)
+	case ErrDependencyCycle:
+		return fmt.Sprintf("Dependency cycle; %s", e.CausedBy)
+	case ErrUnknownDependency:
+		return fmt.Sprintf("Unknown dependency; %s", e.CausedBy)
+	case ErrFailedResourceInput:
+		return fmt.Sprintf("Failed to build resource input; %s", e.CausedBy)
+	case ErrFailedResourceDiff:
+		return fmt.Sprintf("Failed to compare resource with its input; %s", e.CausedBy)
+	case ErrResourceNotFound:
+		return fmt.Sprintf("The resource was never created; %s", e.CausedBy)
+	case ErrFailedResourceStoreLock:
+		return fmt.Sprintf("Failed to lock the resource store; %s", e.CausedBy)
+	case ErrFailedResourceStoreUnlock:
+		return fmt.Sprintf("Failed to unlock the resource store; %s", e.CausedBy)
 	default:
 		return "Unknown error"
 	}
@@ -311,4 +850,24 @@ const (
 	ErrFailedResourceStoreGet
 	//ErrFailedResourceStoreExists is the error code for failed resource store exists
 	ErrFailedResourceStoreExists
+	//ErrDestroyInterrupted is the error code for a destroy stopped by the context
+	ErrDestroyInterrupted
+	//ErrApplyInterrupted is the error code for an apply stopped by the context
+	ErrApplyInterrupted
+	//ErrDependencyCycle is the error code for resources depending on each other
+	ErrDependencyCycle
+	//ErrUnknownDependency is the error code for a dependency that was never declared nor created
+	ErrUnknownDependency
+	//ErrFailedResourceInput is the error code for a failed input function of a declared resource
+	ErrFailedResourceInput
+	//ErrFailedResourceDiff is the error code for a failed comparison between a resource and its input
+	ErrFailedResourceDiff
+	//ErrResourceNotFound is the error code for loading a resource missing from the resource store
+	ErrResourceNotFound
+	//ErrFailedResourceStoreLock is the error code for a resource store lock that could not be acquired
+	ErrFailedResourceStoreLock
+	//ErrFailedResourceStoreUnlock is the error code for a resource store lock that could not be released
+	ErrFailedResourceStoreUnlock
+	//ErrPlanInterrupted is the error code for a plan stopped by the context
+	ErrPlanInterrupted
 )
@@ -1,9 +1,12 @@
 package awsinfra
 
 import (
+	"context"
 	"fmt"
 	"reflect"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
@@ -12,7 +15,6 @@ import (
 	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
 	elbv2 "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
 	elbv2types "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
-	"github.com/aws/aws-sdk-go-v2/service/route53"
 	route53types "github.com/aws/aws-sdk-go-v2/service/route53/types"
 	"github.com/stretchr/testify/assert"
 )
@@ -22,16 +24,23 @@ type TResourceStore struct {
 	getErr    error
 	setErr    error
 	store     map[InternalID]ExternalID
+	mu        sync.Mutex
 }
 
 func (rs *TResourceStore) Exists(internalID InternalID) (bool, error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
 	_, ok := rs.store[internalID]
 	return ok, rs.existsErr
 }
 func (rs *TResourceStore) Get(internalID InternalID) (ExternalID, error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
 	return rs.store[internalID], rs.getErr
 }
 func (rs *TResourceStore) Set(internalID InternalID, externalID ExternalID) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
 	rs.store[internalID] = externalID
 	return rs.setErr
 }
@@ -48,53 +57,84 @@ type TResourceManager[I any, O any] struct {
 	updates    uint
 	loads      uint
 	deletes    uint
+	destroyed  []ExternalID
 }
 
-func (rm *TResourceManager[Input, Output]) Create(input Input) (ExternalID, Output, error) {
+func (rm *TResourceManager[Input, Output]) Create(ctx context.Context, input Input) (ExternalID, Output, error) {
 	rm.creates++
 	return rm.Eid, rm.Output, rm.CreateErr
 }
 
-func (rm *TResourceManager[Input, Output]) Update(input Input, last Output) (ExternalID, Output, error) {
+func (rm *TResourceManager[Input, Output]) Update(ctx context.Context, input Input, last Output) (ExternalID, Output, error) {
 	rm.updates++
 	return rm.Eid, rm.Output, rm.UpdateErr
 }
-func (rm *TResourceManager[Input, Output]) Load(id ExternalID) (Output, error) {
+func (rm *TResourceManager[Input, Output]) Load(ctx context.Context, id ExternalID) (Output, error) {
 	rm.loads++
 	return rm.Output, rm.LoadErr
 }
 
 // Destroy simulates a resource deletion
-func (rm *TResourceManager[Input, Output]) Destroy(id ExternalID) error {
+func (rm *TResourceManager[Input, Output]) Destroy(ctx context.Context, id ExternalID) error {
+	rm.deletes++
+	rm.destroyed = append(rm.destroyed, id)
 	return rm.DestroyErr
 }
 
 // TestProvider aggregates mocks for various resource creators and a resource store.
 type TestProvider struct {
-	vpc            TResourceManager[*ec2.CreateVpcInput, *ec2types.Vpc]
-	dns            TResourceManager[*route53.ChangeResourceRecordSetsInput, *route53types.ChangeInfo]
-	subnet         TResourceManager[*ec2.CreateSubnetInput, *ec2types.Subnet]
-	loadBalancer   TResourceManager[*elbv2.CreateLoadBalancerInput, []elbv2types.LoadBalancer]
-	launchTemplate TResourceManager[*ec2.CreateLaunchTemplateInput, *ec2types.LaunchTemplate]
-	autoScale      TResourceManager[*autoscaling.CreateAutoScalingGroupInput, *autoscalingtypes.AutoScalingGroup]
+	vpc            TResourceManager[*VPCInput, *ec2types.Vpc]
+	dns            TResourceManager[*DNSRecordInput, *route53types.ResourceRecordSet]
+	subnet         TResourceManager[*SubnetInput, *ec2types.Subnet]
+	securityGroup  TResourceManager[*SecurityGroupInput, *ec2types.SecurityGroup]
+	igw            TResourceManager[*InternetGatewayInput, *ec2types.InternetGateway]
+	nat            TResourceManager[*NatGatewayInput, *ec2types.NatGateway]
+	routeTable     TResourceManager[*RouteTableInput, *ec2types.RouteTable]
+	loadBalancer   TResourceManager[*LoadBalancerInput, *LoadBalancer]
+	targetGroup    TResourceManager[*TargetGroupInput, *TargetGroup]
+	listener       TResourceManager[*ListenerInput, *Listener]
+	listenerRule   TResourceManager[*ListenerRuleInput, *elbv2types.Rule]
+	launchTemplate TResourceManager[*LaunchTemplateInput, *ec2types.LaunchTemplate]
+	autoScale      TResourceManager[*AutoScalingGroupInput, *autoscalingtypes.AutoScalingGroup]
 }
 
-func (p *TestProvider) VPC() ResourceManager[*ec2.CreateVpcInput, *ec2types.Vpc] {
+func (p *TestProvider) VPC() ResourceManager[*VPCInput, *ec2types.Vpc] {
 	return &p.vpc
 }
-func (p *TestProvider) DNSRecordSet() ResourceManager[*route53.ChangeResourceRecordSetsInput, *route53types.ChangeInfo] {
+func (p *TestProvider) DNSRecordSet() ResourceManager[*DNSRecordInput, *route53types.ResourceRecordSet] {
 	return &p.dns
 }
-func (p *TestProvider) Subnet() ResourceManager[*ec2.CreateSubnetInput, *ec2types.Subnet] {
+func (p *TestProvider) Subnet() ResourceManager[*SubnetInput, *ec2types.Subnet] {
 	return &p.subnet
 }
-func (p *TestProvider) LoadBalancer() ResourceManager[*elbv2.CreateLoadBalancerInput, []elbv2types.LoadBalancer] {
+func (p *TestProvider) SecurityGroup() ResourceManager[*SecurityGroupInput, *ec2types.SecurityGroup] {
+	return &p.securityGroup
+}
+func (p *TestProvider) InternetGateway() ResourceManager[*InternetGatewayInput, *ec2types.InternetGateway] {
+	return &p.igw
+}
+func (p *TestProvider) NatGateway() ResourceManager[*NatGatewayInput, *ec2types.NatGateway] {
+	return &p.nat
+}
+func (p *TestProvider) RouteTable() ResourceManager[*RouteTableInput, *ec2types.RouteTable] {
+	return &p.routeTable
+}
+func (p *TestProvider) LoadBalancer() ResourceManager[*LoadBalancerInput, *LoadBalancer] {
 	return &p.loadBalancer
 }
-func (p *TestProvider) LaunchTemplate() ResourceManager[*ec2.CreateLaunchTemplateInput, *ec2types.LaunchTemplate] {
+func (p *TestProvider) TargetGroup() ResourceManager[*TargetGroupInput, *TargetGroup] {
+	return &p.targetGroup
+}
+func (p *TestProvider) Listener() ResourceManager[*ListenerInput, *Listener] {
+	return &p.listener
+}
+func (p *TestProvider) ListenerRule() ResourceManager[*ListenerRuleInput, *elbv2types.Rule] {
+	return &p.listenerRule
+}
+func (p *TestProvider) LaunchTemplate() ResourceManager[*LaunchTemplateInput, *ec2types.LaunchTemplate] {
 	return &p.launchTemplate
 }
-func (p *TestProvider) AutoScalingGroup() ResourceManager[*autoscaling.CreateAutoScalingGroupInput, *autoscalingtypes.AutoScalingGroup] {
+func (p *TestProvider) AutoScalingGroup() ResourceManager[*AutoScalingGroupInput, *autoscalingtypes.AutoScalingGroup] {
 	return &p.autoScale
 }
 
@@ -107,7 +147,14 @@ func TestResourceCreation(t *testing.T) {
 		VPCID            = "vpcid"
 		DNSID            = "dnsid"
 		SUBNETID         = "subnetid"
+		SGID             = "sgid"
+		IGWID            = "igwid"
+		NATID            = "natid"
+		ROUTETABLEID     = "routetableid"
 		LBID             = "ldbid"
+		TGID             = "tgid"
+		LISTENERID       = "listenerid"
+		RULEID           = "ruleid"
 		LAUNCHTEMPLATEID = "launchtemplateid"
 		AUTOSCALEID      = "autoscaleid"
 	)
@@ -115,7 +162,14 @@ func TestResourceCreation(t *testing.T) {
 		VPCID:            aws.String("vpceid"),
 		DNSID:            aws.String("dnseid"),
 		SUBNETID:         aws.String("subneteid"),
+		SGID:             aws.String("sgeid"),
+		IGWID:            aws.String("igweid"),
+		NATID:            aws.String("nateid"),
+		ROUTETABLEID:     aws.String("routetableeid"),
 		LBID:             aws.String("ldbeid"),
+		TGID:             aws.String("tgeid"),
+		LISTENERID:       aws.String("listenereid"),
+		RULEID:           aws.String("ruleeid"),
 		LAUNCHTEMPLATEID: aws.String("launchtemplateeid"),
 		AUTOSCALEID:      aws.String("autoscaleeid"),
 	}
@@ -123,27 +177,41 @@ func TestResourceCreation(t *testing.T) {
 		return expectedStore[id]
 	}
 	provider := &TestProvider{
-		vpc:            TResourceManager[*ec2.CreateVpcInput, *ec2types.Vpc]{Output: &ec2types.Vpc{}, Eid: eid(VPCID)},
-		dns:            TResourceManager[*route53.ChangeResourceRecordSetsInput, *route53types.ChangeInfo]{Output: &route53types.ChangeInfo{}, Eid: eid(DNSID)},
-		subnet:         TResourceManager[*ec2.CreateSubnetInput, *ec2types.Subnet]{Output: &ec2types.Subnet{}, Eid: eid(SUBNETID)},
-		loadBalancer:   TResourceManager[*elbv2.CreateLoadBalancerInput, []elbv2types.LoadBalancer]{Output: []elbv2types.LoadBalancer{}, Eid: eid(LBID)},
-		launchTemplate: TResourceManager[*ec2.CreateLaunchTemplateInput, *ec2types.LaunchTemplate]{Output: &ec2types.LaunchTemplate{}, Eid: eid(LAUNCHTEMPLATEID)},
-		autoScale:      TResourceManager[*autoscaling.CreateAutoScalingGroupInput, *autoscalingtypes.AutoScalingGroup]{Output: &autoscalingtypes.AutoScalingGroup{}, Eid: eid(AUTOSCALEID)},
+		vpc:            TResourceManager[*VPCInput, *ec2types.Vpc]{Output: &ec2types.Vpc{}, Eid: eid(VPCID)},
+		dns:            TResourceManager[*DNSRecordInput, *route53types.ResourceRecordSet]{Output: &route53types.ResourceRecordSet{}, Eid: eid(DNSID)},
+		subnet:         TResourceManager[*SubnetInput, *ec2types.Subnet]{Output: &ec2types.Subnet{}, Eid: eid(SUBNETID)},
+		securityGroup:  TResourceManager[*SecurityGroupInput, *ec2types.SecurityGroup]{Output: &ec2types.SecurityGroup{}, Eid: eid(SGID)},
+		igw:            TResourceManager[*InternetGatewayInput, *ec2types.InternetGateway]{Output: &ec2types.InternetGateway{}, Eid: eid(IGWID)},
+		nat:            TResourceManager[*NatGatewayInput, *ec2types.NatGateway]{Output: &ec2types.NatGateway{}, Eid: eid(NATID)},
+		routeTable:     TResourceManager[*RouteTableInput, *ec2types.RouteTable]{Output: &ec2types.RouteTable{}, Eid: eid(ROUTETABLEID)},
+		loadBalancer:   TResourceManager[*LoadBalancerInput, *LoadBalancer]{Output: &LoadBalancer{}, Eid: eid(LBID)},
+		targetGroup:    TResourceManager[*TargetGroupInput, *TargetGroup]{Output: &TargetGroup{}, Eid: eid(TGID)},
+		listener:       TResourceManager[*ListenerInput, *Listener]{Output: &Listener{}, Eid: eid(LISTENERID)},
+		listenerRule:   TResourceManager[*ListenerRuleInput, *elbv2types.Rule]{Output: &elbv2types.Rule{}, Eid: eid(RULEID)},
+		launchTemplate: TResourceManager[*LaunchTemplateInput, *ec2types.LaunchTemplate]{Output: &ec2types.LaunchTemplate{}, Eid: eid(LAUNCHTEMPLATEID)},
+		autoScale:      TResourceManager[*AutoScalingGroupInput, *autoscalingtypes.AutoScalingGroup]{Output: &autoscalingtypes.AutoScalingGroup{}, Eid: eid(AUTOSCALEID)},
 	}
 	store := &TResourceStore{
 		store: make(map[InternalID]ExternalID),
 	}
 	infra := New(provider, store, false)
-	testCreate(t, store, VPCID, eid(VPCID), &ec2types.Vpc{}, &ec2.CreateVpcInput{}, infra.CreateVPC)
-	testCreate(t, store, DNSID, eid(DNSID), &route53types.ChangeInfo{}, &route53.ChangeResourceRecordSetsInput{}, infra.CreateDNS)
-	testCreate(t, store, SUBNETID, eid(SUBNETID), &ec2types.Subnet{}, &ec2.CreateSubnetInput{}, infra.CreateSubnet)
-	testCreate(t, store, LBID, eid(LBID), []elbv2types.LoadBalancer{}, &elbv2.CreateLoadBalancerInput{}, infra.CreateLoadBalancer)
-	testCreate(t, store, LAUNCHTEMPLATEID, eid(LAUNCHTEMPLATEID), &ec2types.LaunchTemplate{}, &ec2.CreateLaunchTemplateInput{}, infra.CreateLaunchTemplate)
-	testCreate(t, store, AUTOSCALEID, eid(AUTOSCALEID), &autoscalingtypes.AutoScalingGroup{}, &autoscaling.CreateAutoScalingGroupInput{}, infra.CreateAutoScale)
+	testCreate(t, store, VPCID, eid(VPCID), &ec2types.Vpc{}, &VPCInput{CreateVpcInput: &ec2.CreateVpcInput{}}, infra.CreateVPC)
+	testCreate(t, store, DNSID, eid(DNSID), &route53types.ResourceRecordSet{}, &DNSRecordInput{ResourceRecordSet: &route53types.ResourceRecordSet{}}, infra.CreateDNS)
+	testCreate(t, store, SUBNETID, eid(SUBNETID), &ec2types.Subnet{}, &SubnetInput{CreateSubnetInput: &ec2.CreateSubnetInput{}}, infra.CreateSubnet)
+	testCreate(t, store, IGWID, eid(IGWID), &ec2types.InternetGateway{}, &InternetGatewayInput{CreateInternetGatewayInput: &ec2.CreateInternetGatewayInput{}}, infra.CreateInternetGateway)
+	testCreate(t, store, NATID, eid(NATID), &ec2types.NatGateway{}, &NatGatewayInput{CreateNatGatewayInput: &ec2.CreateNatGatewayInput{}}, infra.CreateNatGateway)
+	testCreate(t, store, ROUTETABLEID, eid(ROUTETABLEID), &ec2types.RouteTable{}, &RouteTableInput{CreateRouteTableInput: &ec2.CreateRouteTableInput{}}, infra.CreateRouteTable)
+	testCreate(t, store, SGID, eid(SGID), &ec2types.SecurityGroup{}, &SecurityGroupInput{CreateSecurityGroupInput: &ec2.CreateSecurityGroupInput{}}, infra.CreateSecurityGroup)
+	testCreate(t, store, LBID, eid(LBID), &LoadBalancer{}, &LoadBalancerInput{CreateLoadBalancerInput: &elbv2.CreateLoadBalancerInput{}}, infra.CreateLoadBalancer)
+	testCreate(t, store, TGID, eid(TGID), &TargetGroup{}, &TargetGroupInput{CreateTargetGroupInput: &elbv2.CreateTargetGroupInput{}}, infra.CreateTargetGroup)
+	testCreate(t, store, LISTENERID, eid(LISTENERID), &Listener{}, &ListenerInput{CreateListenerInput: &elbv2.CreateListenerInput{}}, infra.CreateListener)
+	testCreate(t, store, RULEID, eid(RULEID), &elbv2types.Rule{}, &ListenerRuleInput{CreateRuleInput: &elbv2.CreateRuleInput{}}, infra.CreateListenerRule)
+	testCreate(t, store, LAUNCHTEMPLATEID, eid(LAUNCHTEMPLATEID), &ec2types.LaunchTemplate{}, &LaunchTemplateInput{CreateLaunchTemplateInput: &ec2.CreateLaunchTemplateInput{}}, infra.CreateLaunchTemplate)
+	testCreate(t, store, AUTOSCALEID, eid(AUTOSCALEID), &autoscalingtypes.AutoScalingGroup{}, &AutoScalingGroupInput{CreateAutoScalingGroupInput: &autoscaling.CreateAutoScalingGroupInput{}}, infra.CreateAutoScale)
 }
 
-func testCreate[Input any, Output any](t *testing.T, store ResourceStore, id InternalID, expectedExternalID ExternalID, expectedOutput Output, input Input, create func(id InternalID, input Input) (Output, error)) {
-	output, err := create(id, input)
+func testCreate[Input any, Output any](t *testing.T, store ResourceStore, id InternalID, expectedExternalID ExternalID, expectedOutput Output, input Input, create func(ctx context.Context, id InternalID, input Input) (Output, error)) {
+	output, err := create(context.Background(), id, input)
 	externalID, _ := store.Get(id)
 	assert.Equal(t, externalID, expectedExternalID, "ID should match the expected value.")
 	assert.Equal(t, output, expectedOutput, "output should match the expected value.")
@@ -251,7 +319,6 @@ func Test_create(t *testing.T) {
 					resourceProvider: &TestProvider{},
 					resourceStore:    &TResourceStore{store: make(map[InternalID]ExternalID)},
 					localStore:       make(map[string]*string),
-					resourceStack:    resourceStack{},
 				},
 				id:    "",
 				input: "testInput",
@@ -274,7 +341,6 @@ func Test_create(t *testing.T) {
 					localStore: map[InternalID]ExternalID{
 						"testInternalID": aws.String("testExternalID"), //id is already in the local store
 					},
-					resourceStack: resourceStack{},
 				},
 				id:    "testInternalID",
 				input: "testInput",
@@ -297,8 +363,7 @@ func Test_create(t *testing.T) {
 						existsErr: fmt.Errorf("Exists error"),
 						store:     make(map[InternalID]ExternalID),
 					},
-					localStore:    make(map[string]*string),
-					resourceStack: resourceStack{},
+					localStore: make(map[string]*string),
 				},
 				id:    "testInternalID",
 				input: "testInput",
@@ -321,8 +386,7 @@ func Test_create(t *testing.T) {
 						existsErr: nil,
 						store:     make(map[InternalID]ExternalID),
 					},
-					localStore:    make(map[string]*string),
-					resourceStack: resourceStack{},
+					localStore: make(map[string]*string),
 				},
 				id:    "testInternalID",
 				input: "testInput",
@@ -347,8 +411,7 @@ func Test_create(t *testing.T) {
 						setErr:    fmt.Errorf("Something bad has happened"),
 						store:     make(map[InternalID]ExternalID),
 					},
-					localStore:    make(map[string]*string),
-					resourceStack: resourceStack{},
+					localStore: make(map[string]*string),
 				},
 				id:    "testInternalID",
 				input: "testInput",
@@ -376,8 +439,7 @@ func Test_create(t *testing.T) {
 							"testInternalID": aws.String("testExternalID"), //id is already in the external store
 						},
 					},
-					localStore:    make(map[string]*string),
-					resourceStack: resourceStack{},
+					localStore: make(map[string]*string),
 				},
 				id:    "testInternalID",
 				input: "testInput",
@@ -405,8 +467,7 @@ func Test_create(t *testing.T) {
 							"testInternalID": aws.String("testExternalID"), //id is already in the external store
 						},
 					},
-					localStore:    make(map[string]*string),
-					resourceStack: resourceStack{},
+					localStore: make(map[string]*string),
 				},
 				id:    "testInternalID",
 				input: "testInput",
@@ -435,8 +496,7 @@ func Test_create(t *testing.T) {
 							"testInternalID": aws.String("testExternalID"), //id is already in the external store
 						},
 					},
-					localStore:    make(map[string]*string),
-					resourceStack: resourceStack{},
+					localStore: make(map[string]*string),
 				},
 				id:    "testInternalID",
 				input: "testInput",
@@ -465,8 +525,7 @@ func Test_create(t *testing.T) {
 							"testInternalID": aws.String("testExternalID"), //id is already in the external store
 						},
 					},
-					localStore:    make(map[string]*string),
-					resourceStack: resourceStack{},
+					localStore: make(map[string]*string),
 				},
 				id:    "testInternalID",
 				input: "testInput",
@@ -495,8 +554,7 @@ func Test_create(t *testing.T) {
 							"testInternalID": aws.String("testExternalID"), //id is already in the external store
 						},
 					},
-					localStore:    make(map[string]*string),
-					resourceStack: resourceStack{},
+					localStore: make(map[string]*string),
 				},
 				id:    "testInternalID",
 				input: "testInput",
@@ -524,8 +582,7 @@ func Test_create(t *testing.T) {
 							"testInternalID": aws.String("testExternalID"), //id is already in the external store
 						},
 					},
-					localStore:    make(map[string]*string),
-					resourceStack: resourceStack{},
+					localStore: make(map[string]*string),
 				},
 				id:    "testInternalID",
 				input: "testInput",
@@ -551,8 +608,7 @@ func Test_create(t *testing.T) {
 						getErr:    nil,
 						store:     make(map[string]*string),
 					},
-					localStore:    make(map[string]*string),
-					resourceStack: resourceStack{},
+					localStore: make(map[string]*string),
 				},
 				id:    "testInternalID",
 				input: "testInput",
@@ -579,8 +635,7 @@ func Test_create(t *testing.T) {
 						getErr:    nil,
 						store:     make(map[string]*string),
 					},
-					localStore:    make(map[string]*string),
-					resourceStack: resourceStack{},
+					localStore: make(map[string]*string),
 				},
 				id:    "testInternalID",
 				input: "testInput",
@@ -599,7 +654,7 @@ func Test_create(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := createWithRollback(tt.args.infra, tt.args.id, tt.args.input, tt.args.resourceManager)
+			got, err := createWithRollback(context.Background(), tt.args.infra, tt.args.id, tt.args.input, tt.args.resourceManager)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("create() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -622,6 +677,500 @@ func Test_create(t *testing.T) {
 	}
 }
 
+func TestDestroyHonoursCancellation(t *testing.T) {
+	first := &TResourceManager[string, string]{}
+	second := &TResourceManager[string, string]{}
+	infra := &Infra{
+		defaultRollback:  false,
+		resourceProvider: &TestProvider{},
+		resourceStore:    &TResourceStore{store: make(map[InternalID]ExternalID)},
+		localStore: map[InternalID]ExternalID{
+			"first":  aws.String("firstExternalID"),
+			"second": aws.String("secondExternalID"),
+		},
+	}
+	infra.resourceGraph.add(&resourceNode{id: "first", destroyer: first})
+	infra.resourceGraph.add(&resourceNode{id: "second", destroyer: second, dependsOn: []InternalID{"first"}})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := infra.Destroy(ctx)
+	if assert.Error(t, err) {
+		assert.Equal(t, ErrDestroyInterrupted, err.(*InfraError).Code)
+		assert.ErrorIs(t, err.(*InfraError).CausedBy, context.Canceled)
+	}
+	assert.Equal(t, 2, infra.resourceGraph.len(), "No resource should be destroyed after cancellation")
+	assert.Equal(t, uint(0), first.deletes+second.deletes)
+
+	assert.Nil(t, infra.Destroy(context.Background()))
+	assert.Equal(t, 0, infra.resourceGraph.len())
+	assert.Equal(t, uint(1), first.deletes)
+	assert.Equal(t, uint(1), second.deletes)
+}
+
+// TRecorder records the calls made to TGraphManager instances
+type TRecorder struct {
+	mu         sync.Mutex
+	events     []string
+	running    int
+	maxRunning int
+}
+
+func (r *TRecorder) start(event string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+	r.running++
+	if r.running > r.maxRunning {
+		r.maxRunning = r.running
+	}
+}
+
+func (r *TRecorder) end() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.running--
+}
+
+func (r *TRecorder) index(event string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, e := range r.events {
+		if e == event {
+			return i
+		}
+	}
+	return -1
+}
+
+// TGraphManager is a resource manager recording the order of its calls
+type TGraphManager struct {
+	id        InternalID
+	recorder  *TRecorder
+	createErr error
+	wait      func()
+}
+
+func (rm *TGraphManager) Create(ctx context.Context, input string) (ExternalID, string, error) {
+	rm.recorder.start("create:" + rm.id)
+	defer rm.recorder.end()
+	if rm.wait != nil {
+		rm.wait()
+	}
+	return aws.String(rm.id + "-eid"), input, rm.createErr
+}
+func (rm *TGraphManager) Update(ctx context.Context, input string, last string) (ExternalID, string, error) {
+	return aws.String(rm.id + "-eid"), input, nil
+}
+func (rm *TGraphManager) Load(ctx context.Context, id ExternalID) (string, error) {
+	return "", nil
+}
+func (rm *TGraphManager) Destroy(ctx context.Context, id ExternalID) error {
+	rm.recorder.start("destroy:" + rm.id)
+	defer rm.recorder.end()
+	return nil
+}
+
+func newGraphInfra(rollback bool, workers int) *Infra {
+	return New(&TestProvider{}, &TResourceStore{store: make(map[InternalID]ExternalID)}, rollback, WithMaxWorkers(workers))
+}
+
+func constInput(input string) InputFunc[string] {
+	return func(ctx context.Context) (string, error) {
+		return input, nil
+	}
+}
+
+func TestApplyRespectsDependencies(t *testing.T) {
+	recorder := &TRecorder{}
+	infra := newGraphInfra(false, 2)
+	//both subnets must be running at the same time to release each other
+	var arrived sync.WaitGroup
+	arrived.Add(2)
+	released := make(chan struct{})
+	go func() {
+		arrived.Wait()
+		close(released)
+	}()
+	barrier := func() {
+		arrived.Done()
+		select {
+		case <-released:
+		case <-time.After(5 * time.Second):
+			t.Errorf("subnets were not created in parallel")
+		}
+	}
+	vpc, err := declare(infra, "vpc", constInput("10.0.0.0/16"), &TGraphManager{id: "vpc", recorder: recorder}, nil)
+	assert.Nil(t, err)
+	_, err = declare(infra, "subnet-a", func(ctx context.Context) (string, error) {
+		return vpc.OutputFor(ctx) + "/a", nil
+	}, &TGraphManager{id: "subnet-a", recorder: recorder, wait: barrier}, []InternalID{"vpc"})
+	assert.Nil(t, err)
+	subnetB, err := declare(infra, "subnet-b", func(ctx context.Context) (string, error) {
+		return vpc.OutputFor(ctx) + "/b", nil
+	}, &TGraphManager{id: "subnet-b", recorder: recorder, wait: barrier}, []InternalID{"vpc"})
+	assert.Nil(t, err)
+	_, err = declare(infra, "asg", constInput("asg"), &TGraphManager{id: "asg", recorder: recorder}, []InternalID{"subnet-a", "subnet-b"})
+	assert.Nil(t, err)
+
+	assert.Nil(t, infra.Apply(context.Background()))
+	assert.Equal(t, "10.0.0.0/16/b", subnetB.Output())
+	assert.Equal(t, 2, recorder.maxRunning)
+	assert.Equal(t, 0, recorder.index("create:vpc"))
+	assert.Equal(t, 3, recorder.index("create:asg"))
+	assert.Equal(t, aws.String("asg-eid"), infra.localStore["asg"])
+
+	assert.Nil(t, infra.Destroy(context.Background()))
+	assert.Equal(t, 4, recorder.index("destroy:asg"))
+	assert.Equal(t, 7, recorder.index("destroy:vpc"))
+	assert.Empty(t, infra.localStore)
+}
+
+func TestApplyHonoursMaxWorkers(t *testing.T) {
+	recorder := &TRecorder{}
+	infra := newGraphInfra(false, 1)
+	for _, id := range []InternalID{"a", "b", "c"} {
+		_, err := declare(infra, id, constInput(id), &TGraphManager{id: id, recorder: recorder, wait: func() { time.Sleep(time.Millisecond) }}, nil)
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, infra.Apply(context.Background()))
+	assert.Equal(t, 1, recorder.maxRunning)
+	assert.Equal(t, []string{"create:a", "create:b", "create:c"}, recorder.events)
+}
+
+func TestApplyValidatesTheGraph(t *testing.T) {
+	recorder := &TRecorder{}
+	infra := newGraphInfra(false, 2)
+	_, err := declare(infra, "a", constInput("a"), &TGraphManager{id: "a", recorder: recorder}, []InternalID{"b"})
+	assert.Nil(t, err)
+	_, err = declare(infra, "b", constInput("b"), &TGraphManager{id: "b", recorder: recorder}, []InternalID{"a"})
+	assert.Nil(t, err)
+	_, err = declare(infra, "a", constInput("a"), &TGraphManager{id: "a", recorder: recorder}, nil)
+	if assert.Error(t, err) {
+		assert.Equal(t, ErrResourceExists, err.(*InfraError).Code)
+	}
+	err = infra.Apply(context.Background())
+	if assert.Error(t, err) {
+		assert.Equal(t, ErrDependencyCycle, err.(*InfraError).Code)
+	}
+
+	_, err = declare(infra, "c", constInput("c"), &TGraphManager{id: "c", recorder: recorder}, []InternalID{"missing"})
+	assert.Nil(t, err)
+	err = infra.Apply(context.Background())
+	if assert.Error(t, err) {
+		assert.Equal(t, ErrUnknownDependency, err.(*InfraError).Code)
+	}
+	assert.Empty(t, recorder.events)
+}
+
+func TestApplyKeepsDeclarationsOfAnInvalidGraph(t *testing.T) {
+	recorder := &TRecorder{}
+	infra := newGraphInfra(false, 2)
+	_, err := declare(infra, "subnet", constInput("subnet"), &TGraphManager{id: "subnet", recorder: recorder}, []InternalID{"vpc"})
+	assert.Nil(t, err)
+	err = infra.Apply(context.Background())
+	if assert.Error(t, err) {
+		assert.Equal(t, ErrUnknownDependency, err.(*InfraError).Code)
+	}
+	_, err = declare(infra, "vpc", constInput("vpc"), &TGraphManager{id: "vpc", recorder: recorder}, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, infra.Apply(context.Background()))
+	assert.Equal(t, []string{"create:vpc", "create:subnet"}, recorder.events)
+}
+
+func TestApplyDependsOnCreatedResources(t *testing.T) {
+	recorder := &TRecorder{}
+	infra := newGraphInfra(false, 2)
+	_, err := createWithRollback(context.Background(), infra, "vpc", "vpc", ResourceManager[string, string](&TGraphManager{id: "vpc", recorder: recorder}))
+	assert.Nil(t, err)
+	_, err = declare(infra, "subnet", constInput("subnet"), &TGraphManager{id: "subnet", recorder: recorder}, []InternalID{"vpc"})
+	assert.Nil(t, err)
+	assert.Nil(t, infra.Apply(context.Background()))
+	assert.Nil(t, infra.Destroy(context.Background()))
+	assert.Equal(t, []string{"create:vpc", "create:subnet", "destroy:subnet", "destroy:vpc"}, recorder.events)
+}
+
+func TestApplyRollback(t *testing.T) {
+	recorder := &TRecorder{}
+	infra := newGraphInfra(true, 2)
+	_, err := declare(infra, "vpc", constInput("vpc"), &TGraphManager{id: "vpc", recorder: recorder}, nil)
+	assert.Nil(t, err)
+	_, err = declare(infra, "subnet", constInput("subnet"), &TGraphManager{id: "subnet", recorder: recorder}, []InternalID{"vpc"})
+	assert.Nil(t, err)
+	_, err = declare(infra, "asg", constInput("asg"), &TGraphManager{id: "asg", recorder: recorder, createErr: fmt.Errorf("Something bad has happened")}, []InternalID{"subnet"})
+	assert.Nil(t, err)
+	_, err = declare(infra, "never", func(ctx context.Context) (string, error) {
+		return "", fmt.Errorf("should not be called")
+	}, &TGraphManager{id: "never", recorder: recorder}, []InternalID{"asg"})
+	assert.Nil(t, err)
+
+	err = infra.Apply(context.Background())
+	if assert.Error(t, err) {
+		assert.Equal(t, ErrFailedResourceManagerCreate, err.(*InfraError).Code)
+	}
+	assert.Equal(t, []string{"create:vpc", "create:subnet", "create:asg", "destroy:asg", "destroy:subnet", "destroy:vpc"}, recorder.events, "The asg was created before failing")
+	assert.Empty(t, infra.localStore)
+}
+
+func TestRollbackOfACancelledCreation(t *testing.T) {
+	recorder := &TRecorder{}
+	infra := newGraphInfra(true, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err := declare(infra, "vpc", constInput("vpc"), &TGraphManager{id: "vpc", recorder: recorder}, nil)
+	assert.Nil(t, err)
+	_, err = declare(infra, "subnet", constInput("subnet"), &TGraphManager{id: "subnet", recorder: recorder, wait: cancel}, []InternalID{"vpc"})
+	assert.Nil(t, err)
+	_, err = declare(infra, "asg", constInput("asg"), &TGraphManager{id: "asg", recorder: recorder}, []InternalID{"subnet"})
+	assert.Nil(t, err)
+
+	err = infra.Apply(ctx)
+	if assert.Error(t, err) {
+		assert.Equal(t, ErrApplyInterrupted, err.(*InfraError).Code)
+	}
+	assert.Equal(t, []string{"create:vpc", "create:subnet", "destroy:subnet", "destroy:vpc"}, recorder.events, "The rollback outlives the cancelled context")
+	assert.Empty(t, infra.localStore)
+
+	recorder = &TRecorder{}
+	infra = newGraphInfra(true, 1)
+	ctx, cancel = context.WithCancel(context.Background())
+	_, err = createWithRollback(ctx, infra, "vpc", "vpc", ResourceManager[string, string](&TGraphManager{id: "vpc", recorder: recorder}))
+	assert.Nil(t, err)
+	_, err = createWithRollback(ctx, infra, "subnet", "subnet", ResourceManager[string, string](&TGraphManager{id: "subnet", recorder: recorder, wait: cancel, createErr: context.Canceled}))
+	assert.Error(t, err)
+	assert.Equal(t, []string{"create:vpc", "create:subnet", "destroy:subnet", "destroy:vpc"}, recorder.events)
+	assert.Empty(t, infra.localStore)
+}
+
+func TestApplyFailedInput(t *testing.T) {
+	infra := newGraphInfra(false, 2)
+	_, err := declare(infra, "vpc", func(ctx context.Context) (string, error) {
+		return "", fmt.Errorf("Something bad has happened")
+	}, &TGraphManager{id: "vpc", recorder: &TRecorder{}}, nil)
+	assert.Nil(t, err)
+	err = infra.Apply(context.Background())
+	if assert.Error(t, err) {
+		assert.Equal(t, ErrFailedResourceInput, err.(*InfraError).Code)
+	}
+}
+
+func TestApplyPrunesReplacedResources(t *testing.T) {
+	store := &TResourceStore{store: map[InternalID]ExternalID{"vpc": aws.String("oldExternalID")}}
+	infra := New(&TestProvider{}, store, false)
+	rm := &TResourceManager[string, string]{Output: "testOutput", Eid: aws.String("newExternalID")}
+	_, err := declare(infra, "vpc", constInput("vpc"), ResourceManager[string, string](rm), nil)
+	assert.Nil(t, err)
+	assert.Nil(t, infra.Apply(context.Background()))
+	assert.Equal(t, []ExternalID{aws.String("oldExternalID")}, rm.destroyed)
+	assert.Equal(t, aws.String("newExternalID"), store.store["vpc"])
+	assert.Empty(t, infra.retired)
+}
+
+func TestPruneAfterCreate(t *testing.T) {
+	store := &TResourceStore{store: map[InternalID]ExternalID{
+		"vpc":    aws.String("oldVPC"),
+		"subnet": aws.String("oldSubnet"),
+	}}
+	infra := New(&TestProvider{}, store, false)
+	vpc := &TResourceManager[string, string]{Eid: aws.String("newVPC")}
+	subnet := &TResourceManager[string, string]{Eid: aws.String("newSubnet"), DestroyErr: fmt.Errorf("Something bad has happened")}
+	_, err := createWithRollback(context.Background(), infra, "vpc", "vpc", ResourceManager[string, string](vpc))
+	assert.Nil(t, err)
+	_, err = createWithRollback(context.Background(), infra, "subnet", "subnet", ResourceManager[string, string](subnet))
+	assert.Nil(t, err)
+	assert.Equal(t, uint(0), vpc.deletes+subnet.deletes, "Replaced resources are only destroyed by Prune")
+
+	err = infra.Prune(context.Background())
+	if assert.Error(t, err) {
+		assert.Equal(t, ErrFailedResourceManagerDestroy, err.(*InfraError).Code)
+	}
+	assert.Equal(t, uint(0), vpc.deletes, "The VPC must outlive the replaced subnet")
+	assert.Len(t, infra.retired, 2)
+
+	subnet.DestroyErr = nil
+	assert.Nil(t, infra.Prune(context.Background()))
+	assert.Equal(t, []ExternalID{aws.String("oldVPC")}, vpc.destroyed)
+	assert.Empty(t, infra.retired)
+}
+
+// TLockingStore is a resource store recording how it is locked
+type TLockingStore struct {
+	TResourceStore
+	locked    bool
+	locks     int
+	lockErr   error
+	unlockErr error
+	unlocked  []InternalID //ids set while the store was not locked
+}
+
+func (rs *TLockingStore) Lock(ctx context.Context) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.lockErr != nil {
+		return rs.lockErr
+	}
+	rs.locked = true
+	rs.locks++
+	return nil
+}
+func (rs *TLockingStore) Unlock(ctx context.Context) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.locked = false
+	return rs.unlockErr
+}
+func (rs *TLockingStore) Set(internalID InternalID, externalID ExternalID) error {
+	rs.mu.Lock()
+	if !rs.locked {
+		rs.unlocked = append(rs.unlocked, internalID)
+	}
+	rs.mu.Unlock()
+	return rs.TResourceStore.Set(internalID, externalID)
+}
+
+func TestApplyLocksTheResourceStore(t *testing.T) {
+	recorder := &TRecorder{}
+	store := &TLockingStore{TResourceStore: TResourceStore{store: make(map[InternalID]ExternalID)}}
+	infra := New(&TestProvider{}, store, true, WithMaxWorkers(2))
+	for _, id := range []InternalID{"a", "b", "c"} {
+		_, err := declare(infra, id, constInput(id), &TGraphManager{id: id, recorder: recorder}, nil)
+		assert.Nil(t, err)
+	}
+	_, err := declare(infra, "d", constInput("d"), &TGraphManager{id: "d", recorder: recorder, createErr: fmt.Errorf("Something bad has happened")}, []InternalID{"a"})
+	assert.Nil(t, err)
+	err = infra.Apply(context.Background())
+	if assert.Error(t, err) {
+		assert.Equal(t, ErrFailedResourceManagerCreate, err.(*InfraError).Code)
+	}
+	assert.Equal(t, 1, store.locks, "The rollback shares the lock of Apply")
+	assert.False(t, store.locked)
+	assert.Empty(t, store.unlocked)
+
+	_, err = createWithRollback(context.Background(), infra, "vpc", "vpc", ResourceManager[string, string](&TGraphManager{id: "vpc", recorder: recorder}))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, store.locks)
+	assert.Empty(t, store.unlocked)
+
+	store.lockErr = fmt.Errorf("locked by someone else")
+	_, err = createWithRollback(context.Background(), infra, "subnet", "subnet", ResourceManager[string, string](&TGraphManager{id: "subnet", recorder: recorder}))
+	if assert.Error(t, err) {
+		assert.Equal(t, ErrFailedResourceStoreLock, err.(*InfraError).Code)
+	}
+	assert.Equal(t, -1, recorder.index("create:subnet"), "Nothing is created without the lock")
+
+	store.lockErr, store.unlockErr = nil, fmt.Errorf("lock lost")
+	err = infra.Destroy(context.Background())
+	if assert.Error(t, err) {
+		assert.Equal(t, ErrFailedResourceStoreUnlock, err.(*InfraError).Code)
+	}
+	assert.Equal(t, 0, infra.locks)
+}
+
+func TestCreateKeepsPartiallyCreatedResources(t *testing.T) {
+	store := &TResourceStore{store: map[InternalID]ExternalID{"subnet": aws.String("oldSubnet")}}
+	infra := New(&TestProvider{}, store, false)
+	vpc := &TResourceManager[string, string]{Eid: aws.String("vpc-0a1b2c"), CreateErr: fmt.Errorf("timed out waiting for the vpc")}
+	_, err := createWithRollback(context.Background(), infra, "vpc", "vpc", ResourceManager[string, string](vpc))
+	if assert.Error(t, err) {
+		assert.Equal(t, ErrFailedResourceManagerCreate, err.(*InfraError).Code)
+	}
+	assert.Equal(t, aws.String("vpc-0a1b2c"), store.store["vpc"], "The next execution must find the vpc")
+
+	subnet := &TResourceManager[string, string]{Eid: aws.String("newSubnet"), UpdateErr: fmt.Errorf("Something bad has happened")}
+	_, err = createWithRollback(context.Background(), infra, "subnet", "subnet", ResourceManager[string, string](subnet))
+	if assert.Error(t, err) {
+		assert.Equal(t, ErrFailedResourceManagerUpdate, err.(*InfraError).Code)
+	}
+	assert.Equal(t, aws.String("newSubnet"), store.store["subnet"])
+	assert.Len(t, infra.retired, 1)
+
+	assert.Nil(t, infra.Destroy(context.Background()))
+	assert.Equal(t, []ExternalID{aws.String("vpc-0a1b2c")}, vpc.destroyed)
+	assert.Equal(t, []ExternalID{aws.String("newSubnet")}, subnet.destroyed)
+
+	dns := &TResourceManager[string, string]{Eid: aws.String(""), CreateErr: fmt.Errorf("Something bad has happened")}
+	_, err = createWithRollback(context.Background(), infra, "dns", "dns", ResourceManager[string, string](dns))
+	assert.Error(t, err)
+	_, stored := store.store["dns"]
+	assert.False(t, stored, "A blank ExternalID means nothing was created")
+
+	rollback := New(&TestProvider{}, &TResourceStore{store: make(map[InternalID]ExternalID)}, true)
+	lb := &TResourceManager[string, string]{Eid: aws.String("arn:lb"), CreateErr: fmt.Errorf("failed to set the attributes")}
+	_, err = createWithRollback(context.Background(), rollback, "lb", "lb", ResourceManager[string, string](lb))
+	assert.Error(t, err)
+	assert.Equal(t, []ExternalID{aws.String("arn:lb")}, lb.destroyed, "Rollback destroys the partially created resource")
+}
+
+func TestResolveSecurityGroups(t *testing.T) {
+	store := &TResourceStore{store: map[InternalID]ExternalID{
+		"db":  aws.String("sg-db"),
+		"web": aws.String("sg-web-old"),
+	}}
+	infra := New(&TestProvider{}, store, false)
+	infra.localStore["web"] = aws.String("sg-web") //replaced in this execution
+	input := &SecurityGroupInput{
+		Ingress: []SecurityGroupRule{
+			{IpProtocol: "tcp", FromPort: 5432, ToPort: 5432, SecurityGroups: []InternalID{"web", "app"}, SecurityGroupIds: []string{"sg-bastion"}},
+		},
+		Egress: []SecurityGroupRule{
+			{IpProtocol: "-1", SecurityGroups: []InternalID{"db"}},
+		},
+	}
+	resolved, err := infra.resolveSecurityGroups("app", input)
+	assert.Nil(t, err)
+	assert.Equal(t, []SecurityGroupRule{
+		{IpProtocol: "tcp", FromPort: 5432, ToPort: 5432, SecurityGroupIds: []string{"sg-bastion", "sg-web"}, Self: true},
+	}, resolved.Ingress)
+	assert.Equal(t, []SecurityGroupRule{{IpProtocol: "-1", SecurityGroupIds: []string{"sg-db"}}}, resolved.Egress)
+	assert.Equal(t, []string{"sg-bastion"}, input.Ingress[0].SecurityGroupIds, "The input must not be modified")
+
+	input.Egress[0].SecurityGroups = []InternalID{"cache"}
+	_, err = infra.resolveSecurityGroups("app", input)
+	assert.Equal(t, ErrUnknownDependency, err.(*InfraError).Code)
+
+	_, err = infra.CreateSecurityGroup(context.Background(), "app", input)
+	assert.Equal(t, ErrUnknownDependency, err.(*InfraError).Code)
+}
+
+func TestSubnetAssociationsHaveOneOwner(t *testing.T) {
+	store := &TResourceStore{store: map[InternalID]ExternalID{
+		"private": aws.String("subnet-1"),
+		"public":  aws.String("subnet-2"),
+	}}
+	infra := New(&TestProvider{}, store, false)
+	assert.Nil(t, infra.claimSubnets("public-rt", &RouteTableInput{SubnetIds: []string{"subnet-2"}}))
+	assert.Nil(t, infra.claimRouteTable("private", &SubnetInput{RouteTableId: aws.String("rtb-1")}))
+	assert.Nil(t, infra.claimRouteTable("public", &SubnetInput{}), "A Subnet leaving its RouteTableId nil can be associated by a RouteTable")
+
+	err := infra.claimSubnets("private-rt", &RouteTableInput{SubnetIds: []string{"subnet-3", "subnet-1"}})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "subnet subnet-1 (private) sets its RouteTableId")
+	}
+	_, err = infra.CreateSubnet(context.Background(), "public", &SubnetInput{RouteTableId: aws.String("rtb-1")})
+	if assert.Error(t, err) {
+		assert.Equal(t, ErrFailedResourceInput, err.(*InfraError).Code)
+		assert.Contains(t, err.Error(), "is in the SubnetIds of public-rt")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	store := &TResourceStore{store: map[InternalID]ExternalID{"lb": aws.String("arn")}}
+	infra := New(&TestProvider{}, store, false)
+	lb := &TResourceManager[string, string]{Output: "loaded"}
+	output, err := load(context.Background(), infra, "lb", ResourceManager[string, string](lb))
+	assert.Nil(t, err)
+	assert.Equal(t, "loaded", output)
+	assert.Equal(t, uint(0), lb.creates+lb.updates, "Load must not change the resource")
+
+	_, err = load(context.Background(), infra, "missing", ResourceManager[string, string](lb))
+	if assert.Error(t, err) {
+		assert.Equal(t, ErrResourceNotFound, err.(*InfraError).Code)
+	}
+	lb.LoadErr = fmt.Errorf("Something bad has happened")
+	_, err = load(context.Background(), infra, "lb", ResourceManager[string, string](lb))
+	if assert.Error(t, err) {
+		assert.Equal(t, ErrFailedResourceManagerLoad, err.(*InfraError).Code)
+	}
+}
+
 func TestDefaultErrorMsg(t *testing.T) {
 	err := &InfraError{
 		Code:     32187128709,
@@ -0,0 +1,192 @@
+package awsinfra
+
+import (
+	"context"
+	"fmt"
+)
+
+// resourceNode is a resource in the dependency graph
+type resourceNode struct {
+	id        InternalID
+	dependsOn []InternalID
+	destroyer ResourceDestroyer
+	apply     func(ctx context.Context) error                                         //creates or updates a declared resource, nil for tracked resources
+	plan      func(ctx context.Context, unknown []InternalID) (ResourceChange, error) //previews apply, nil for tracked resources
+}
+
+// resourceGraph keeps resources and the dependencies between them.
+// The zero value is an empty graph ready to use.
+type resourceGraph struct {
+	nodes map[InternalID]*resourceNode
+	order []InternalID //insertion order, keeps every walk deterministic
+}
+
+func (g *resourceGraph) add(n *resourceNode) {
+	if g.nodes == nil {
+		g.nodes = make(map[InternalID]*resourceNode)
+	}
+	if _, exists := g.nodes[n.id]; !exists {
+		g.order = append(g.order, n.id)
+	}
+	g.nodes[n.id] = n
+}
+
+func (g *resourceGraph) remove(id InternalID) {
+	if _, exists := g.nodes[id]; !exists {
+		return
+	}
+	delete(g.nodes, id)
+	for i, v := range g.order {
+		if v == id {
+			g.order = append(g.order[:i], g.order[i+1:]...)
+			break
+		}
+	}
+}
+
+func (g *resourceGraph) has(id InternalID) bool {
+	_, exists := g.nodes[id]
+	return exists
+}
+
+func (g *resourceGraph) len() int {
+	return len(g.order)
+}
+
+// ids returns a copy of the graph ids in insertion order
+func (g *resourceGraph) ids() []InternalID {
+	return append([]InternalID{}, g.order...)
+}
+
+// dependencies maps every id to the ids it depends on, ignoring ids outside the graph
+func (g *resourceGraph) dependencies() map[InternalID][]InternalID {
+	deps := make(map[InternalID][]InternalID, len(g.order))
+	for _, id := range g.order {
+		for _, dep := range g.nodes[id].dependsOn {
+			if g.has(dep) {
+				deps[id] = append(deps[id], dep)
+			}
+		}
+	}
+	return deps
+}
+
+// dependents maps every id to the ids that depend on it
+func (g *resourceGraph) dependents() map[InternalID][]InternalID {
+	dependents := make(map[InternalID][]InternalID, len(g.order))
+	deps := g.dependencies()
+	for _, id := range g.order {
+		for _, dep := range deps[id] {
+			dependents[dep] = append(dependents[dep], id)
+		}
+	}
+	return dependents
+}
+
+// topologicalOrder returns the ids sorted so every resource comes after its dependencies.
+// Ties are resolved by insertion order. It fails when the dependencies have a cycle.
+func (g *resourceGraph) topologicalOrder() ([]InternalID, error) {
+	deps := g.dependencies()
+	dependents := g.dependents()
+	remaining := make(map[InternalID]int, len(g.order))
+	var ready []InternalID
+	for _, id := range g.order {
+		remaining[id] = len(deps[id])
+		if remaining[id] == 0 {
+			ready = append(ready, id)
+		}
+	}
+	sorted := make([]InternalID, 0, len(g.order))
+	for len(ready) > 0 {
+		id := ready[0]
+		ready = ready[1:]
+		sorted = append(sorted, id)
+		for _, dependent := range dependents[id] {
+			remaining[dependent]--
+			if remaining[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+	if len(sorted) != len(g.order) {
+		var cycle []InternalID
+		for _, id := range g.order {
+			if remaining[id] > 0 {
+				cycle = append(cycle, id)
+			}
+		}
+		return nil, fmt.Errorf("resources %v have cyclic dependencies", cycle)
+	}
+	return sorted, nil
+}
+
+// walk calls fn for every id once all the ids in after[id] have completed successfully,
+// running at most workers calls at the same time. ids must be topologically sorted.
+// After the first failure, or once ctx is done, no new call is started; walk waits for
+// the running calls and returns the first error, or the context error.
+func walk(ctx context.Context, ids []InternalID, after map[InternalID][]InternalID, workers int, fn func(ctx context.Context, id InternalID) error) error {
+	if workers < 1 {
+		workers = 1
+	}
+	type result struct {
+		id  InternalID
+		err error
+	}
+	remaining := make(map[InternalID]int, len(ids))
+	waiters := make(map[InternalID][]InternalID, len(ids))
+	for _, id := range ids {
+		remaining[id] = 0
+	}
+	for _, id := range ids {
+		for _, prerequisite := range after[id] {
+			if _, ok := remaining[prerequisite]; ok {
+				remaining[id]++
+				waiters[prerequisite] = append(waiters[prerequisite], id)
+			}
+		}
+	}
+	var ready []InternalID
+	for _, id := range ids {
+		if remaining[id] == 0 {
+			ready = append(ready, id)
+		}
+	}
+	done := make(chan result)
+	running, completed := 0, 0
+	var firstErr error
+	for {
+		for firstErr == nil && ctx.Err() == nil && len(ready) > 0 && running < workers {
+			id := ready[0]
+			ready = ready[1:]
+			running++
+			go func(id InternalID) {
+				done <- result{id, fn(ctx, id)}
+			}(id)
+		}
+		if running == 0 {
+			break
+		}
+		r := <-done
+		running--
+		if r.err != nil {
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			continue
+		}
+		completed++
+		for _, waiter := range waiters[r.id] {
+			remaining[waiter]--
+			if remaining[waiter] == 0 {
+				ready = append(ready, waiter)
+			}
+		}
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+	if completed != len(ids) {
+		return ctx.Err()
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package awsinfra
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTopologicalOrder(t *testing.T) {
+	g := resourceGraph{}
+	g.add(&resourceNode{id: "asg", dependsOn: []InternalID{"subnet", "template"}})
+	g.add(&resourceNode{id: "subnet", dependsOn: []InternalID{"vpc"}})
+	g.add(&resourceNode{id: "template"})
+	g.add(&resourceNode{id: "vpc", dependsOn: []InternalID{"outside"}})
+	order, err := g.topologicalOrder()
+	assert.Nil(t, err)
+	assert.Equal(t, []InternalID{"template", "vpc", "subnet", "asg"}, order)
+
+	g.remove("template")
+	order, err = g.topologicalOrder()
+	assert.Nil(t, err)
+	assert.Equal(t, []InternalID{"vpc", "subnet", "asg"}, order)
+
+	g.add(&resourceNode{id: "vpc", dependsOn: []InternalID{"asg"}})
+	_, err = g.topologicalOrder()
+	assert.Error(t, err)
+}
+
+func TestWalkStopsAfterFailure(t *testing.T) {
+	var called []InternalID
+	after := map[InternalID][]InternalID{"b": {"a"}, "c": {"b"}}
+	err := walk(context.Background(), []InternalID{"a", "b", "c"}, after, 1, func(ctx context.Context, id InternalID) error {
+		called = append(called, id)
+		if id == "b" {
+			return fmt.Errorf("failed %s", id)
+		}
+		return nil
+	})
+	assert.EqualError(t, err, "failed b")
+	assert.Equal(t, []InternalID{"a", "b"}, called)
+}
+
+func TestWalkHonoursCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var called []InternalID
+	err := walk(ctx, []InternalID{"a", "b"}, nil, 1, func(ctx context.Context, id InternalID) error {
+		called = append(called, id)
+		cancel()
+		return nil
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, []InternalID{"a"}, called)
+}
@@ -0,0 +1,190 @@
+package awsinfra
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	elbv2 "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	route53types "github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+// VPCInput is the desired state of a VPC.
+// It extends ec2.CreateVpcInput with the attributes that can only be set once the VPC exists.
+// The IPv6 block is left untouched unless AmazonProvidedIpv6CidrBlock, Ipv6Pool or Ipv6IpamPoolId is set;
+// AmazonProvidedIpv6CidrBlock set to false alone removes it.
+type VPCInput struct {
+	*ec2.CreateVpcInput
+	EnableDnsSupport   *bool //nil leaves the attribute untouched
+	EnableDnsHostnames *bool //nil leaves the attribute untouched
+	//SecondaryCidrBlocks are the IPv4 CIDR blocks associated besides the primary one.
+	//nil leaves the associations untouched, an empty slice removes all of them.
+	SecondaryCidrBlocks []string
+}
+
+// SubnetInput is the desired state of a Subnet.
+// It extends ec2.CreateSubnetInput with the attributes that can only be set once the Subnet exists.
+// The IPv6 block is left untouched unless Ipv6CidrBlock is set.
+type SubnetInput struct {
+	*ec2.CreateSubnetInput
+	MapPublicIpOnLaunch         *bool //nil leaves the attribute untouched
+	AssignIpv6AddressOnCreation *bool //nil leaves the attribute untouched
+	//RouteTableId is the route table explicitly associated with the Subnet.
+	//nil leaves the association untouched, an empty string falls back to the main route table.
+	//It excludes RouteTableInput.SubnetIds: a Subnet setting it can't be associated by a RouteTable too.
+	RouteTableId *string
+}
+
+// InternetGatewayInput is the desired state of an InternetGateway.
+type InternetGatewayInput struct {
+	*ec2.CreateInternetGatewayInput
+	//VpcId is the VPC the gateway is attached to.
+	//nil leaves the attachment untouched, an empty string detaches the gateway.
+	VpcId *string
+}
+
+// NatGatewayInput is the desired state of a NatGateway.
+// A public NatGateway without AllocationId gets an Elastic IP allocated on creation,
+// which is released when the NatGateway is destroyed.
+type NatGatewayInput struct {
+	*ec2.CreateNatGatewayInput
+	//Timeout is the maximum time to wait for the NatGateway to be available, 0 waits up to 10 minutes
+	Timeout time.Duration
+}
+
+// RouteTableInput is the desired state of a RouteTable.
+type RouteTableInput struct {
+	*ec2.CreateRouteTableInput
+	//Routes are the routes besides the local route of the VPC.
+	//nil leaves them untouched, an empty slice deletes all of them.
+	Routes []Route
+	//SubnetIds are the Subnets explicitly associated with the RouteTable, moved from their current one if needed.
+	//nil leaves the associations untouched, an empty slice removes all of them.
+	//It excludes SubnetInput.RouteTableId: a Subnet setting it can't be listed too.
+	SubnetIds []string
+}
+
+// Route sends the traffic to one destination through one target.
+// Exactly one destination and one target must be set.
+type Route struct {
+	DestinationCidrBlock        *string
+	DestinationIpv6CidrBlock    *string
+	DestinationPrefixListId     *string
+	GatewayId                   *string //internet or virtual private gateway
+	NatGatewayId                *string
+	EgressOnlyInternetGatewayId *string
+	TransitGatewayId            *string
+	VpcPeeringConnectionId      *string
+	NetworkInterfaceId          *string
+}
+
+// SecurityGroupInput is the desired state of a SecurityGroup.
+// Rules are reconciled one by one on update, only the missing ones are authorized
+// and only the extra ones revoked, so the traffic they allow isn't interrupted.
+// A change of Description or VpcId creates a new group before the last one is deleted,
+// so staying in the same VPC it needs a new GroupName, group names being unique in a VPC.
+type SecurityGroupInput struct {
+	*ec2.CreateSecurityGroupInput
+	//Ingress are the rules allowing inbound traffic.
+	//nil leaves them untouched, an empty slice revokes all of them.
+	Ingress []SecurityGroupRule
+	//Egress are the rules allowing outbound traffic.
+	//nil leaves them untouched, including the allow all rule of new groups.
+	Egress []SecurityGroupRule
+}
+
+// SecurityGroupRule allows the traffic of a protocol and port range from, or to, a set of peers.
+// Each peer is a separate rule of the SecurityGroup.
+type SecurityGroupRule struct {
+	IpProtocol    string //tcp, udp, icmp, icmpv6 or -1 for all the traffic
+	FromPort      int32  //first port, or the ICMP type. Ignored for -1
+	ToPort        int32  //last port, or the ICMP code. Ignored for -1
+	Description   string
+	CidrIpv4      []string
+	CidrIpv6      []string
+	PrefixListIds []string
+	//SecurityGroups are the InternalIDs of the managed security groups allowed.
+	//Infra resolves them into SecurityGroupIds, so they must be created or applied first.
+	SecurityGroups   []InternalID
+	SecurityGroupIds []string //ids of the security groups allowed
+	Self             bool     //allows the security group itself
+}
+
+// LaunchTemplateInput is the desired state of a LaunchTemplate.
+// Updates to LaunchTemplateData are applied as new versions of the same template.
+type LaunchTemplateInput struct {
+	*ec2.CreateLaunchTemplateInput
+	SetDefaultVersion bool  //makes each new version the default one
+	KeepVersions      int32 //number of latest versions kept on update, 0 keeps all of them
+}
+
+// AutoScalingGroupInput is the desired state of an AutoScalingGroup.
+type AutoScalingGroupInput struct {
+	*autoscaling.CreateAutoScalingGroupInput
+	//InstanceRefresh replaces the running instances when the launch template changes.
+	//nil leaves them running, only new instances use the new launch template.
+	InstanceRefresh *InstanceRefresh
+}
+
+// InstanceRefresh configures the replacement of the instances of an AutoScalingGroup
+type InstanceRefresh struct {
+	MinHealthyPercentage *int32 //nil uses the AWS default of 90
+	InstanceWarmup       *int32 //seconds, nil uses the default instance warmup of the group
+	//AutoRollback restores the previous launch template when the refresh fails
+	AutoRollback bool
+	//Timeout is the maximum time to wait for the refresh, 0 waits up to 30 minutes
+	Timeout time.Duration
+}
+
+// Load balancer attributes commonly set in LoadBalancerInput.Attributes
+const (
+	LoadBalancerIdleTimeout        = "idle_timeout.timeout_seconds"
+	LoadBalancerDeletionProtection = "deletion_protection.enabled"
+)
+
+// LoadBalancerInput is the desired state of a LoadBalancer.
+type LoadBalancerInput struct {
+	*elbv2.CreateLoadBalancerInput
+	//Attributes are the load balancer attributes to set, such as LoadBalancerIdleTimeout.
+	//Attributes left out keep their current value.
+	Attributes map[string]string
+}
+
+// Target group attributes commonly set in TargetGroupInput.Attributes
+const (
+	TargetGroupDeregistrationDelay    = "deregistration_delay.timeout_seconds"
+	TargetGroupStickinessEnabled      = "stickiness.enabled"
+	TargetGroupStickinessType         = "stickiness.type"
+	TargetGroupStickinessCookieExpiry = "stickiness.lb_cookie.duration_seconds"
+)
+
+// TargetGroupInput is the desired state of a TargetGroup.
+type TargetGroupInput struct {
+	*elbv2.CreateTargetGroupInput
+	//Attributes are the target group attributes to set, such as TargetGroupDeregistrationDelay.
+	//Attributes left out keep their current value.
+	Attributes map[string]string
+}
+
+// ListenerInput is the desired state of a Listener.
+type ListenerInput struct {
+	*elbv2.CreateListenerInput
+	//ExtraCertificates are the ARNs of the certificates served besides the default one in Certificates.
+	//nil leaves them untouched, an empty slice removes all of them.
+	ExtraCertificates []string
+	//IgnoreDefaultActionChanges only uses DefaultActions to create the listener, so a deploy
+	//switching the traffic by modifying the listener isn't undone by the next Apply.
+	IgnoreDefaultActionChanges bool
+}
+
+// ListenerRuleInput is the desired state of a listener Rule.
+type ListenerRuleInput struct {
+	*elbv2.CreateRuleInput
+}
+
+// DNSRecordInput is the desired state of a DNS record set.
+// The record set is identified by the hosted zone, its name, its type and its set identifier.
+type DNSRecordInput struct {
+	HostedZoneId *string
+	*route53types.ResourceRecordSet
+}
@@ -0,0 +1,39 @@
+package apierror
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/aws/smithy-go"
+)
+
+// Code returns the error code of an AWS API error, or an empty string for other errors
+func Code(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode()
+	}
+	return ""
+}
+
+// Is reports whether err is an AWS API error with one of the given codes
+func Is(err error, codes ...string) bool {
+	code := Code(err)
+	for _, c := range codes {
+		if code != "" && code == c {
+			return true
+		}
+	}
+	return false
+}
+
+// IsNotFound reports whether err is an AWS API error telling the resource doesn't exist,
+// such as InvalidVpcID.NotFound or LoadBalancerNotFound
+func IsNotFound(err error) bool {
+	return strings.HasSuffix(Code(err), "NotFound")
+}
+
+// IsDependencyViolation reports whether err is an AWS API error telling other resources depend on the resource
+func IsDependencyViolation(err error) bool {
+	return Is(err, "DependencyViolation")
+}
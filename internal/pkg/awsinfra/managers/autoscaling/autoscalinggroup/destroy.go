@@ -0,0 +1,80 @@
+package autoscalingautoscalinggroupmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/managers/apierror"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/wait"
+)
+
+const (
+	// drainTimeout is the maximum time to wait for the instances to be drained and terminated
+	drainTimeout = 15 * time.Minute
+	// deleteTimeout is the maximum time to wait for the group to disappear once deleted
+	deleteTimeout = 10 * time.Minute
+)
+
+// Destroy scales the group to zero and waits for its instances to terminate, so they are
+// deregistered from the attached target groups and their connections drained first.
+// The group is then deleted and Destroy waits until it is gone. With WithForceDelete
+// the group is deleted right away along with its instances.
+// Destroying a group that no longer exists succeeds.
+func (rm *manager) Destroy(ctx context.Context, id awsinfra.ExternalID) error {
+	name := aws.ToString(id)
+	asg, err := rm.describe(ctx, name)
+	if err != nil || asg == nil {
+		return err
+	}
+	if !rm.forceDelete {
+		if err := rm.drain(ctx, name); err != nil {
+			return err
+		}
+	}
+	if _, err := rm.client.DeleteAutoScalingGroup(ctx, &autoscaling.DeleteAutoScalingGroupInput{
+		AutoScalingGroupName: id,
+		ForceDelete:          aws.Bool(rm.forceDelete),
+	}); err != nil {
+		if apierror.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if err := wait.Until(ctx, pollInterval, deleteTimeout, func(ctx context.Context) (bool, error) {
+		asg, err := rm.describe(ctx, name)
+		return asg == nil, err
+	}); err != nil {
+		return fmt.Errorf("AutoScalingGroup %s was deleted but didn't disappear: %w", name, err)
+	}
+	return nil
+}
+
+// drain scales the group to zero and waits until all its instances are terminated
+func (rm *manager) drain(ctx context.Context, name string) error {
+	if _, err := rm.client.UpdateAutoScalingGroup(ctx, &autoscaling.UpdateAutoScalingGroupInput{
+		AutoScalingGroupName: aws.String(name),
+		MinSize:              aws.Int32(0),
+		MaxSize:              aws.Int32(0),
+		DesiredCapacity:      aws.Int32(0),
+	}); err != nil {
+		return err
+	}
+	remaining := 0
+	err := wait.Until(ctx, pollInterval, drainTimeout, func(ctx context.Context) (bool, error) {
+		asg, err := rm.describe(ctx, name)
+		if err != nil || asg == nil {
+			return asg == nil, err
+		}
+		remaining = len(asg.Instances)
+		return remaining == 0, nil
+	})
+	if errors.Is(err, wait.ErrTimeout) {
+		return fmt.Errorf("AutoScalingGroup %s still has %d instances after scaling to zero, instances protected from scale in must be removed or the group force deleted: %w", name, remaining, err)
+	}
+	return err
+}
@@ -4,53 +4,113 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
 	"github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra"
 )
 
-// New Creates a new instsance of the resource manager
-func New(client *autoscaling.Client) awsinfra.ResourceManager[*autoscaling.CreateAutoScalingGroupInput, *types.AutoScalingGroup] {
-	return &manager{
-		client,
+// Option configures the resource manager
+type Option func(*manager)
+
+// WithForceDelete makes Destroy delete groups right away, terminating their instances
+// without scaling in first and so without waiting for connections to drain
+func WithForceDelete() Option {
+	return func(rm *manager) {
+		rm.forceDelete = true
+	}
+}
+
+// New Creates a new instsance of the resource manager.
+// The EC2 client resolves the launch template versions $Latest and $Default.
+func New(client *autoscaling.Client, ec2Client *ec2.Client, opts ...Option) awsinfra.ResourceManager[*awsinfra.AutoScalingGroupInput, *types.AutoScalingGroup] {
+	rm := &manager{
+		client:    client,
+		ec2Client: ec2Client,
 	}
+	for _, opt := range opts {
+		opt(rm)
+	}
+	return rm
 }
 
 type manager struct {
-	client *autoscaling.Client
+	client      *autoscaling.Client
+	ec2Client   *ec2.Client
+	forceDelete bool
 }
 
-func (rm *manager) Create(input *autoscaling.CreateAutoScalingGroupInput) (awsinfra.ExternalID, *types.AutoScalingGroup, error) {
-	if *input.AutoScalingGroupName == "" {
+func (rm *manager) Create(ctx context.Context, input *awsinfra.AutoScalingGroupInput) (awsinfra.ExternalID, *types.AutoScalingGroup, error) {
+	if input.CreateAutoScalingGroupInput == nil || aws.ToString(input.AutoScalingGroupName) == "" {
 		return nil, nil, fmt.Errorf("AutoScalingGroupName is required and is used as the external id")
 	}
-	_, err := rm.client.CreateAutoScalingGroup(context.TODO(), input)
+	_, err := rm.client.CreateAutoScalingGroup(ctx, input.CreateAutoScalingGroupInput)
 	if err != nil {
 		return nil, nil, err
 	}
-	asg, err := rm.Load(input.AutoScalingGroupName)
+	asg, err := rm.Load(ctx, input.AutoScalingGroupName)
 	if err != nil {
 		return nil, nil, err
 	}
 	return asg.AutoScalingGroupName, asg, nil
 }
 
-func (rm *manager) Update(input *autoscaling.CreateAutoScalingGroupInput, last *types.AutoScalingGroup) (awsinfra.ExternalID, *types.AutoScalingGroup, error) {
-	return nil, nil, fmt.Errorf("TODO: Need to implement")
+// Update reconciles sizes, launch template, subnets, health checks and the other settings of
+// UpdateAutoScalingGroup, as well as target groups and tags. When the launch template changes,
+// or a version $Latest or $Default now resolves to one the instances don't run, and input.InstanceRefresh
+// is set, the running instances are replaced by an instance refresh and Update waits for it to complete. A change of name creates a new AutoScalingGroup instead.
+func (rm *manager) Update(ctx context.Context, input *awsinfra.AutoScalingGroupInput, last *types.AutoScalingGroup) (awsinfra.ExternalID, *types.AutoScalingGroup, error) {
+	changes, err := rm.changes(ctx, input, last)
+	if err != nil {
+		return last.AutoScalingGroupName, last, err
+	}
+	if changes.replace {
+		return rm.Create(ctx, input)
+	}
+	if changes.empty() {
+		return last.AutoScalingGroupName, last, nil
+	}
+	if err := rm.apply(ctx, input, last, changes); err != nil {
+		return last.AutoScalingGroupName, last, err
+	}
+	asg, err := rm.Load(ctx, last.AutoScalingGroupName)
+	if err != nil {
+		return last.AutoScalingGroupName, last, err
+	}
+	return asg.AutoScalingGroupName, asg, nil
 }
-func (rm *manager) Load(id awsinfra.ExternalID) (*types.AutoScalingGroup, error) {
-	output, err := rm.client.DescribeAutoScalingGroups(context.TODO(), &autoscaling.DescribeAutoScalingGroupsInput{
-		AutoScalingGroupNames: []string{*id},
-	})
+
+// Diff describes the changes Update would make, without making them
+func (rm *manager) Diff(ctx context.Context, input *awsinfra.AutoScalingGroupInput, last *types.AutoScalingGroup) ([]awsinfra.FieldChange, error) {
+	changes, err := rm.changes(ctx, input, last)
 	if err != nil {
 		return nil, err
 	}
-	if len(output.AutoScalingGroups) == 0 {
+	return changes.fields, nil
+}
+
+func (rm *manager) Load(ctx context.Context, id awsinfra.ExternalID) (*types.AutoScalingGroup, error) {
+	asg, err := rm.describe(ctx, *id)
+	if err != nil {
+		return nil, err
+	}
+	if asg == nil {
 		return nil, fmt.Errorf("AutoScalingGroup with id %s not found", *id)
 	}
-	return &output.AutoScalingGroups[0], nil
+	return asg, nil
 }
 
-func (rm *manager) Destroy(id awsinfra.ExternalID) error {
-	return fmt.Errorf("TODO: Need to implement")
+// describe returns the group with the given name, or nil if it doesn't exist
+func (rm *manager) describe(ctx context.Context, name string) (*types.AutoScalingGroup, error) {
+	output, err := rm.client.DescribeAutoScalingGroups(ctx, &autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []string{name},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(output.AutoScalingGroups) == 0 {
+		return nil, nil
+	}
+	return &output.AutoScalingGroups[0], nil
 }
@@ -0,0 +1,41 @@
+package autoscalingautoscalinggroupmanager
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateRequiresAName(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(server.Close)
+	rm := New(autoscaling.New(autoscaling.Options{
+		Region:           "us-east-1",
+		BaseEndpoint:     aws.String(server.URL),
+		Credentials:      credentials.NewStaticCredentialsProvider("test", "test", ""),
+		RetryMaxAttempts: 1,
+	}), nil)
+	for _, input := range []*awsinfra.AutoScalingGroupInput{
+		{},
+		{CreateAutoScalingGroupInput: &autoscaling.CreateAutoScalingGroupInput{}},
+		{CreateAutoScalingGroupInput: &autoscaling.CreateAutoScalingGroupInput{AutoScalingGroupName: aws.String("")}},
+	} {
+		_, _, err := rm.Create(context.Background(), input)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "AutoScalingGroupName is required")
+		}
+	}
+	assert.Equal(t, int32(0), calls.Load())
+}
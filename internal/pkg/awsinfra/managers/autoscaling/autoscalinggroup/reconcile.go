@@ -0,0 +1,243 @@
+package autoscalingautoscalinggroupmanager
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra"
+)
+
+const (
+	// targetGroupsBatch is the maximum number of target groups attached or detached by a single call
+	targetGroupsBatch = 10
+	// resourceType is the type of the tagged resource
+	resourceType = "auto-scaling-group"
+)
+
+// asgChanges are the operations needed to reconcile an AutoScalingGroup with its input
+type asgChanges struct {
+	replace            bool
+	update             bool
+	refresh            bool
+	attachTargetGroups []string
+	detachTargetGroups []string
+	createTags         []types.Tag
+	deleteTags         []types.Tag
+	fields             []awsinfra.FieldChange
+}
+
+func (c *asgChanges) empty() bool {
+	return len(c.fields) == 0
+}
+
+// changes compares the input with the last AutoScalingGroup. It only reads from the cloud provider.
+func (rm *manager) changes(ctx context.Context, input *awsinfra.AutoScalingGroupInput, last *types.AutoScalingGroup) (*asgChanges, error) {
+	c := &asgChanges{}
+	if input.AutoScalingGroupName != nil && aws.ToString(input.AutoScalingGroupName) != aws.ToString(last.AutoScalingGroupName) {
+		c.replace = true
+		c.fields = append(c.fields, awsinfra.FieldChange{
+			Field:             "AutoScalingGroupName",
+			Current:           aws.ToString(last.AutoScalingGroupName),
+			Desired:           aws.ToString(input.AutoScalingGroupName),
+			ForcesReplacement: true,
+		})
+	}
+
+	//Subnets and zones are compared as sets, the other settings field by field
+	settings := updateInput(input)
+	settings.AutoScalingGroupName = nil
+	settings.VPCZoneIdentifier = nil
+	settings.AvailabilityZones = nil
+	updates, err := awsinfra.DiffFields(settings, last)
+	if err != nil {
+		return nil, err
+	}
+	if input.VPCZoneIdentifier != nil {
+		desired, current := splitSubnets(aws.ToString(input.VPCZoneIdentifier)), splitSubnets(aws.ToString(last.VPCZoneIdentifier))
+		if !sameSet(desired, current) {
+			updates = append(updates, awsinfra.FieldChange{Field: "VPCZoneIdentifier", Current: current, Desired: desired})
+		}
+	}
+	if input.AvailabilityZones != nil && !sameSet(input.AvailabilityZones, last.AvailabilityZones) {
+		updates = append(updates, awsinfra.FieldChange{Field: "AvailabilityZones", Current: last.AvailabilityZones, Desired: input.AvailabilityZones})
+	}
+	c.update = len(updates) > 0
+	for _, change := range updates {
+		if input.InstanceRefresh != nil && (change.Field == "LaunchTemplate" || strings.HasPrefix(change.Field, "LaunchTemplate.")) {
+			c.refresh = true
+		}
+	}
+	c.fields = append(c.fields, updates...)
+	if input.InstanceRefresh != nil && !c.refresh && input.LaunchTemplate != nil {
+		//The input may be unchanged while $Latest or $Default points to a new version
+		outdated, version, err := rm.outdatedInstances(ctx, input.LaunchTemplate, last.Instances)
+		if err != nil {
+			return nil, err
+		}
+		if len(outdated) > 0 {
+			c.refresh = true
+			c.fields = append(c.fields, awsinfra.FieldChange{Field: "Instances.LaunchTemplate.Version", Current: outdated, Desired: version})
+		}
+	}
+
+	if input.TargetGroupARNs != nil {
+		c.attachTargetGroups = missing(input.TargetGroupARNs, last.TargetGroupARNs)
+		c.detachTargetGroups = missing(last.TargetGroupARNs, input.TargetGroupARNs)
+		if len(c.attachTargetGroups) > 0 || len(c.detachTargetGroups) > 0 {
+			c.fields = append(c.fields, awsinfra.FieldChange{Field: "TargetGroupARNs", Current: last.TargetGroupARNs, Desired: input.TargetGroupARNs})
+		}
+	}
+
+	if input.Tags != nil {
+		tagChanges(input, last, c)
+	}
+	return c, nil
+}
+
+// tagChanges compares the tags of the input with the ones of the last AutoScalingGroup.
+// Tags managed by AWS, prefixed with aws:, are left untouched.
+func tagChanges(input *awsinfra.AutoScalingGroupInput, last *types.AutoScalingGroup, c *asgChanges) {
+	tag := func(key string, value *string, propagate *bool) types.Tag {
+		return types.Tag{
+			Key:               aws.String(key),
+			Value:             value,
+			PropagateAtLaunch: propagate,
+			ResourceId:        last.AutoScalingGroupName,
+			ResourceType:      aws.String(resourceType),
+		}
+	}
+	current := make(map[string]types.TagDescription, len(last.Tags))
+	for _, t := range last.Tags {
+		if !strings.HasPrefix(aws.ToString(t.Key), "aws:") {
+			current[aws.ToString(t.Key)] = t
+		}
+	}
+	desired := make(map[string]bool, len(input.Tags))
+	for _, t := range input.Tags {
+		key := aws.ToString(t.Key)
+		desired[key] = true
+		cur, ok := current[key]
+		if !ok || aws.ToString(cur.Value) != aws.ToString(t.Value) || aws.ToBool(cur.PropagateAtLaunch) != aws.ToBool(t.PropagateAtLaunch) {
+			c.createTags = append(c.createTags, tag(key, t.Value, t.PropagateAtLaunch))
+		}
+	}
+	currentTags := make(map[string]string, len(current))
+	for key, t := range current {
+		currentTags[key] = aws.ToString(t.Value)
+		if !desired[key] {
+			c.deleteTags = append(c.deleteTags, tag(key, t.Value, t.PropagateAtLaunch))
+		}
+	}
+	sort.Slice(c.deleteTags, func(i, j int) bool { return aws.ToString(c.deleteTags[i].Key) < aws.ToString(c.deleteTags[j].Key) })
+	if len(c.createTags) > 0 || len(c.deleteTags) > 0 {
+		desiredTags := make(map[string]string, len(input.Tags))
+		for _, t := range input.Tags {
+			desiredTags[aws.ToString(t.Key)] = aws.ToString(t.Value)
+		}
+		c.fields = append(c.fields, awsinfra.FieldChange{Field: "Tags", Current: currentTags, Desired: desiredTags})
+	}
+}
+
+// apply makes the changes to the AutoScalingGroup
+func (rm *manager) apply(ctx context.Context, input *awsinfra.AutoScalingGroupInput, last *types.AutoScalingGroup, c *asgChanges) error {
+	name := last.AutoScalingGroupName
+	if c.update {
+		update := updateInput(input)
+		update.AutoScalingGroupName = name
+		if _, err := rm.client.UpdateAutoScalingGroup(ctx, update); err != nil {
+			return err
+		}
+	}
+	for start := 0; start < len(c.attachTargetGroups); start += targetGroupsBatch {
+		if _, err := rm.client.AttachLoadBalancerTargetGroups(ctx, &autoscaling.AttachLoadBalancerTargetGroupsInput{
+			AutoScalingGroupName: name,
+			TargetGroupARNs:      c.attachTargetGroups[start:min(start+targetGroupsBatch, len(c.attachTargetGroups))],
+		}); err != nil {
+			return err
+		}
+	}
+	for start := 0; start < len(c.detachTargetGroups); start += targetGroupsBatch {
+		if _, err := rm.client.DetachLoadBalancerTargetGroups(ctx, &autoscaling.DetachLoadBalancerTargetGroupsInput{
+			AutoScalingGroupName: name,
+			TargetGroupARNs:      c.detachTargetGroups[start:min(start+targetGroupsBatch, len(c.detachTargetGroups))],
+		}); err != nil {
+			return err
+		}
+	}
+	if len(c.deleteTags) > 0 {
+		if _, err := rm.client.DeleteTags(ctx, &autoscaling.DeleteTagsInput{Tags: c.deleteTags}); err != nil {
+			return err
+		}
+	}
+	if len(c.createTags) > 0 {
+		if _, err := rm.client.CreateOrUpdateTags(ctx, &autoscaling.CreateOrUpdateTagsInput{Tags: c.createTags}); err != nil {
+			return err
+		}
+	}
+	if c.refresh {
+		return rm.refreshInstances(ctx, aws.ToString(name), input.InstanceRefresh)
+	}
+	return nil
+}
+
+// updateInput maps the creation input onto the settings UpdateAutoScalingGroup can change
+func updateInput(input *awsinfra.AutoScalingGroupInput) *autoscaling.UpdateAutoScalingGroupInput {
+	return &autoscaling.UpdateAutoScalingGroupInput{
+		AutoScalingGroupName:             input.AutoScalingGroupName,
+		AvailabilityZones:                input.AvailabilityZones,
+		CapacityRebalance:                input.CapacityRebalance,
+		Context:                          input.Context,
+		DefaultCooldown:                  input.DefaultCooldown,
+		DefaultInstanceWarmup:            input.DefaultInstanceWarmup,
+		DesiredCapacity:                  input.DesiredCapacity,
+		DesiredCapacityType:              input.DesiredCapacityType,
+		HealthCheckGracePeriod:           input.HealthCheckGracePeriod,
+		HealthCheckType:                  input.HealthCheckType,
+		InstanceMaintenancePolicy:        input.InstanceMaintenancePolicy,
+		LaunchConfigurationName:          input.LaunchConfigurationName,
+		LaunchTemplate:                   input.LaunchTemplate,
+		MaxInstanceLifetime:              input.MaxInstanceLifetime,
+		MaxSize:                          input.MaxSize,
+		MinSize:                          input.MinSize,
+		MixedInstancesPolicy:             input.MixedInstancesPolicy,
+		NewInstancesProtectedFromScaleIn: input.NewInstancesProtectedFromScaleIn,
+		PlacementGroup:                   input.PlacementGroup,
+		ServiceLinkedRoleARN:             input.ServiceLinkedRoleARN,
+		TerminationPolicies:              input.TerminationPolicies,
+		VPCZoneIdentifier:                input.VPCZoneIdentifier,
+	}
+}
+
+func splitSubnets(vpcZoneIdentifier string) []string {
+	var subnets []string
+	for _, subnet := range strings.Split(vpcZoneIdentifier, ",") {
+		if subnet = strings.TrimSpace(subnet); subnet != "" {
+			subnets = append(subnets, subnet)
+		}
+	}
+	sort.Strings(subnets)
+	return subnets
+}
+
+func sameSet(a []string, b []string) bool {
+	return len(missing(a, b)) == 0 && len(missing(b, a)) == 0
+}
+
+// missing returns the values of a that are not in b
+func missing(a []string, b []string) []string {
+	in := make(map[string]bool, len(b))
+	for _, v := range b {
+		in[v] = true
+	}
+	var out []string
+	for _, v := range a {
+		if !in[v] {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,167 @@
+package autoscalingautoscalinggroupmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/wait"
+)
+
+const (
+	// refreshTimeout is the default maximum time to wait for an instance refresh
+	refreshTimeout = 30 * time.Minute
+	// pollInterval is the time between checks while waiting for the group
+	pollInterval = 15 * time.Second
+)
+
+// RefreshError is returned by Update when the instance refresh didn't complete successfully
+type RefreshError struct {
+	AutoScalingGroupName string
+	InstanceRefreshID    string
+	Status               types.InstanceRefreshStatus
+	Reason               string
+	CausedBy             error //set when waiting for the refresh failed
+}
+
+// Error returns the final status of the refresh and its reason
+func (e *RefreshError) Error() string {
+	msg := fmt.Sprintf("instance refresh %s of AutoScalingGroup %s ended as %s", e.InstanceRefreshID, e.AutoScalingGroupName, e.Status)
+	if e.Reason != "" {
+		msg += ": " + e.Reason
+	}
+	if e.CausedBy != nil {
+		msg += fmt.Sprintf("; %v", e.CausedBy)
+	}
+	return msg
+}
+
+// Unwrap returns the error that interrupted the wait
+func (e *RefreshError) Unwrap() error {
+	return e.CausedBy
+}
+
+// refreshInstances replaces the instances of the group and waits for the refresh to end.
+// Instances already using the desired launch template are skipped. When waiting times out
+// the refresh is rolled back if AutoRollback is set, and cancelled otherwise.
+func (rm *manager) refreshInstances(ctx context.Context, name string, refresh *awsinfra.InstanceRefresh) error {
+	output, err := rm.client.StartInstanceRefresh(ctx, &autoscaling.StartInstanceRefreshInput{
+		AutoScalingGroupName: aws.String(name),
+		Strategy:             types.RefreshStrategyRolling,
+		Preferences: &types.RefreshPreferences{
+			MinHealthyPercentage: refresh.MinHealthyPercentage,
+			InstanceWarmup:       refresh.InstanceWarmup,
+			AutoRollback:         aws.Bool(refresh.AutoRollback),
+			SkipMatching:         aws.Bool(true),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	refreshID := aws.ToString(output.InstanceRefreshId)
+	timeout := refresh.Timeout
+	if timeout == 0 {
+		timeout = refreshTimeout
+	}
+	var last *types.InstanceRefresh
+	err = wait.Until(ctx, pollInterval, timeout, func(ctx context.Context) (bool, error) {
+		status, err := rm.instanceRefresh(ctx, name, refreshID)
+		if err != nil {
+			return false, err
+		}
+		last = status
+		switch last.Status {
+		case types.InstanceRefreshStatusSuccessful, types.InstanceRefreshStatusFailed, types.InstanceRefreshStatusCancelled,
+			types.InstanceRefreshStatusRollbackSuccessful, types.InstanceRefreshStatusRollbackFailed:
+			return true, nil
+		}
+		return false, nil
+	})
+	if err != nil {
+		if errors.Is(err, wait.ErrTimeout) {
+			rm.abortRefresh(ctx, name, refresh.AutoRollback)
+		}
+		refreshErr := &RefreshError{AutoScalingGroupName: name, InstanceRefreshID: refreshID, CausedBy: err}
+		if last != nil {
+			refreshErr.Status = last.Status
+		}
+		return refreshErr
+	}
+	if last.Status != types.InstanceRefreshStatusSuccessful {
+		return &RefreshError{AutoScalingGroupName: name, InstanceRefreshID: refreshID, Status: last.Status, Reason: aws.ToString(last.StatusReason)}
+	}
+	return nil
+}
+
+func (rm *manager) instanceRefresh(ctx context.Context, name string, refreshID string) (*types.InstanceRefresh, error) {
+	output, err := rm.client.DescribeInstanceRefreshes(ctx, &autoscaling.DescribeInstanceRefreshesInput{
+		AutoScalingGroupName: aws.String(name),
+		InstanceRefreshIds:   []string{refreshID},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(output.InstanceRefreshes) == 0 {
+		return nil, fmt.Errorf("instance refresh %s of AutoScalingGroup %s not found", refreshID, name)
+	}
+	return &output.InstanceRefreshes[0], nil
+}
+
+// outdatedInstances returns the instances not running the version the launch template resolves to, and that version
+func (rm *manager) outdatedInstances(ctx context.Context, template *types.LaunchTemplateSpecification, instances []types.Instance) ([]string, string, error) {
+	version, err := rm.resolveVersion(ctx, template)
+	if err != nil {
+		return nil, "", err
+	}
+	var outdated []string
+	for _, instance := range instances {
+		running := instance.LaunchTemplate
+		if running == nil || aws.ToString(running.Version) != version ||
+			(template.LaunchTemplateId != nil && aws.ToString(running.LaunchTemplateId) != aws.ToString(template.LaunchTemplateId)) ||
+			(template.LaunchTemplateName != nil && aws.ToString(running.LaunchTemplateName) != aws.ToString(template.LaunchTemplateName)) {
+			outdated = append(outdated, aws.ToString(instance.InstanceId))
+		}
+	}
+	return outdated, version, nil
+}
+
+// resolveVersion returns the version number of the launch template, resolving $Latest and $Default.
+// A missing version is $Default, as for the group.
+func (rm *manager) resolveVersion(ctx context.Context, template *types.LaunchTemplateSpecification) (string, error) {
+	version := aws.ToString(template.Version)
+	if version == "" {
+		version = "$Default"
+	}
+	if version != "$Latest" && version != "$Default" {
+		return version, nil
+	}
+	output, err := rm.ec2Client.DescribeLaunchTemplateVersions(ctx, &ec2.DescribeLaunchTemplateVersionsInput{
+		LaunchTemplateId:   template.LaunchTemplateId,
+		LaunchTemplateName: template.LaunchTemplateName,
+		Versions:           []string{version},
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(output.LaunchTemplateVersions) == 0 {
+		return "", fmt.Errorf("Launch Template %s%s has no version %s", aws.ToString(template.LaunchTemplateId), aws.ToString(template.LaunchTemplateName), version)
+	}
+	return strconv.FormatInt(aws.ToInt64(output.LaunchTemplateVersions[0].VersionNumber), 10), nil
+}
+
+// abortRefresh stops a refresh that took too long. It is best effort: the refresh
+// error is what gets reported, so a failure to stop it is ignored.
+func (rm *manager) abortRefresh(ctx context.Context, name string, rollback bool) {
+	if rollback {
+		_, _ = rm.client.RollbackInstanceRefresh(ctx, &autoscaling.RollbackInstanceRefreshInput{AutoScalingGroupName: aws.String(name)})
+		return
+	}
+	_, _ = rm.client.CancelInstanceRefresh(ctx, &autoscaling.CancelInstanceRefreshInput{AutoScalingGroupName: aws.String(name)})
+}
@@ -0,0 +1,203 @@
+package ec2internetgatewaymanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/managers/apierror"
+	ec2tags "github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/managers/ec2/tags"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/wait"
+)
+
+const (
+	// detachTimeout is the maximum time to wait for the public addresses of the VPC to be released
+	detachTimeout = 5 * time.Minute
+	// pollInterval is the time between two detach attempts
+	pollInterval = 10 * time.Second
+)
+
+// New Creates a new instsance of the resource manager
+func New(client *ec2.Client) awsinfra.ResourceManager[*awsinfra.InternetGatewayInput, *types.InternetGateway] {
+	return &manager{
+		client,
+	}
+}
+
+type manager struct {
+	client *ec2.Client
+}
+
+// gatewayChanges are the operations needed to reconcile an InternetGateway with its input
+type gatewayChanges struct {
+	tags   ec2tags.Changes
+	detach []string //VPC ids
+	attach string   //VPC id
+	fields []awsinfra.FieldChange
+}
+
+// Create creates the InternetGateway and attaches it to the VPC, its id is the ExternalID
+func (rm *manager) Create(ctx context.Context, input *awsinfra.InternetGatewayInput) (awsinfra.ExternalID, *types.InternetGateway, error) {
+	output, err := rm.client.CreateInternetGateway(ctx, input.CreateInternetGatewayInput)
+	if err != nil {
+		return nil, nil, err
+	}
+	gatewayID := output.InternetGateway.InternetGatewayId
+	if aws.ToString(input.VpcId) == "" {
+		return gatewayID, output.InternetGateway, nil
+	}
+	if err := rm.attach(ctx, *gatewayID, *input.VpcId); err != nil {
+		return gatewayID, output.InternetGateway, err
+	}
+	gateway, err := rm.Load(ctx, gatewayID)
+	if err != nil {
+		return gatewayID, output.InternetGateway, err
+	}
+	return gatewayID, gateway, nil
+}
+
+// Update reconciles the tags and moves the InternetGateway to the desired VPC
+func (rm *manager) Update(ctx context.Context, input *awsinfra.InternetGatewayInput, last *types.InternetGateway) (awsinfra.ExternalID, *types.InternetGateway, error) {
+	changes := rm.changes(input, last)
+	if len(changes.fields) == 0 {
+		return last.InternetGatewayId, last, nil
+	}
+	gatewayID := aws.ToString(last.InternetGatewayId)
+	if err := changes.tags.Apply(ctx, rm.client, gatewayID); err != nil {
+		return last.InternetGatewayId, last, err
+	}
+	for _, vpcID := range changes.detach {
+		if err := rm.detach(ctx, gatewayID, vpcID); err != nil {
+			return last.InternetGatewayId, last, err
+		}
+	}
+	if changes.attach != "" {
+		if err := rm.attach(ctx, gatewayID, changes.attach); err != nil {
+			return last.InternetGatewayId, last, err
+		}
+	}
+	gateway, err := rm.Load(ctx, last.InternetGatewayId)
+	if err != nil {
+		return last.InternetGatewayId, last, err
+	}
+	return gateway.InternetGatewayId, gateway, nil
+}
+
+// Diff describes the changes Update would make, without making them
+func (rm *manager) Diff(ctx context.Context, input *awsinfra.InternetGatewayInput, last *types.InternetGateway) ([]awsinfra.FieldChange, error) {
+	return rm.changes(input, last).fields, nil
+}
+
+// changes compares the input with the last InternetGateway
+func (rm *manager) changes(input *awsinfra.InternetGatewayInput, last *types.InternetGateway) *gatewayChanges {
+	c := &gatewayChanges{}
+	desiredTags := ec2tags.Desired(input.TagSpecifications, types.ResourceTypeInternetGateway)
+	currentTags := ec2tags.Current(last.Tags)
+	if c.tags = ec2tags.Diff(desiredTags, currentTags); !c.tags.Empty() {
+		c.fields = append(c.fields, awsinfra.FieldChange{Field: "Tags", Current: currentTags, Desired: desiredTags})
+	}
+	if input.VpcId == nil {
+		return c
+	}
+	attached := attachedVPCs(last)
+	current := ""
+	for _, vpcID := range attached {
+		if vpcID == *input.VpcId {
+			current = vpcID
+			continue
+		}
+		c.detach = append(c.detach, vpcID)
+	}
+	if current == "" {
+		c.attach = *input.VpcId
+	}
+	if len(c.detach) > 0 || c.attach != "" {
+		c.fields = append(c.fields, awsinfra.FieldChange{Field: "VpcId", Current: attached, Desired: *input.VpcId})
+	}
+	return c
+}
+
+func (rm *manager) Load(ctx context.Context, id awsinfra.ExternalID) (*types.InternetGateway, error) {
+	output, err := rm.client.DescribeInternetGateways(ctx, &ec2.DescribeInternetGatewaysInput{
+		InternetGatewayIds: []string{*id},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(output.InternetGateways) == 0 {
+		return nil, fmt.Errorf("InternetGateway %s not found", *id)
+	}
+	return &output.InternetGateways[0], nil
+}
+
+// Destroy detaches the InternetGateway from its VPCs and deletes it.
+// Destroying an InternetGateway that no longer exists succeeds.
+func (rm *manager) Destroy(ctx context.Context, id awsinfra.ExternalID) error {
+	gateway, err := rm.Load(ctx, id)
+	if err != nil {
+		if apierror.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	for _, vpcID := range attachedVPCs(gateway) {
+		if err := rm.detach(ctx, *id, vpcID); err != nil {
+			return err
+		}
+	}
+	_, err = rm.client.DeleteInternetGateway(ctx, &ec2.DeleteInternetGatewayInput{InternetGatewayId: id})
+	if apierror.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func (rm *manager) attach(ctx context.Context, gatewayID string, vpcID string) error {
+	_, err := rm.client.AttachInternetGateway(ctx, &ec2.AttachInternetGatewayInput{
+		InternetGatewayId: aws.String(gatewayID),
+		VpcId:             aws.String(vpcID),
+	})
+	return err
+}
+
+// detach detaches the InternetGateway from the VPC. The VPC can't lose its gateway while it has
+// public addresses mapped, e.g. of a NatGateway being deleted, so it is retried until they are released.
+func (rm *manager) detach(ctx context.Context, gatewayID string, vpcID string) error {
+	var inUse error
+	err := wait.Until(ctx, pollInterval, detachTimeout, func(ctx context.Context) (bool, error) {
+		_, err := rm.client.DetachInternetGateway(ctx, &ec2.DetachInternetGatewayInput{
+			InternetGatewayId: aws.String(gatewayID),
+			VpcId:             aws.String(vpcID),
+		})
+		switch {
+		case err == nil, apierror.IsNotFound(err), apierror.Is(err, "Gateway.NotAttached"):
+			return true, nil
+		case apierror.IsDependencyViolation(err):
+			inUse = err
+			return false, nil
+		default:
+			return false, err
+		}
+	})
+	if errors.Is(err, wait.ErrTimeout) && inUse != nil {
+		return fmt.Errorf("InternetGateway %s can't be detached from %s, public addresses still mapped: %w", gatewayID, vpcID, inUse)
+	}
+	return err
+}
+
+// attachedVPCs returns the VPCs the InternetGateway is attached, or being attached, to
+func attachedVPCs(gateway *types.InternetGateway) []string {
+	var vpcs []string
+	for _, attachment := range gateway.Attachments {
+		if attachment.State == types.AttachmentStatusDetached || attachment.State == types.AttachmentStatusDetaching {
+			continue
+		}
+		vpcs = append(vpcs, aws.ToString(attachment.VpcId))
+	}
+	return vpcs
+}
@@ -5,34 +5,71 @@ import (
 	"fmt"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
 	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/managers/apierror"
 )
 
-// New Creates a new instsance of the resource manager
-func New(client *ec2.Client) awsinfra.ResourceManager[*ec2.CreateLaunchTemplateInput, *types.LaunchTemplate] {
+// New Creates a new instsance of the resource manager.
+// The Auto Scaling client finds the versions used by AutoScalingGroups, which are never pruned.
+func New(client *ec2.Client, asgClient *autoscaling.Client) awsinfra.ResourceManager[*awsinfra.LaunchTemplateInput, *types.LaunchTemplate] {
 	return &manager{
 		client,
+		asgClient,
 	}
 }
 
 type manager struct {
-	client *ec2.Client
+	client    *ec2.Client
+	asgClient *autoscaling.Client
 }
 
-func (rm *manager) Create(input *ec2.CreateLaunchTemplateInput) (awsinfra.ExternalID, *types.LaunchTemplate, error) {
-	output, err := rm.client.CreateLaunchTemplate(context.TODO(), input)
+func (rm *manager) Create(ctx context.Context, input *awsinfra.LaunchTemplateInput) (awsinfra.ExternalID, *types.LaunchTemplate, error) {
+	output, err := rm.client.CreateLaunchTemplate(ctx, input.CreateLaunchTemplateInput)
 	if err != nil {
 		return aws.String(""), nil, err
 	}
 	return output.LaunchTemplate.LaunchTemplateId, output.LaunchTemplate, nil
 }
-func (rm *manager) Update(input *ec2.CreateLaunchTemplateInput, last *types.LaunchTemplate) (awsinfra.ExternalID, *types.LaunchTemplate, error) {
-	return aws.String(""), &types.LaunchTemplate{}, fmt.Errorf("TODO: Need to implement")
+
+// Update creates a new version of the template when LaunchTemplateData differs from the
+// latest version, so the ExternalID stays the template id and resources referencing
+// the template keep working. Old versions are pruned according to KeepVersions,
+// except the default one and the ones AutoScalingGroups or their instances use.
+// A template can't be renamed, so a change of name creates a new template instead.
+func (rm *manager) Update(ctx context.Context, input *awsinfra.LaunchTemplateInput, last *types.LaunchTemplate) (awsinfra.ExternalID, *types.LaunchTemplate, error) {
+	changes, err := rm.changes(ctx, input, last)
+	if err != nil {
+		return last.LaunchTemplateId, last, err
+	}
+	if changes.replace {
+		return rm.Create(ctx, input)
+	}
+	if changes.empty() {
+		return last.LaunchTemplateId, last, nil
+	}
+	if err := rm.apply(ctx, input, last, changes); err != nil {
+		return last.LaunchTemplateId, last, err
+	}
+	template, err := rm.Load(ctx, last.LaunchTemplateId)
+	if err != nil {
+		return last.LaunchTemplateId, last, err
+	}
+	return template.LaunchTemplateId, template, nil
 }
-func (rm *manager) Load(id awsinfra.ExternalID) (*types.LaunchTemplate, error) {
-	output, err := rm.client.DescribeLaunchTemplates(context.TODO(), &ec2.DescribeLaunchTemplatesInput{
+
+// Diff describes the changes Update would make, without making them
+func (rm *manager) Diff(ctx context.Context, input *awsinfra.LaunchTemplateInput, last *types.LaunchTemplate) ([]awsinfra.FieldChange, error) {
+	changes, err := rm.changes(ctx, input, last)
+	if err != nil {
+		return nil, err
+	}
+	return changes.fields, nil
+}
+func (rm *manager) Load(ctx context.Context, id awsinfra.ExternalID) (*types.LaunchTemplate, error) {
+	output, err := rm.client.DescribeLaunchTemplates(ctx, &ec2.DescribeLaunchTemplatesInput{
 		LaunchTemplateIds: []string{*id},
 		MaxResults:        aws.Int32(1),
 	})
@@ -44,6 +81,14 @@ func (rm *manager) Load(id awsinfra.ExternalID) (*types.LaunchTemplate, error) {
 	}
 	return &output.LaunchTemplates[0], nil
 }
-func (rm *manager) Destroy(id awsinfra.ExternalID) error {
-	return fmt.Errorf("TODO: Need to implement")
+
+// Destroy deletes the template with all its versions.
+// Destroying a template that no longer exists succeeds.
+func (rm *manager) Destroy(ctx context.Context, id awsinfra.ExternalID) error {
+	if _, err := rm.client.DeleteLaunchTemplate(ctx, &ec2.DeleteLaunchTemplateInput{
+		LaunchTemplateId: id,
+	}); err != nil && !apierror.IsNotFound(err) {
+		return err
+	}
+	return nil
 }
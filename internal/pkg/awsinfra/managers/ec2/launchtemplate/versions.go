@@ -0,0 +1,204 @@
+package ec2launchtemplatemanager
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	asgtypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra"
+	ec2tags "github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/managers/ec2/tags"
+)
+
+// deleteVersionsBatch is the maximum number of versions DeleteLaunchTemplateVersions accepts
+const deleteVersionsBatch = 200
+
+// templateChanges are the operations needed to reconcile a LaunchTemplate with its input
+type templateChanges struct {
+	replace    bool
+	tags       ec2tags.Changes
+	newVersion bool
+	setDefault bool //makes the latest version the default one when no version is created
+	fields     []awsinfra.FieldChange
+}
+
+func (c *templateChanges) empty() bool {
+	return len(c.fields) == 0
+}
+
+// changes compares the input with the last LaunchTemplate and its latest version. It only reads from the cloud provider.
+func (rm *manager) changes(ctx context.Context, input *awsinfra.LaunchTemplateInput, last *types.LaunchTemplate) (*templateChanges, error) {
+	c := &templateChanges{}
+	if input.LaunchTemplateName != nil && aws.ToString(input.LaunchTemplateName) != aws.ToString(last.LaunchTemplateName) {
+		c.replace = true
+		c.fields = append(c.fields, awsinfra.FieldChange{
+			Field:             "LaunchTemplateName",
+			Current:           aws.ToString(last.LaunchTemplateName),
+			Desired:           aws.ToString(input.LaunchTemplateName),
+			ForcesReplacement: true,
+		})
+	}
+
+	desiredTags := ec2tags.Desired(input.TagSpecifications, types.ResourceTypeLaunchTemplate)
+	currentTags := ec2tags.Current(last.Tags)
+	if c.tags = ec2tags.Diff(desiredTags, currentTags); !c.tags.Empty() {
+		c.fields = append(c.fields, awsinfra.FieldChange{Field: "Tags", Current: currentTags, Desired: desiredTags})
+	}
+
+	latest, err := rm.latestVersion(ctx, last.LaunchTemplateId)
+	if err != nil {
+		return nil, err
+	}
+	dataChanges, err := awsinfra.DiffFields(input.LaunchTemplateData, latest.LaunchTemplateData)
+	if err != nil {
+		return nil, err
+	}
+	for _, change := range dataChanges {
+		change.Field = "LaunchTemplateData." + change.Field
+		c.fields = append(c.fields, change)
+	}
+	c.newVersion = len(dataChanges) > 0
+
+	if input.SetDefaultVersion && !c.newVersion && aws.ToInt64(last.DefaultVersionNumber) != aws.ToInt64(last.LatestVersionNumber) {
+		c.setDefault = true
+		c.fields = append(c.fields, awsinfra.FieldChange{
+			Field:   "DefaultVersionNumber",
+			Current: aws.ToInt64(last.DefaultVersionNumber),
+			Desired: aws.ToInt64(last.LatestVersionNumber),
+		})
+	}
+	return c, nil
+}
+
+// apply makes the changes to the LaunchTemplate
+func (rm *manager) apply(ctx context.Context, input *awsinfra.LaunchTemplateInput, last *types.LaunchTemplate, c *templateChanges) error {
+	if err := c.tags.Apply(ctx, rm.client, aws.ToString(last.LaunchTemplateId)); err != nil {
+		return err
+	}
+	version := aws.ToInt64(last.LatestVersionNumber)
+	if c.newVersion {
+		output, err := rm.client.CreateLaunchTemplateVersion(ctx, &ec2.CreateLaunchTemplateVersionInput{
+			LaunchTemplateId:   last.LaunchTemplateId,
+			LaunchTemplateData: input.LaunchTemplateData,
+			VersionDescription: input.VersionDescription,
+		})
+		if err != nil {
+			return err
+		}
+		version = aws.ToInt64(output.LaunchTemplateVersion.VersionNumber)
+	}
+	defaultVersion := aws.ToInt64(last.DefaultVersionNumber)
+	if c.setDefault || (c.newVersion && input.SetDefaultVersion) {
+		if _, err := rm.client.ModifyLaunchTemplate(ctx, &ec2.ModifyLaunchTemplateInput{
+			LaunchTemplateId: last.LaunchTemplateId,
+			DefaultVersion:   aws.String(strconv.FormatInt(version, 10)),
+		}); err != nil {
+			return err
+		}
+		defaultVersion = version
+	}
+	if c.newVersion && input.KeepVersions > 0 {
+		return rm.pruneVersions(ctx, last.LaunchTemplateId, input.KeepVersions, defaultVersion)
+	}
+	return nil
+}
+
+func (rm *manager) latestVersion(ctx context.Context, id *string) (*types.LaunchTemplateVersion, error) {
+	output, err := rm.client.DescribeLaunchTemplateVersions(ctx, &ec2.DescribeLaunchTemplateVersionsInput{
+		LaunchTemplateId: id,
+		Versions:         []string{"$Latest"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(output.LaunchTemplateVersions) == 0 {
+		return nil, fmt.Errorf("Launch Template %s has no versions", aws.ToString(id))
+	}
+	return &output.LaunchTemplateVersions[0], nil
+}
+
+// pruneVersions deletes all but the keep latest versions of the template.
+// The default version and the versions used by AutoScalingGroups are never deleted.
+func (rm *manager) pruneVersions(ctx context.Context, id *string, keep int32, defaultVersion int64) error {
+	used, err := rm.usedVersions(ctx, aws.ToString(id))
+	if err != nil {
+		return err
+	}
+	var versions []int64
+	paginator := ec2.NewDescribeLaunchTemplateVersionsPaginator(rm.client, &ec2.DescribeLaunchTemplateVersionsInput{
+		LaunchTemplateId: id,
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, version := range page.LaunchTemplateVersions {
+			versions = append(versions, aws.ToInt64(version.VersionNumber))
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+	var prune []string
+	for i, version := range versions {
+		if i >= int(keep) && version != defaultVersion && !used[strconv.FormatInt(version, 10)] {
+			prune = append(prune, strconv.FormatInt(version, 10))
+		}
+	}
+	for start := 0; start < len(prune); start += deleteVersionsBatch {
+		end := min(start+deleteVersionsBatch, len(prune))
+		output, err := rm.client.DeleteLaunchTemplateVersions(ctx, &ec2.DeleteLaunchTemplateVersionsInput{
+			LaunchTemplateId: id,
+			Versions:         prune[start:end],
+		})
+		if err != nil {
+			return err
+		}
+		if len(output.UnsuccessfullyDeletedLaunchTemplateVersions) > 0 {
+			failed := output.UnsuccessfullyDeletedLaunchTemplateVersions[0]
+			reason := ""
+			if failed.ResponseError != nil {
+				reason = aws.ToString(failed.ResponseError.Message)
+			}
+			return fmt.Errorf("Launch Template %s version %d can't be deleted: %s", aws.ToString(id), aws.ToInt64(failed.VersionNumber), reason)
+		}
+	}
+	return nil
+}
+
+// usedVersions returns the versions of the template AutoScalingGroups are pinned to, directly or through
+// a mixed instances policy, and the ones their instances were launched from, which a rollback goes back to.
+// $Latest and $Default match no version number, the latest and default versions are never pruned anyway.
+func (rm *manager) usedVersions(ctx context.Context, id string) (map[string]bool, error) {
+	used := make(map[string]bool)
+	use := func(template *asgtypes.LaunchTemplateSpecification) {
+		if template != nil && aws.ToString(template.LaunchTemplateId) == id {
+			used[aws.ToString(template.Version)] = true
+		}
+	}
+	paginator := autoscaling.NewDescribeAutoScalingGroupsPaginator(rm.asgClient, &autoscaling.DescribeAutoScalingGroupsInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, asg := range page.AutoScalingGroups {
+			use(asg.LaunchTemplate)
+			if asg.MixedInstancesPolicy != nil && asg.MixedInstancesPolicy.LaunchTemplate != nil {
+				mixed := asg.MixedInstancesPolicy.LaunchTemplate
+				use(mixed.LaunchTemplateSpecification)
+				for _, override := range mixed.Overrides {
+					use(override.LaunchTemplateSpecification)
+				}
+			}
+			for _, instance := range asg.Instances {
+				use(instance.LaunchTemplate)
+			}
+		}
+	}
+	return used, nil
+}
@@ -0,0 +1,225 @@
+package ec2natgatewaymanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/managers/apierror"
+	ec2tags "github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/managers/ec2/tags"
+)
+
+const (
+	// availableTimeout is the default maximum time to wait for a new NatGateway to become available
+	availableTimeout = 10 * time.Minute
+	// deletedTimeout is the maximum time to wait for a NatGateway to release its addresses
+	deletedTimeout = 10 * time.Minute
+	// allocatedByTag marks the Elastic IPs allocated by the manager, released with their NatGateway
+	allocatedByTag = "awsinfra:allocated-by"
+	allocatedBy    = "natgateway"
+)
+
+// New Creates a new instsance of the resource manager
+func New(client *ec2.Client) awsinfra.ResourceManager[*awsinfra.NatGatewayInput, *types.NatGateway] {
+	return &manager{
+		client,
+	}
+}
+
+type manager struct {
+	client *ec2.Client
+}
+
+// natChanges are the operations needed to reconcile a NatGateway with its input
+type natChanges struct {
+	replace bool
+	tags    ec2tags.Changes
+	fields  []awsinfra.FieldChange
+}
+
+// Create allocates an Elastic IP when a public NatGateway has none, creates the NatGateway
+// and waits for it to be available, its id is the ExternalID.
+// A NatGateway failing to become available is destroyed, releasing the Elastic IP allocated for it.
+func (rm *manager) Create(ctx context.Context, input *awsinfra.NatGatewayInput) (awsinfra.ExternalID, *types.NatGateway, error) {
+	create := *input.CreateNatGatewayInput
+	if create.ConnectivityType != types.ConnectivityTypePrivate && create.AllocationId == nil {
+		allocationID, err := rm.allocate(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		create.AllocationId = allocationID
+	}
+	output, err := rm.client.CreateNatGateway(ctx, &create)
+	if err != nil {
+		if create.AllocationId != input.AllocationId {
+			err = errors.Join(err, rm.release(context.WithoutCancel(ctx), []string{*create.AllocationId}))
+		}
+		return nil, nil, err
+	}
+	natID := output.NatGateway.NatGatewayId
+	timeout := input.Timeout
+	if timeout <= 0 {
+		timeout = availableTimeout
+	}
+	if err := ec2.NewNatGatewayAvailableWaiter(rm.client).Wait(ctx, &ec2.DescribeNatGatewaysInput{
+		NatGatewayIds: []string{*natID},
+	}, timeout); err != nil {
+		return natID, output.NatGateway, errors.Join(err, rm.Destroy(context.WithoutCancel(ctx), natID))
+	}
+	nat, err := rm.Load(ctx, natID)
+	if err != nil {
+		return natID, output.NatGateway, err
+	}
+	return natID, nat, nil
+}
+
+// Update reconciles the tags of the NatGateway. Nothing else can be changed,
+// so a new NatGateway is created instead and its id returned; the last one is destroyed by awsinfra.Infra.Prune.
+func (rm *manager) Update(ctx context.Context, input *awsinfra.NatGatewayInput, last *types.NatGateway) (awsinfra.ExternalID, *types.NatGateway, error) {
+	changes := rm.changes(input, last)
+	if changes.replace {
+		return rm.Create(ctx, input)
+	}
+	if len(changes.fields) == 0 {
+		return last.NatGatewayId, last, nil
+	}
+	if err := changes.tags.Apply(ctx, rm.client, aws.ToString(last.NatGatewayId)); err != nil {
+		return last.NatGatewayId, last, err
+	}
+	nat, err := rm.Load(ctx, last.NatGatewayId)
+	if err != nil {
+		return last.NatGatewayId, last, err
+	}
+	return nat.NatGatewayId, nat, nil
+}
+
+// Diff describes the changes Update would make, without making them
+func (rm *manager) Diff(ctx context.Context, input *awsinfra.NatGatewayInput, last *types.NatGateway) ([]awsinfra.FieldChange, error) {
+	return rm.changes(input, last).fields, nil
+}
+
+// changes compares the input with the last NatGateway
+func (rm *manager) changes(input *awsinfra.NatGatewayInput, last *types.NatGateway) *natChanges {
+	c := &natChanges{}
+	replacement := func(field string, desired string, current string) {
+		if desired != "" && desired != current {
+			c.replace = true
+			c.fields = append(c.fields, awsinfra.FieldChange{Field: field, Current: current, Desired: desired, ForcesReplacement: true})
+		}
+	}
+	replacement("SubnetId", aws.ToString(input.SubnetId), aws.ToString(last.SubnetId))
+	replacement("ConnectivityType", string(input.ConnectivityType), string(last.ConnectivityType))
+	primary := primaryAddress(last)
+	replacement("AllocationId", aws.ToString(input.AllocationId), aws.ToString(primary.AllocationId))
+	replacement("PrivateIpAddress", aws.ToString(input.PrivateIpAddress), aws.ToString(primary.PrivateIp))
+
+	desiredTags := ec2tags.Desired(input.TagSpecifications, types.ResourceTypeNatgateway)
+	currentTags := ec2tags.Current(last.Tags)
+	if c.tags = ec2tags.Diff(desiredTags, currentTags); !c.tags.Empty() {
+		c.fields = append(c.fields, awsinfra.FieldChange{Field: "Tags", Current: currentTags, Desired: desiredTags})
+	}
+	return c
+}
+
+func (rm *manager) Load(ctx context.Context, id awsinfra.ExternalID) (*types.NatGateway, error) {
+	output, err := rm.client.DescribeNatGateways(ctx, &ec2.DescribeNatGatewaysInput{
+		NatGatewayIds: []string{*id},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(output.NatGateways) == 0 {
+		return nil, fmt.Errorf("NatGateway %s not found", *id)
+	}
+	return &output.NatGateways[0], nil
+}
+
+// Destroy deletes the NatGateway, waits for it to release its addresses and then
+// releases the Elastic IPs allocated for it by Create.
+// Destroying a NatGateway that no longer exists succeeds.
+func (rm *manager) Destroy(ctx context.Context, id awsinfra.ExternalID) error {
+	nat, err := rm.Load(ctx, id)
+	if err != nil {
+		if apierror.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	var allocationIDs []string
+	for _, address := range nat.NatGatewayAddresses {
+		if address.AllocationId != nil {
+			allocationIDs = append(allocationIDs, *address.AllocationId)
+		}
+	}
+	if nat.State != types.NatGatewayStateDeleted {
+		if _, err := rm.client.DeleteNatGateway(ctx, &ec2.DeleteNatGatewayInput{NatGatewayId: id}); err != nil && !apierror.IsNotFound(err) {
+			return err
+		}
+		if err := ec2.NewNatGatewayDeletedWaiter(rm.client).Wait(ctx, &ec2.DescribeNatGatewaysInput{
+			NatGatewayIds: []string{*id},
+		}, deletedTimeout); err != nil {
+			return err
+		}
+	}
+	return rm.release(ctx, allocationIDs)
+}
+
+// allocate allocates an Elastic IP tagged as allocated by the manager
+func (rm *manager) allocate(ctx context.Context) (*string, error) {
+	output, err := rm.client.AllocateAddress(ctx, &ec2.AllocateAddressInput{
+		Domain: types.DomainTypeVpc,
+		TagSpecifications: []types.TagSpecification{{
+			ResourceType: types.ResourceTypeElasticIp,
+			Tags:         []types.Tag{{Key: aws.String(allocatedByTag), Value: aws.String(allocatedBy)}},
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return output.AllocationId, nil
+}
+
+// release releases the Elastic IPs allocated by the manager, leaving the ones given in the input
+func (rm *manager) release(ctx context.Context, allocationIDs []string) error {
+	if len(allocationIDs) == 0 {
+		return nil
+	}
+	output, err := rm.client.DescribeAddresses(ctx, &ec2.DescribeAddressesInput{
+		AllocationIds: allocationIDs,
+	})
+	if err != nil {
+		if apierror.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	for _, address := range output.Addresses {
+		if ec2tags.Current(address.Tags)[allocatedByTag] != allocatedBy {
+			continue
+		}
+		if _, err := rm.client.ReleaseAddress(ctx, &ec2.ReleaseAddressInput{
+			AllocationId: address.AllocationId,
+		}); err != nil && !apierror.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// primaryAddress returns the address the NatGateway was created with
+func primaryAddress(nat *types.NatGateway) types.NatGatewayAddress {
+	for _, address := range nat.NatGatewayAddresses {
+		if aws.ToBool(address.IsPrimary) {
+			return address
+		}
+	}
+	if len(nat.NatGatewayAddresses) > 0 {
+		return nat.NatGatewayAddresses[0]
+	}
+	return types.NatGatewayAddress{}
+}
@@ -0,0 +1,137 @@
+package ec2routetablemanager
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/managers/apierror"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/wait"
+)
+
+const (
+	// existsTimeout is the maximum time to wait for a new RouteTable to be visible
+	existsTimeout = 2 * time.Minute
+	// existsInterval is the time between checks while waiting for a new RouteTable
+	existsInterval = 2 * time.Second
+)
+
+// New Creates a new instsance of the resource manager
+func New(client *ec2.Client) awsinfra.ResourceManager[*awsinfra.RouteTableInput, *types.RouteTable] {
+	return &manager{
+		client,
+	}
+}
+
+type manager struct {
+	client *ec2.Client
+}
+
+// Create creates the RouteTable, adds its routes and associates its Subnets, its id is the ExternalID
+func (rm *manager) Create(ctx context.Context, input *awsinfra.RouteTableInput) (awsinfra.ExternalID, *types.RouteTable, error) {
+	output, err := rm.client.CreateRouteTable(ctx, input.CreateRouteTableInput)
+	if err != nil {
+		return nil, nil, err
+	}
+	tableID := output.RouteTable.RouteTableId
+	if len(input.Routes) == 0 && len(input.SubnetIds) == 0 {
+		return tableID, output.RouteTable, nil
+	}
+	var table *types.RouteTable
+	//Routes can't be added until the RouteTable is visible
+	if err := wait.Until(ctx, existsInterval, existsTimeout, func(ctx context.Context) (bool, error) {
+		table, err = rm.Load(ctx, tableID)
+		if apierror.IsNotFound(err) {
+			return false, nil
+		}
+		return err == nil, err
+	}); err != nil {
+		return tableID, output.RouteTable, err
+	}
+	changes, err := rm.changes(ctx, input, table)
+	if err != nil {
+		return tableID, table, err
+	}
+	if err := rm.apply(ctx, *tableID, changes); err != nil {
+		return tableID, table, err
+	}
+	if table, err = rm.Load(ctx, tableID); err != nil {
+		return tableID, output.RouteTable, err
+	}
+	return tableID, table, nil
+}
+
+// Update reconciles tags, routes and Subnet associations in place, only changing the routes
+// and associations that differ. A RouteTable can't be moved to another VPC, so a new one is
+// created instead and its id returned; the last one is destroyed by awsinfra.Infra.Prune.
+func (rm *manager) Update(ctx context.Context, input *awsinfra.RouteTableInput, last *types.RouteTable) (awsinfra.ExternalID, *types.RouteTable, error) {
+	changes, err := rm.changes(ctx, input, last)
+	if err != nil {
+		return last.RouteTableId, last, err
+	}
+	if changes.replace {
+		return rm.Create(ctx, input)
+	}
+	if changes.empty() {
+		return last.RouteTableId, last, nil
+	}
+	if err := rm.apply(ctx, *last.RouteTableId, changes); err != nil {
+		return last.RouteTableId, last, err
+	}
+	table, err := rm.Load(ctx, last.RouteTableId)
+	if err != nil {
+		return last.RouteTableId, last, err
+	}
+	return table.RouteTableId, table, nil
+}
+
+// Diff describes the changes Update would make, without making them
+func (rm *manager) Diff(ctx context.Context, input *awsinfra.RouteTableInput, last *types.RouteTable) ([]awsinfra.FieldChange, error) {
+	changes, err := rm.changes(ctx, input, last)
+	if err != nil {
+		return nil, err
+	}
+	return changes.fields, nil
+}
+
+func (rm *manager) Load(ctx context.Context, id awsinfra.ExternalID) (*types.RouteTable, error) {
+	output, err := rm.client.DescribeRouteTables(ctx, &ec2.DescribeRouteTablesInput{
+		RouteTableIds: []string{*id},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(output.RouteTables) == 0 {
+		return nil, fmt.Errorf("RouteTable %s not found", *id)
+	}
+	return &output.RouteTables[0], nil
+}
+
+// Destroy disassociates the Subnets of the RouteTable, which fall back to the main
+// route table, and deletes it.
+// Destroying a RouteTable that no longer exists succeeds.
+func (rm *manager) Destroy(ctx context.Context, id awsinfra.ExternalID) error {
+	table, err := rm.Load(ctx, id)
+	if err != nil {
+		if apierror.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	for _, associationID := range subnetAssociations(table) {
+		if _, err := rm.client.DisassociateRouteTable(ctx, &ec2.DisassociateRouteTableInput{
+			AssociationId: aws.String(associationID),
+		}); err != nil && !apierror.IsNotFound(err) {
+			return err
+		}
+	}
+	_, err = rm.client.DeleteRouteTable(ctx, &ec2.DeleteRouteTableInput{RouteTableId: id})
+	if apierror.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,261 @@
+package ec2routetablemanager
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra"
+	ec2tags "github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/managers/ec2/tags"
+)
+
+// tableChanges are the operations needed to reconcile a RouteTable with its input
+type tableChanges struct {
+	replace       bool
+	tags          ec2tags.Changes
+	createRoutes  []awsinfra.Route
+	replaceRoutes []awsinfra.Route
+	deleteRoutes  []awsinfra.Route
+	associate     []string //subnet ids
+	disassociate  []string //association ids
+	fields        []awsinfra.FieldChange
+}
+
+func (c *tableChanges) empty() bool {
+	return len(c.fields) == 0
+}
+
+// changes compares the input with the last RouteTable. It only reads from the cloud provider.
+func (rm *manager) changes(ctx context.Context, input *awsinfra.RouteTableInput, last *types.RouteTable) (*tableChanges, error) {
+	c := &tableChanges{}
+	if vpcID := aws.ToString(input.VpcId); vpcID != "" && vpcID != aws.ToString(last.VpcId) {
+		c.replace = true
+		c.fields = append(c.fields, awsinfra.FieldChange{Field: "VpcId", Current: aws.ToString(last.VpcId), Desired: vpcID, ForcesReplacement: true})
+	}
+
+	desiredTags := ec2tags.Desired(input.TagSpecifications, types.ResourceTypeRouteTable)
+	currentTags := ec2tags.Current(last.Tags)
+	if c.tags = ec2tags.Diff(desiredTags, currentTags); !c.tags.Empty() {
+		c.fields = append(c.fields, awsinfra.FieldChange{Field: "Tags", Current: currentTags, Desired: desiredTags})
+	}
+
+	if input.Routes != nil {
+		if err := c.diffRoutes(input.Routes, last.Routes); err != nil {
+			return nil, err
+		}
+	}
+
+	if input.SubnetIds != nil {
+		associations := subnetAssociations(last)
+		for _, subnetID := range input.SubnetIds {
+			if _, ok := associations[subnetID]; !ok {
+				c.associate = append(c.associate, subnetID)
+			}
+		}
+		current := make([]string, 0, len(associations))
+		for subnetID, associationID := range associations {
+			current = append(current, subnetID)
+			if !slices.Contains(input.SubnetIds, subnetID) {
+				c.disassociate = append(c.disassociate, associationID)
+			}
+		}
+		sort.Strings(current)
+		sort.Strings(c.disassociate)
+		if len(c.associate) > 0 || len(c.disassociate) > 0 {
+			c.fields = append(c.fields, awsinfra.FieldChange{Field: "SubnetIds", Current: current, Desired: input.SubnetIds})
+		}
+	}
+	return c, nil
+}
+
+// diffRoutes computes the routes to create, replace and delete. Routes are identified by their
+// destination, and only the routes created with CreateRoute are managed, so the local route of
+// the VPC and the propagated ones are kept.
+func (c *tableChanges) diffRoutes(desired []awsinfra.Route, routes []types.Route) error {
+	current := make(map[string]awsinfra.Route)
+	for _, route := range routes {
+		if route.Origin != types.RouteOriginCreateRoute {
+			continue
+		}
+		r := awsinfra.Route{
+			DestinationCidrBlock:        route.DestinationCidrBlock,
+			DestinationIpv6CidrBlock:    route.DestinationIpv6CidrBlock,
+			DestinationPrefixListId:     route.DestinationPrefixListId,
+			GatewayId:                   route.GatewayId,
+			NatGatewayId:                route.NatGatewayId,
+			EgressOnlyInternetGatewayId: route.EgressOnlyInternetGatewayId,
+			TransitGatewayId:            route.TransitGatewayId,
+			VpcPeeringConnectionId:      route.VpcPeeringConnectionId,
+			NetworkInterfaceId:          route.NetworkInterfaceId,
+		}
+		current[destination(r)] = r
+	}
+	wanted := make(map[string]awsinfra.Route, len(desired))
+	for _, route := range desired {
+		d := destination(route)
+		if d == "" {
+			return fmt.Errorf("route to %s has no destination", target(route))
+		}
+		wanted[d] = route
+		existing, ok := current[d]
+		switch {
+		case !ok:
+			c.createRoutes = append(c.createRoutes, route)
+		case target(existing) != target(route):
+			c.replaceRoutes = append(c.replaceRoutes, route)
+		}
+	}
+	for _, d := range sortedKeys(current) {
+		if _, ok := wanted[d]; !ok {
+			c.deleteRoutes = append(c.deleteRoutes, current[d])
+		}
+	}
+	if len(c.createRoutes) > 0 || len(c.replaceRoutes) > 0 || len(c.deleteRoutes) > 0 {
+		c.fields = append(c.fields, awsinfra.FieldChange{Field: "Routes", Current: render(current), Desired: render(wanted)})
+	}
+	return nil
+}
+
+// apply makes the changes to the RouteTable. Routes and associations are added before the
+// extra ones are removed, so the traffic keeps flowing while it moves.
+func (rm *manager) apply(ctx context.Context, tableID string, c *tableChanges) error {
+	if err := c.tags.Apply(ctx, rm.client, tableID); err != nil {
+		return err
+	}
+	for _, route := range c.createRoutes {
+		if _, err := rm.client.CreateRoute(ctx, &ec2.CreateRouteInput{
+			RouteTableId:                aws.String(tableID),
+			DestinationCidrBlock:        route.DestinationCidrBlock,
+			DestinationIpv6CidrBlock:    route.DestinationIpv6CidrBlock,
+			DestinationPrefixListId:     route.DestinationPrefixListId,
+			GatewayId:                   route.GatewayId,
+			NatGatewayId:                route.NatGatewayId,
+			EgressOnlyInternetGatewayId: route.EgressOnlyInternetGatewayId,
+			TransitGatewayId:            route.TransitGatewayId,
+			VpcPeeringConnectionId:      route.VpcPeeringConnectionId,
+			NetworkInterfaceId:          route.NetworkInterfaceId,
+		}); err != nil {
+			return err
+		}
+	}
+	for _, route := range c.replaceRoutes {
+		if _, err := rm.client.ReplaceRoute(ctx, &ec2.ReplaceRouteInput{
+			RouteTableId:                aws.String(tableID),
+			DestinationCidrBlock:        route.DestinationCidrBlock,
+			DestinationIpv6CidrBlock:    route.DestinationIpv6CidrBlock,
+			DestinationPrefixListId:     route.DestinationPrefixListId,
+			GatewayId:                   route.GatewayId,
+			NatGatewayId:                route.NatGatewayId,
+			EgressOnlyInternetGatewayId: route.EgressOnlyInternetGatewayId,
+			TransitGatewayId:            route.TransitGatewayId,
+			VpcPeeringConnectionId:      route.VpcPeeringConnectionId,
+			NetworkInterfaceId:          route.NetworkInterfaceId,
+		}); err != nil {
+			return err
+		}
+	}
+	for _, subnetID := range c.associate {
+		if err := rm.associate(ctx, tableID, subnetID); err != nil {
+			return err
+		}
+	}
+	for _, associationID := range c.disassociate {
+		if _, err := rm.client.DisassociateRouteTable(ctx, &ec2.DisassociateRouteTableInput{
+			AssociationId: aws.String(associationID),
+		}); err != nil {
+			return err
+		}
+	}
+	for _, route := range c.deleteRoutes {
+		if _, err := rm.client.DeleteRoute(ctx, &ec2.DeleteRouteInput{
+			RouteTableId:             aws.String(tableID),
+			DestinationCidrBlock:     route.DestinationCidrBlock,
+			DestinationIpv6CidrBlock: route.DestinationIpv6CidrBlock,
+			DestinationPrefixListId:  route.DestinationPrefixListId,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// associate associates the Subnet with the RouteTable, moving it from the route table it is explicitly associated with
+func (rm *manager) associate(ctx context.Context, tableID string, subnetID string) error {
+	output, err := rm.client.DescribeRouteTables(ctx, &ec2.DescribeRouteTablesInput{
+		Filters: []types.Filter{{Name: aws.String("association.subnet-id"), Values: []string{subnetID}}},
+	})
+	if err != nil {
+		return err
+	}
+	for _, table := range output.RouteTables {
+		if associationID, ok := subnetAssociations(&table)[subnetID]; ok {
+			_, err := rm.client.ReplaceRouteTableAssociation(ctx, &ec2.ReplaceRouteTableAssociationInput{
+				AssociationId: aws.String(associationID),
+				RouteTableId:  aws.String(tableID),
+			})
+			return err
+		}
+	}
+	_, err = rm.client.AssociateRouteTable(ctx, &ec2.AssociateRouteTableInput{
+		RouteTableId: aws.String(tableID),
+		SubnetId:     aws.String(subnetID),
+	})
+	return err
+}
+
+// subnetAssociations returns the association ids of the Subnets explicitly associated with the RouteTable, by Subnet id
+func subnetAssociations(table *types.RouteTable) map[string]string {
+	associations := make(map[string]string)
+	for _, assoc := range table.Associations {
+		if assoc.SubnetId == nil || aws.ToBool(assoc.Main) {
+			continue
+		}
+		if assoc.AssociationState != nil && assoc.AssociationState.State != types.RouteTableAssociationStateCodeAssociated &&
+			assoc.AssociationState.State != types.RouteTableAssociationStateCodeAssociating {
+			continue
+		}
+		associations[*assoc.SubnetId] = aws.ToString(assoc.RouteTableAssociationId)
+	}
+	return associations
+}
+
+// destination returns the destination of the route, which identifies it in the RouteTable
+func destination(route awsinfra.Route) string {
+	return aws.ToString(route.DestinationCidrBlock) + aws.ToString(route.DestinationIpv6CidrBlock) + aws.ToString(route.DestinationPrefixListId)
+}
+
+// target returns the target of the route, e.g. "nat-0a1b2c"
+func target(route awsinfra.Route) string {
+	targets := []*string{route.GatewayId, route.NatGatewayId, route.EgressOnlyInternetGatewayId, route.TransitGatewayId,
+		route.VpcPeeringConnectionId, route.NetworkInterfaceId}
+	var ids []string
+	for _, id := range targets {
+		if aws.ToString(id) != "" {
+			ids = append(ids, *id)
+		}
+	}
+	return strings.Join(ids, ",")
+}
+
+// render lists the routes as destination to target
+func render(routes map[string]awsinfra.Route) map[string]string {
+	rendered := make(map[string]string, len(routes))
+	for d, route := range routes {
+		rendered[d] = target(route)
+	}
+	return rendered
+}
+
+func sortedKeys(m map[string]awsinfra.Route) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,44 @@
+package ec2securitygroupmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/managers/apierror"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/wait"
+)
+
+const (
+	// releaseTimeout is the maximum time to wait for the network interfaces using the group to be released
+	releaseTimeout = 5 * time.Minute
+	// pollInterval is the time between two deletion attempts
+	pollInterval = 10 * time.Second
+)
+
+// Destroy deletes the SecurityGroup. Network interfaces of terminated instances or deleted
+// load balancers keep using the group for a while, so the deletion is retried until they are released.
+// Destroying a SecurityGroup that no longer exists succeeds.
+func (rm *manager) Destroy(ctx context.Context, id awsinfra.ExternalID) error {
+	var inUse error
+	err := wait.Until(ctx, pollInterval, releaseTimeout, func(ctx context.Context) (bool, error) {
+		_, err := rm.client.DeleteSecurityGroup(ctx, &ec2.DeleteSecurityGroupInput{GroupId: id})
+		switch {
+		case err == nil, apierror.IsNotFound(err):
+			return true, nil
+		case apierror.IsDependencyViolation(err):
+			inUse = err
+			return false, nil
+		default:
+			return false, err
+		}
+	})
+	if errors.Is(err, wait.ErrTimeout) && inUse != nil {
+		return fmt.Errorf("SecurityGroup %s can't be deleted, still in use: %w", aws.ToString(id), inUse)
+	}
+	return err
+}
@@ -0,0 +1,107 @@
+package ec2securitygroupmanager
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra"
+)
+
+// existsTimeout is the maximum time to wait for a new SecurityGroup to be visible
+const existsTimeout = 2 * time.Minute
+
+// New Creates a new instsance of the resource manager
+func New(client *ec2.Client) awsinfra.ResourceManager[*awsinfra.SecurityGroupInput, *types.SecurityGroup] {
+	return &manager{
+		client,
+	}
+}
+
+type manager struct {
+	client *ec2.Client
+}
+
+// Create creates the SecurityGroup and reconciles its rules, its id is the ExternalID.
+// New groups allow all the outbound traffic, which is revoked when Egress is set without that rule.
+func (rm *manager) Create(ctx context.Context, input *awsinfra.SecurityGroupInput) (awsinfra.ExternalID, *types.SecurityGroup, error) {
+	output, err := rm.client.CreateSecurityGroup(ctx, input.CreateSecurityGroupInput)
+	if err != nil {
+		return nil, nil, err
+	}
+	groupID := output.GroupId
+	created := &types.SecurityGroup{GroupId: groupID, GroupName: input.GroupName, VpcId: input.VpcId}
+	if err := ec2.NewSecurityGroupExistsWaiter(rm.client).Wait(ctx, &ec2.DescribeSecurityGroupsInput{
+		GroupIds: []string{*groupID},
+	}, existsTimeout); err != nil {
+		return groupID, created, err
+	}
+	group, err := rm.Load(ctx, groupID)
+	if err != nil {
+		return groupID, created, err
+	}
+	changes, err := rm.changes(ctx, input, group)
+	if err != nil {
+		return groupID, group, err
+	}
+	if changes.empty() {
+		return groupID, group, nil
+	}
+	if err := rm.apply(ctx, *groupID, changes); err != nil {
+		return groupID, group, err
+	}
+	if group, err = rm.Load(ctx, groupID); err != nil {
+		return groupID, created, err
+	}
+	return groupID, group, nil
+}
+
+// Update authorizes the missing rules, revokes the extra ones and updates the descriptions of the
+// changed ones, so the traffic allowed by the rules kept is never interrupted.
+// The name, the description and the VPC of a SecurityGroup can't be changed, so a new one is created
+// instead and its id returned; the last one is destroyed by awsinfra.Infra.Prune. As names are unique in a VPC
+// and both groups exist until then, a new description in the same VPC is rejected unless the GroupName changes too.
+func (rm *manager) Update(ctx context.Context, input *awsinfra.SecurityGroupInput, last *types.SecurityGroup) (awsinfra.ExternalID, *types.SecurityGroup, error) {
+	changes, err := rm.changes(ctx, input, last)
+	if err != nil {
+		return last.GroupId, last, err
+	}
+	if changes.replace {
+		return rm.Create(ctx, input)
+	}
+	if changes.empty() {
+		return last.GroupId, last, nil
+	}
+	if err := rm.apply(ctx, *last.GroupId, changes); err != nil {
+		return last.GroupId, last, err
+	}
+	group, err := rm.Load(ctx, last.GroupId)
+	if err != nil {
+		return last.GroupId, last, err
+	}
+	return group.GroupId, group, nil
+}
+
+// Diff describes the changes Update would make, without making them
+func (rm *manager) Diff(ctx context.Context, input *awsinfra.SecurityGroupInput, last *types.SecurityGroup) ([]awsinfra.FieldChange, error) {
+	changes, err := rm.changes(ctx, input, last)
+	if err != nil {
+		return nil, err
+	}
+	return changes.fields, nil
+}
+
+func (rm *manager) Load(ctx context.Context, id awsinfra.ExternalID) (*types.SecurityGroup, error) {
+	output, err := rm.client.DescribeSecurityGroups(ctx, &ec2.DescribeSecurityGroupsInput{
+		GroupIds: []string{*id},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(output.SecurityGroups) == 0 {
+		return nil, fmt.Errorf("SecurityGroup %s not found", *id)
+	}
+	return &output.SecurityGroups[0], nil
+}
@@ -0,0 +1,54 @@
+package ec2securitygroupmanager
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateRejectsReplacementUnderTheSameName(t *testing.T) {
+	ctx := context.Background()
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(server.Close)
+	rm := New(ec2.New(ec2.Options{
+		Region:           "us-east-1",
+		BaseEndpoint:     aws.String(server.URL),
+		Credentials:      credentials.NewStaticCredentialsProvider("test", "test", ""),
+		RetryMaxAttempts: 1,
+	})).(*manager)
+	last := &types.SecurityGroup{
+		GroupId:     aws.String("sg-0a1b2c"),
+		GroupName:   aws.String("web"),
+		Description: aws.String("web servers"),
+		VpcId:       aws.String("vpc-0a1b2c"),
+	}
+	input := &awsinfra.SecurityGroupInput{CreateSecurityGroupInput: &ec2.CreateSecurityGroupInput{
+		GroupName:   aws.String("web"),
+		Description: aws.String("web and api servers"),
+	}}
+
+	externalID, _, err := rm.Update(ctx, input, last)
+	assert.ErrorContains(t, err, "set a new GroupName")
+	assert.Equal(t, last.GroupId, externalID)
+	assert.Equal(t, int32(0), calls.Load(), "No security group is created")
+	_, err = rm.Diff(ctx, input, last)
+	assert.Error(t, err, "Plan reports the rejected replacement")
+
+	input.VpcId = aws.String("vpc-0d1e2f")
+	changes, err := rm.changes(ctx, input, last)
+	assert.NoError(t, err, "The name is free in another VPC")
+	assert.True(t, changes.replace)
+}
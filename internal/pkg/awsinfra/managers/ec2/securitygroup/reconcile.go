@@ -0,0 +1,193 @@
+package ec2securitygroupmanager
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra"
+	ec2tags "github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/managers/ec2/tags"
+)
+
+// groupChanges are the operations needed to reconcile a SecurityGroup with its input
+type groupChanges struct {
+	replace bool
+	tags    ec2tags.Changes
+	ingress ruleChanges
+	egress  ruleChanges
+	fields  []awsinfra.FieldChange
+}
+
+// ruleChanges are the operations needed to reconcile the rules of one direction
+type ruleChanges struct {
+	authorize    []types.IpPermission
+	revoke       []string //rule ids
+	descriptions []types.SecurityGroupRuleDescription
+}
+
+func (c *groupChanges) empty() bool {
+	return len(c.fields) == 0
+}
+
+func (c *ruleChanges) empty() bool {
+	return len(c.authorize) == 0 && len(c.revoke) == 0 && len(c.descriptions) == 0
+}
+
+// changes compares the input with the last SecurityGroup. It only reads from the cloud provider.
+func (rm *manager) changes(ctx context.Context, input *awsinfra.SecurityGroupInput, last *types.SecurityGroup) (*groupChanges, error) {
+	c := &groupChanges{}
+	replacement := func(field string, desired *string, current *string) {
+		if desired != nil && aws.ToString(desired) != aws.ToString(current) {
+			c.replace = true
+			c.fields = append(c.fields, awsinfra.FieldChange{
+				Field:             field,
+				Current:           aws.ToString(current),
+				Desired:           aws.ToString(desired),
+				ForcesReplacement: true,
+			})
+		}
+	}
+	replacement("GroupName", input.GroupName, last.GroupName)
+	replacement("Description", input.Description, last.Description)
+	replacement("VpcId", input.VpcId, last.VpcId)
+	//Group names are unique in a VPC, and the new group is created before Prune deletes the last one
+	sameVPC := input.VpcId == nil || *input.VpcId == aws.ToString(last.VpcId)
+	sameName := input.GroupName == nil || *input.GroupName == aws.ToString(last.GroupName)
+	if c.replace && sameVPC && sameName {
+		return nil, fmt.Errorf("SecurityGroup %s can't be replaced under the same name in its VPC, set a new GroupName to change the Description",
+			aws.ToString(last.GroupName))
+	}
+
+	desiredTags := ec2tags.Desired(input.TagSpecifications, types.ResourceTypeSecurityGroup)
+	currentTags := ec2tags.Current(last.Tags)
+	if c.tags = ec2tags.Diff(desiredTags, currentTags); !c.tags.Empty() {
+		c.fields = append(c.fields, awsinfra.FieldChange{Field: "Tags", Current: currentTags, Desired: desiredTags})
+	}
+
+	if input.Ingress == nil && input.Egress == nil {
+		return c, nil
+	}
+	groupID := aws.ToString(last.GroupId)
+	current, err := rm.currentPermissions(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+	rules := func(field string, rules []awsinfra.SecurityGroupRule, egress bool) (ruleChanges, error) {
+		if rules == nil {
+			return ruleChanges{}, nil
+		}
+		desired, err := desiredPermissions(rules, egress, groupID)
+		if err != nil {
+			return ruleChanges{}, err
+		}
+		var direction []permission
+		for _, p := range current {
+			if p.egress == egress {
+				direction = append(direction, p)
+			}
+		}
+		changes := diffPermissions(desired, direction)
+		if !changes.empty() {
+			c.fields = append(c.fields, awsinfra.FieldChange{Field: field, Current: render(direction), Desired: render(desired)})
+		}
+		return changes, nil
+	}
+	if c.ingress, err = rules("Ingress", input.Ingress, false); err != nil {
+		return nil, err
+	}
+	if c.egress, err = rules("Egress", input.Egress, true); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// diffPermissions computes the rules to authorize, to revoke and to describe again
+func diffPermissions(desired []permission, current []permission) ruleChanges {
+	var c ruleChanges
+	existing := make(map[permissionKey]permission, len(current))
+	for _, p := range current {
+		existing[p.permissionKey] = p
+	}
+	wanted := make(map[permissionKey]bool, len(desired))
+	for _, p := range desired {
+		if wanted[p.permissionKey] {
+			continue
+		}
+		wanted[p.permissionKey] = true
+		cur, ok := existing[p.permissionKey]
+		switch {
+		case !ok:
+			c.authorize = append(c.authorize, p.ipPermission())
+		case cur.description != p.description:
+			c.descriptions = append(c.descriptions, types.SecurityGroupRuleDescription{
+				SecurityGroupRuleId: aws.String(cur.ruleID),
+				Description:         aws.String(p.description),
+			})
+		}
+	}
+	for _, p := range current {
+		if !wanted[p.permissionKey] {
+			c.revoke = append(c.revoke, p.ruleID)
+		}
+	}
+	return c
+}
+
+// apply makes the changes to the SecurityGroup. New rules are authorized before the
+// extra ones are revoked, so traffic moving from a rule to another isn't interrupted.
+func (rm *manager) apply(ctx context.Context, groupID string, c *groupChanges) error {
+	if err := c.tags.Apply(ctx, rm.client, groupID); err != nil {
+		return err
+	}
+	if len(c.ingress.authorize) > 0 {
+		if _, err := rm.client.AuthorizeSecurityGroupIngress(ctx, &ec2.AuthorizeSecurityGroupIngressInput{
+			GroupId:       aws.String(groupID),
+			IpPermissions: c.ingress.authorize,
+		}); err != nil {
+			return err
+		}
+	}
+	if len(c.egress.authorize) > 0 {
+		if _, err := rm.client.AuthorizeSecurityGroupEgress(ctx, &ec2.AuthorizeSecurityGroupEgressInput{
+			GroupId:       aws.String(groupID),
+			IpPermissions: c.egress.authorize,
+		}); err != nil {
+			return err
+		}
+	}
+	if len(c.ingress.descriptions) > 0 {
+		if _, err := rm.client.UpdateSecurityGroupRuleDescriptionsIngress(ctx, &ec2.UpdateSecurityGroupRuleDescriptionsIngressInput{
+			GroupId:                       aws.String(groupID),
+			SecurityGroupRuleDescriptions: c.ingress.descriptions,
+		}); err != nil {
+			return err
+		}
+	}
+	if len(c.egress.descriptions) > 0 {
+		if _, err := rm.client.UpdateSecurityGroupRuleDescriptionsEgress(ctx, &ec2.UpdateSecurityGroupRuleDescriptionsEgressInput{
+			GroupId:                       aws.String(groupID),
+			SecurityGroupRuleDescriptions: c.egress.descriptions,
+		}); err != nil {
+			return err
+		}
+	}
+	if len(c.ingress.revoke) > 0 {
+		if _, err := rm.client.RevokeSecurityGroupIngress(ctx, &ec2.RevokeSecurityGroupIngressInput{
+			GroupId:              aws.String(groupID),
+			SecurityGroupRuleIds: c.ingress.revoke,
+		}); err != nil {
+			return err
+		}
+	}
+	if len(c.egress.revoke) > 0 {
+		if _, err := rm.client.RevokeSecurityGroupEgress(ctx, &ec2.RevokeSecurityGroupEgressInput{
+			GroupId:              aws.String(groupID),
+			SecurityGroupRuleIds: c.egress.revoke,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package ec2securitygroupmanager
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra"
+)
+
+// permissionKey identifies a rule of a SecurityGroup: a protocol and port range allowed
+// from, or to, a single peer. Exactly one of the peer fields is set.
+type permissionKey struct {
+	egress       bool
+	protocol     string
+	fromPort     int32
+	toPort       int32
+	cidrIpv4     string
+	cidrIpv6     string
+	prefixListID string
+	groupID      string
+}
+
+// permission is a rule of a SecurityGroup
+type permission struct {
+	permissionKey
+	description string
+	ruleID      string //empty for desired rules
+}
+
+// protocols maps the protocol numbers to the names AWS reports
+var protocols = map[string]string{"1": "icmp", "6": "tcp", "17": "udp", "58": "icmpv6", "all": "-1"}
+
+func newKey(egress bool, protocol string, fromPort int32, toPort int32) permissionKey {
+	protocol = strings.ToLower(protocol)
+	if name, ok := protocols[protocol]; ok {
+		protocol = name
+	}
+	if protocol == "-1" {
+		fromPort, toPort = -1, -1
+	}
+	return permissionKey{egress: egress, protocol: protocol, fromPort: fromPort, toPort: toPort}
+}
+
+// desiredPermissions splits the rules of the input into one permission per peer
+func desiredPermissions(rules []awsinfra.SecurityGroupRule, egress bool, groupID string) ([]permission, error) {
+	var permissions []permission
+	for _, rule := range rules {
+		if len(rule.SecurityGroups) > 0 {
+			return nil, fmt.Errorf("security groups %s must be resolved into SecurityGroupIds by awsinfra.Infra", strings.Join(rule.SecurityGroups, ", "))
+		}
+		key := newKey(egress, rule.IpProtocol, rule.FromPort, rule.ToPort)
+		add := func(peer func(k *permissionKey)) {
+			k := key
+			peer(&k)
+			permissions = append(permissions, permission{permissionKey: k, description: rule.Description})
+		}
+		for _, cidr := range rule.CidrIpv4 {
+			add(func(k *permissionKey) { k.cidrIpv4 = cidr })
+		}
+		for _, cidr := range rule.CidrIpv6 {
+			add(func(k *permissionKey) { k.cidrIpv6 = cidr })
+		}
+		for _, id := range rule.PrefixListIds {
+			add(func(k *permissionKey) { k.prefixListID = id })
+		}
+		for _, id := range rule.SecurityGroupIds {
+			add(func(k *permissionKey) { k.groupID = id })
+		}
+		if rule.Self {
+			add(func(k *permissionKey) { k.groupID = groupID })
+		}
+	}
+	return permissions, nil
+}
+
+// currentPermissions returns the rules of the SecurityGroup
+func (rm *manager) currentPermissions(ctx context.Context, groupID string) ([]permission, error) {
+	var permissions []permission
+	paginator := ec2.NewDescribeSecurityGroupRulesPaginator(rm.client, &ec2.DescribeSecurityGroupRulesInput{
+		Filters: []types.Filter{{Name: aws.String("group-id"), Values: []string{groupID}}},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, rule := range page.SecurityGroupRules {
+			key := newKey(aws.ToBool(rule.IsEgress), aws.ToString(rule.IpProtocol), aws.ToInt32(rule.FromPort), aws.ToInt32(rule.ToPort))
+			key.cidrIpv4 = aws.ToString(rule.CidrIpv4)
+			key.cidrIpv6 = aws.ToString(rule.CidrIpv6)
+			key.prefixListID = aws.ToString(rule.PrefixListId)
+			if rule.ReferencedGroupInfo != nil {
+				key.groupID = aws.ToString(rule.ReferencedGroupInfo.GroupId)
+			}
+			permissions = append(permissions, permission{
+				permissionKey: key,
+				description:   aws.ToString(rule.Description),
+				ruleID:        aws.ToString(rule.SecurityGroupRuleId),
+			})
+		}
+	}
+	return permissions, nil
+}
+
+// ipPermission returns the permission as expected by the authorize APIs
+func (p permission) ipPermission() types.IpPermission {
+	permission := types.IpPermission{IpProtocol: aws.String(p.protocol)}
+	if p.protocol != "-1" {
+		permission.FromPort = aws.Int32(p.fromPort)
+		permission.ToPort = aws.Int32(p.toPort)
+	}
+	var description *string
+	if p.description != "" {
+		description = aws.String(p.description)
+	}
+	switch {
+	case p.cidrIpv4 != "":
+		permission.IpRanges = []types.IpRange{{CidrIp: aws.String(p.cidrIpv4), Description: description}}
+	case p.cidrIpv6 != "":
+		permission.Ipv6Ranges = []types.Ipv6Range{{CidrIpv6: aws.String(p.cidrIpv6), Description: description}}
+	case p.prefixListID != "":
+		permission.PrefixListIds = []types.PrefixListId{{PrefixListId: aws.String(p.prefixListID), Description: description}}
+	default:
+		permission.UserIdGroupPairs = []types.UserIdGroupPair{{GroupId: aws.String(p.groupID), Description: description}}
+	}
+	return permission
+}
+
+// String renders the permission in plans, e.g. "tcp 443-443 10.0.0.0/16"
+func (p permission) String() string {
+	peer := p.cidrIpv4 + p.cidrIpv6 + p.prefixListID + p.groupID
+	s := fmt.Sprintf("%s %d-%d %s", p.protocol, p.fromPort, p.toPort, peer)
+	if p.protocol == "-1" {
+		s = "all " + peer
+	}
+	if p.description != "" {
+		s += " (" + p.description + ")"
+	}
+	return s
+}
+
+// render lists the permissions as sorted strings
+func render(permissions []permission) []string {
+	rendered := make([]string, 0, len(permissions))
+	for _, p := range permissions {
+		rendered = append(rendered, p.String())
+	}
+	sort.Strings(rendered)
+	return rendered
+}
@@ -0,0 +1,105 @@
+package ec2subnetmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/managers/apierror"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/wait"
+)
+
+const (
+	// releaseTimeout is the maximum time to wait for network interfaces to be released
+	releaseTimeout = 5 * time.Minute
+	// pollInterval is the time between checks while waiting for network interfaces
+	pollInterval = 10 * time.Second
+)
+
+// Destroy deletes the Subnet once the network interfaces in it are released: detached
+// interfaces are deleted while waiting for the attached ones, e.g. of load balancers or
+// Lambda functions, to be released by the services owning them.
+// Destroying a Subnet that no longer exists succeeds.
+func (rm *manager) Destroy(ctx context.Context, id awsinfra.ExternalID) error {
+	subnetID := aws.ToString(id)
+	if _, err := rm.Load(ctx, id); err != nil {
+		if apierror.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if err := rm.releaseNetworkInterfaces(ctx, subnetID); err != nil {
+		if errors.Is(err, wait.ErrTimeout) {
+			return rm.inUse(ctx, subnetID, err)
+		}
+		return err
+	}
+	if _, err := rm.client.DeleteSubnet(ctx, &ec2.DeleteSubnetInput{SubnetId: id}); err != nil {
+		if apierror.IsNotFound(err) {
+			return nil
+		}
+		if apierror.IsDependencyViolation(err) {
+			return rm.inUse(ctx, subnetID, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// releaseNetworkInterfaces deletes the detached network interfaces of the Subnet, waiting
+// for the attached ones to be released by the resources using them
+func (rm *manager) releaseNetworkInterfaces(ctx context.Context, subnetID string) error {
+	return wait.Until(ctx, pollInterval, releaseTimeout, func(ctx context.Context) (bool, error) {
+		enis, err := rm.networkInterfaces(ctx, subnetID)
+		if err != nil {
+			return false, err
+		}
+		released := true
+		for _, eni := range enis {
+			if eni.Status != types.NetworkInterfaceStatusAvailable {
+				released = false
+				continue
+			}
+			if _, err := rm.client.DeleteNetworkInterface(ctx, &ec2.DeleteNetworkInterfaceInput{
+				NetworkInterfaceId: eni.NetworkInterfaceId,
+			}); err != nil && !apierror.IsNotFound(err) {
+				return false, err
+			}
+		}
+		return released, nil
+	})
+}
+
+// inUse names the network interfaces still keeping the Subnet alive
+func (rm *manager) inUse(ctx context.Context, subnetID string, cause error) error {
+	enis, err := rm.networkInterfaces(ctx, subnetID)
+	if err != nil || len(enis) == 0 {
+		return fmt.Errorf("Subnet %s can't be deleted: %w", subnetID, cause)
+	}
+	inUse := make([]string, 0, len(enis))
+	for _, eni := range enis {
+		inUse = append(inUse, fmt.Sprintf("%s (%s)", aws.ToString(eni.NetworkInterfaceId), eni.Status))
+	}
+	return fmt.Errorf("Subnet %s can't be deleted, network interfaces still in use: %s: %w", subnetID, strings.Join(inUse, ", "), cause)
+}
+
+func (rm *manager) networkInterfaces(ctx context.Context, subnetID string) ([]types.NetworkInterface, error) {
+	var enis []types.NetworkInterface
+	paginator := ec2.NewDescribeNetworkInterfacesPaginator(rm.client, &ec2.DescribeNetworkInterfacesInput{
+		Filters: []types.Filter{{Name: aws.String("subnet-id"), Values: []string{subnetID}}},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		enis = append(enis, page.NetworkInterfaces...)
+	}
+	return enis, nil
+}
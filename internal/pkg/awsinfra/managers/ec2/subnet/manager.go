@@ -3,6 +3,7 @@ package ec2subnetmanager
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
@@ -10,8 +11,11 @@ import (
 	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra"
 )
 
+// availableTimeout is the maximum time to wait for a new Subnet to become available
+const availableTimeout = 5 * time.Minute
+
 // New Creates a new instsance of the resource manager
-func New(client *ec2.Client) awsinfra.ResourceManager[*ec2.CreateSubnetInput, *types.Subnet] {
+func New(client *ec2.Client) awsinfra.ResourceManager[*awsinfra.SubnetInput, *types.Subnet] {
 	return &manager{
 		client,
 	}
@@ -21,18 +25,71 @@ type manager struct {
 	client *ec2.Client
 }
 
-func (rm *manager) Create(input *ec2.CreateSubnetInput) (awsinfra.ExternalID, *types.Subnet, error) {
-	output, err := rm.client.CreateSubnet(context.TODO(), input)
+func (rm *manager) Create(ctx context.Context, input *awsinfra.SubnetInput) (awsinfra.ExternalID, *types.Subnet, error) {
+	output, err := rm.client.CreateSubnet(ctx, input.CreateSubnetInput)
 	if err != nil {
 		return aws.String(""), nil, err
 	}
-	return output.Subnet.SubnetId, output.Subnet, nil
+	subnetID := output.Subnet.SubnetId
+	if !needsReconcile(input) {
+		return subnetID, output.Subnet, nil
+	}
+	//Attributes and associations can only be changed once the Subnet is available
+	if err := ec2.NewSubnetAvailableWaiter(rm.client).Wait(ctx, &ec2.DescribeSubnetsInput{
+		SubnetIds: []string{*subnetID},
+	}, availableTimeout); err != nil {
+		return subnetID, output.Subnet, err
+	}
+	changes, err := rm.changes(ctx, input, output.Subnet)
+	if err != nil {
+		return subnetID, output.Subnet, err
+	}
+	if err := rm.apply(ctx, *subnetID, changes); err != nil {
+		return subnetID, output.Subnet, err
+	}
+	subnet, err := rm.Load(ctx, subnetID)
+	if err != nil {
+		return subnetID, output.Subnet, err
+	}
+	return subnetID, subnet, nil
+}
+
+// Update reconciles tags, public IP and IPv6 settings and the route table association in place.
+// A change of CIDR block, Availability Zone or VPC can't be applied to an existing Subnet,
+// so a new Subnet is created and its id returned; the last one is destroyed by awsinfra.Infra.Prune.
+// As both exist at the same time, a replacement in the same VPC needs a CIDR block not overlapping the last one.
+func (rm *manager) Update(ctx context.Context, input *awsinfra.SubnetInput, last *types.Subnet) (awsinfra.ExternalID, *types.Subnet, error) {
+	changes, err := rm.changes(ctx, input, last)
+	if err != nil {
+		return last.SubnetId, last, err
+	}
+	if changes.replace {
+		return rm.Create(ctx, input)
+	}
+	if changes.empty() {
+		return last.SubnetId, last, nil
+	}
+	if err := rm.apply(ctx, *last.SubnetId, changes); err != nil {
+		return last.SubnetId, last, err
+	}
+	subnet, err := rm.Load(ctx, last.SubnetId)
+	if err != nil {
+		return last.SubnetId, last, err
+	}
+	return subnet.SubnetId, subnet, nil
 }
-func (rm *manager) Update(input *ec2.CreateSubnetInput, last *types.Subnet) (awsinfra.ExternalID, *types.Subnet, error) {
-	return aws.String(""), &types.Subnet{}, fmt.Errorf("TODO: Need to implement")
+
+// Diff describes the changes Update would make, without making them
+func (rm *manager) Diff(ctx context.Context, input *awsinfra.SubnetInput, last *types.Subnet) ([]awsinfra.FieldChange, error) {
+	changes, err := rm.changes(ctx, input, last)
+	if err != nil {
+		return nil, err
+	}
+	return changes.fields, nil
 }
-func (rm *manager) Load(id awsinfra.ExternalID) (*types.Subnet, error) {
-	output, err := rm.client.DescribeSubnets(context.TODO(), &ec2.DescribeSubnetsInput{
+
+func (rm *manager) Load(ctx context.Context, id awsinfra.ExternalID) (*types.Subnet, error) {
+	output, err := rm.client.DescribeSubnets(ctx, &ec2.DescribeSubnetsInput{
 		SubnetIds: []string{*id},
 	})
 	if err != nil {
@@ -44,6 +101,7 @@ func (rm *manager) Load(id awsinfra.ExternalID) (*types.Subnet, error) {
 	return &output.Subnets[0], nil
 }
 
-func (rm *manager) Destroy(id awsinfra.ExternalID) error {
-	return fmt.Errorf("TODO: Need to implement")
+// needsReconcile reports whether the input has settings CreateSubnet can't apply
+func needsReconcile(input *awsinfra.SubnetInput) bool {
+	return input.MapPublicIpOnLaunch != nil || input.AssignIpv6AddressOnCreation != nil || aws.ToString(input.RouteTableId) != ""
 }
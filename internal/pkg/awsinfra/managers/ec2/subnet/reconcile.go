@@ -0,0 +1,245 @@
+package ec2subnetmanager
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra"
+	ec2tags "github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/managers/ec2/tags"
+)
+
+// subnetChanges are the operations needed to reconcile a Subnet with its input
+type subnetChanges struct {
+	replace                     bool
+	tags                        ec2tags.Changes
+	mapPublicIpOnLaunch         *bool
+	assignIpv6AddressOnCreation *bool
+	disassociateIpv6            []string //association ids
+	associateIpv6               *string
+	routeTable                  *routeTableChange
+	fields                      []awsinfra.FieldChange
+}
+
+// routeTableChange moves the Subnet to another route table
+type routeTableChange struct {
+	associationID string //current explicit association, empty when the Subnet uses the main route table
+	routeTableID  string //desired route table, empty to fall back to the main route table
+}
+
+func (c *subnetChanges) empty() bool {
+	return len(c.fields) == 0
+}
+
+// changes compares the input with the last Subnet. It only reads from the cloud provider.
+func (rm *manager) changes(ctx context.Context, input *awsinfra.SubnetInput, last *types.Subnet) (*subnetChanges, error) {
+	c := &subnetChanges{}
+	replacement := func(field string, desired *string, current *string) {
+		if desired != nil && aws.ToString(desired) != aws.ToString(current) {
+			c.replace = true
+			c.fields = append(c.fields, awsinfra.FieldChange{
+				Field:             field,
+				Current:           aws.ToString(current),
+				Desired:           aws.ToString(desired),
+				ForcesReplacement: true,
+			})
+		}
+	}
+	replacement("CidrBlock", input.CidrBlock, last.CidrBlock)
+	replacement("AvailabilityZone", input.AvailabilityZone, last.AvailabilityZone)
+	replacement("AvailabilityZoneId", input.AvailabilityZoneId, last.AvailabilityZoneId)
+	replacement("VpcId", input.VpcId, last.VpcId)
+	if c.replace {
+		if err := replaceable(input, last); err != nil {
+			return nil, err
+		}
+	}
+
+	desiredTags := ec2tags.Desired(input.TagSpecifications, types.ResourceTypeSubnet)
+	currentTags := ec2tags.Current(last.Tags)
+	if c.tags = ec2tags.Diff(desiredTags, currentTags); !c.tags.Empty() {
+		c.fields = append(c.fields, awsinfra.FieldChange{Field: "Tags", Current: currentTags, Desired: desiredTags})
+	}
+
+	if input.MapPublicIpOnLaunch != nil && *input.MapPublicIpOnLaunch != aws.ToBool(last.MapPublicIpOnLaunch) {
+		c.mapPublicIpOnLaunch = input.MapPublicIpOnLaunch
+		c.fields = append(c.fields, awsinfra.FieldChange{Field: "MapPublicIpOnLaunch", Current: aws.ToBool(last.MapPublicIpOnLaunch), Desired: *input.MapPublicIpOnLaunch})
+	}
+	if input.AssignIpv6AddressOnCreation != nil && *input.AssignIpv6AddressOnCreation != aws.ToBool(last.AssignIpv6AddressOnCreation) {
+		c.assignIpv6AddressOnCreation = input.AssignIpv6AddressOnCreation
+		c.fields = append(c.fields, awsinfra.FieldChange{Field: "AssignIpv6AddressOnCreation", Current: aws.ToBool(last.AssignIpv6AddressOnCreation), Desired: *input.AssignIpv6AddressOnCreation})
+	}
+
+	//A Subnet has at most one IPv6 block, an input without one leaves it untouched
+	if input.Ipv6CidrBlock != nil {
+		var currentIpv6 []string
+		matched := false
+		for _, assoc := range last.Ipv6CidrBlockAssociationSet {
+			if !activeIpv6(assoc) {
+				continue
+			}
+			currentIpv6 = append(currentIpv6, aws.ToString(assoc.Ipv6CidrBlock))
+			if aws.ToString(assoc.Ipv6CidrBlock) == *input.Ipv6CidrBlock {
+				matched = true
+				continue
+			}
+			c.disassociateIpv6 = append(c.disassociateIpv6, aws.ToString(assoc.AssociationId))
+		}
+		if !matched {
+			c.associateIpv6 = input.Ipv6CidrBlock
+			c.fields = append(c.fields, awsinfra.FieldChange{Field: "Ipv6CidrBlock", Current: currentIpv6, Desired: *input.Ipv6CidrBlock})
+		}
+	}
+
+	if input.RouteTableId != nil {
+		associationID, routeTableID, err := rm.routeTableAssociation(ctx, aws.ToString(last.SubnetId))
+		if err != nil {
+			return nil, err
+		}
+		if routeTableID != *input.RouteTableId {
+			c.routeTable = &routeTableChange{associationID: associationID, routeTableID: *input.RouteTableId}
+			c.fields = append(c.fields, awsinfra.FieldChange{Field: "RouteTableId", Current: routeTableID, Desired: *input.RouteTableId})
+		}
+	}
+	return c, nil
+}
+
+// replaceable checks that a replacement can be created while the last Subnet still exists,
+// which awsinfra.Infra.Prune only destroys afterwards: the CIDR blocks of Subnets of a VPC can't overlap
+func replaceable(input *awsinfra.SubnetInput, last *types.Subnet) error {
+	if input.VpcId != nil && aws.ToString(input.VpcId) != aws.ToString(last.VpcId) {
+		return nil
+	}
+	cidr := aws.ToString(last.CidrBlock)
+	if input.CidrBlock != nil {
+		cidr = *input.CidrBlock
+	}
+	if overlaps(cidr, aws.ToString(last.CidrBlock)) {
+		return fmt.Errorf("Subnet %s can't be replaced: the new CIDR block %s overlaps its %s in the same VPC, use a free CIDR block",
+			aws.ToString(last.SubnetId), cidr, aws.ToString(last.CidrBlock))
+	}
+	if input.Ipv6CidrBlock == nil {
+		return nil
+	}
+	for _, assoc := range last.Ipv6CidrBlockAssociationSet {
+		if activeIpv6(assoc) && overlaps(*input.Ipv6CidrBlock, aws.ToString(assoc.Ipv6CidrBlock)) {
+			return fmt.Errorf("Subnet %s can't be replaced: the new IPv6 CIDR block %s overlaps its %s in the same VPC, use a free CIDR block",
+				aws.ToString(last.SubnetId), *input.Ipv6CidrBlock, aws.ToString(assoc.Ipv6CidrBlock))
+		}
+	}
+	return nil
+}
+
+// overlaps reports whether two CIDR blocks share addresses
+func overlaps(a string, b string) bool {
+	pa, errA := netip.ParsePrefix(a)
+	pb, errB := netip.ParsePrefix(b)
+	return errA == nil && errB == nil && pa.Overlaps(pb)
+}
+
+// activeIpv6 reports whether an IPv6 association is in use or about to be
+func activeIpv6(assoc types.SubnetIpv6CidrBlockAssociation) bool {
+	return assoc.Ipv6CidrBlockState != nil && (assoc.Ipv6CidrBlockState.State == types.SubnetCidrBlockStateCodeAssociated ||
+		assoc.Ipv6CidrBlockState.State == types.SubnetCidrBlockStateCodeAssociating)
+}
+
+// routeTableAssociation returns the explicit route table association of the Subnet,
+// or empty strings when the Subnet uses the main route table
+func (rm *manager) routeTableAssociation(ctx context.Context, subnetID string) (string, string, error) {
+	output, err := rm.client.DescribeRouteTables(ctx, &ec2.DescribeRouteTablesInput{
+		Filters: []types.Filter{{Name: aws.String("association.subnet-id"), Values: []string{subnetID}}},
+	})
+	if err != nil {
+		return "", "", err
+	}
+	for _, rt := range output.RouteTables {
+		for _, assoc := range rt.Associations {
+			if aws.ToString(assoc.SubnetId) == subnetID {
+				return aws.ToString(assoc.RouteTableAssociationId), aws.ToString(rt.RouteTableId), nil
+			}
+		}
+	}
+	return "", "", nil
+}
+
+// apply makes the changes to the Subnet
+func (rm *manager) apply(ctx context.Context, subnetID string, c *subnetChanges) error {
+	if err := c.tags.Apply(ctx, rm.client, subnetID); err != nil {
+		return err
+	}
+	if c.mapPublicIpOnLaunch != nil {
+		if _, err := rm.client.ModifySubnetAttribute(ctx, &ec2.ModifySubnetAttributeInput{
+			SubnetId:            aws.String(subnetID),
+			MapPublicIpOnLaunch: &types.AttributeBooleanValue{Value: c.mapPublicIpOnLaunch},
+		}); err != nil {
+			return err
+		}
+	}
+	//IPv6 addresses can only be assigned on creation while the Subnet has an IPv6 block,
+	//so the block is associated before enabling it and disassociated after disabling it
+	disableIpv6 := c.assignIpv6AddressOnCreation != nil && !*c.assignIpv6AddressOnCreation
+	if disableIpv6 {
+		if err := rm.assignIpv6(ctx, subnetID, c.assignIpv6AddressOnCreation); err != nil {
+			return err
+		}
+	}
+	for _, associationID := range c.disassociateIpv6 {
+		if _, err := rm.client.DisassociateSubnetCidrBlock(ctx, &ec2.DisassociateSubnetCidrBlockInput{
+			AssociationId: aws.String(associationID),
+		}); err != nil {
+			return err
+		}
+	}
+	if c.associateIpv6 != nil {
+		if _, err := rm.client.AssociateSubnetCidrBlock(ctx, &ec2.AssociateSubnetCidrBlockInput{
+			SubnetId:      aws.String(subnetID),
+			Ipv6CidrBlock: c.associateIpv6,
+		}); err != nil {
+			return err
+		}
+	}
+	if c.assignIpv6AddressOnCreation != nil && !disableIpv6 {
+		if err := rm.assignIpv6(ctx, subnetID, c.assignIpv6AddressOnCreation); err != nil {
+			return err
+		}
+	}
+	if c.routeTable != nil {
+		if err := rm.moveRouteTable(ctx, subnetID, c.routeTable); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (rm *manager) assignIpv6(ctx context.Context, subnetID string, value *bool) error {
+	_, err := rm.client.ModifySubnetAttribute(ctx, &ec2.ModifySubnetAttributeInput{
+		SubnetId:                    aws.String(subnetID),
+		AssignIpv6AddressOnCreation: &types.AttributeBooleanValue{Value: value},
+	})
+	return err
+}
+
+func (rm *manager) moveRouteTable(ctx context.Context, subnetID string, change *routeTableChange) error {
+	switch {
+	case change.routeTableID == "":
+		_, err := rm.client.DisassociateRouteTable(ctx, &ec2.DisassociateRouteTableInput{
+			AssociationId: aws.String(change.associationID),
+		})
+		return err
+	case change.associationID == "":
+		_, err := rm.client.AssociateRouteTable(ctx, &ec2.AssociateRouteTableInput{
+			SubnetId:     aws.String(subnetID),
+			RouteTableId: aws.String(change.routeTableID),
+		})
+		return err
+	default:
+		_, err := rm.client.ReplaceRouteTableAssociation(ctx, &ec2.ReplaceRouteTableAssociationInput{
+			AssociationId: aws.String(change.associationID),
+			RouteTableId:  aws.String(change.routeTableID),
+		})
+		return err
+	}
+}
@@ -0,0 +1,102 @@
+package ec2tags
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// Desired returns the tags requested for the resource type in the tag specifications.
+// It returns nil when no specification targets the resource type, meaning tags are not managed.
+func Desired(specs []types.TagSpecification, resourceType types.ResourceType) map[string]string {
+	var desired map[string]string
+	for _, spec := range specs {
+		if spec.ResourceType != resourceType && spec.ResourceType != "" {
+			continue
+		}
+		if desired == nil {
+			desired = make(map[string]string)
+		}
+		for _, tag := range spec.Tags {
+			desired[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+		}
+	}
+	return desired
+}
+
+// Current returns the tags of a loaded resource as a map, skipping the reserved aws: tags
+func Current(tags []types.Tag) map[string]string {
+	current := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		if strings.HasPrefix(aws.ToString(tag.Key), "aws:") {
+			continue
+		}
+		current[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	return current
+}
+
+// Changes are the tag operations needed to reconcile a resource
+type Changes struct {
+	Create []types.Tag
+	Delete []types.Tag
+}
+
+// Diff computes the tags to create or overwrite and the tags to delete.
+// A nil desired map means tags are not managed, so nothing changes.
+func Diff(desired map[string]string, current map[string]string) Changes {
+	var changes Changes
+	if desired == nil {
+		return changes
+	}
+	for _, k := range sortedKeys(desired) {
+		if v, ok := current[k]; !ok || v != desired[k] {
+			changes.Create = append(changes.Create, types.Tag{Key: aws.String(k), Value: aws.String(desired[k])})
+		}
+	}
+	for _, k := range sortedKeys(current) {
+		if _, ok := desired[k]; !ok {
+			changes.Delete = append(changes.Delete, types.Tag{Key: aws.String(k)})
+		}
+	}
+	return changes
+}
+
+// Empty reports whether there is nothing to change
+func (c Changes) Empty() bool {
+	return len(c.Create) == 0 && len(c.Delete) == 0
+}
+
+// Apply creates and deletes the tags of the resource
+func (c Changes) Apply(ctx context.Context, client *ec2.Client, resourceID string) error {
+	if len(c.Create) > 0 {
+		if _, err := client.CreateTags(ctx, &ec2.CreateTagsInput{
+			Resources: []string{resourceID},
+			Tags:      c.Create,
+		}); err != nil {
+			return err
+		}
+	}
+	if len(c.Delete) > 0 {
+		if _, err := client.DeleteTags(ctx, &ec2.DeleteTagsInput{
+			Resources: []string{resourceID},
+			Tags:      c.Delete,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,260 @@
+package ec2vpcmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/managers/apierror"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/wait"
+)
+
+const (
+	// dependentsTimeout is the maximum time to wait for network interfaces to be released
+	dependentsTimeout = 5 * time.Minute
+	// pollInterval is the time between checks while waiting for dependents
+	pollInterval = 10 * time.Second
+)
+
+// BlockedError is returned by Destroy when resources still depending on the VPC keep it from being deleted
+type BlockedError struct {
+	VpcID    string
+	Blockers []string //the blocking resources, e.g. "subnet subnet-0a1b2c"
+	CausedBy error
+}
+
+// Error returns the VPC and the resources blocking its deletion
+func (e *BlockedError) Error() string {
+	msg := fmt.Sprintf("VPC %s can't be deleted, blocked by: %s", e.VpcID, strings.Join(e.Blockers, ", "))
+	if e.CausedBy != nil {
+		msg += fmt.Sprintf("; %v", e.CausedBy)
+	}
+	return msg
+}
+
+// Unwrap returns the error that revealed the blockers, nil when they were found before changing anything
+func (e *BlockedError) Unwrap() error {
+	return e.CausedBy
+}
+
+// Destroy removes what commonly keeps a VPC alive and then deletes it: detached network
+// interfaces are deleted while waiting for the attached ones to be released, internet
+// gateways are detached and the rules of the default security group revoked.
+// Resources that must be destroyed by their own managers, such as subnets, are not
+// touched; they are looked for first, and a *BlockedError names them before the VPC is changed.
+// Destroying a VPC that no longer exists succeeds.
+func (rm *manager) Destroy(ctx context.Context, id awsinfra.ExternalID) error {
+	vpcID := aws.ToString(id)
+	if _, err := rm.Load(ctx, id); err != nil {
+		if apierror.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	dependents, err := rm.dependents(ctx, vpcID)
+	if err != nil {
+		return err
+	}
+	if len(dependents) > 0 {
+		return &BlockedError{VpcID: vpcID, Blockers: dependents}
+	}
+	if err := rm.releaseNetworkInterfaces(ctx, vpcID); err != nil {
+		if errors.Is(err, wait.ErrTimeout) {
+			return rm.blocked(ctx, vpcID, err)
+		}
+		return err
+	}
+	if err := rm.detachInternetGateways(ctx, vpcID); err != nil {
+		if apierror.IsDependencyViolation(err) {
+			return rm.blocked(ctx, vpcID, err)
+		}
+		return err
+	}
+	if err := rm.revokeDefaultSecurityGroupRules(ctx, vpcID); err != nil {
+		return err
+	}
+	if _, err := rm.client.DeleteVpc(ctx, &ec2.DeleteVpcInput{VpcId: id}); err != nil {
+		if apierror.IsDependencyViolation(err) {
+			return rm.blocked(ctx, vpcID, err)
+		}
+		if apierror.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// releaseNetworkInterfaces deletes the detached network interfaces of the VPC, waiting
+// for the attached ones to be released by the resources using them
+func (rm *manager) releaseNetworkInterfaces(ctx context.Context, vpcID string) error {
+	return wait.Until(ctx, pollInterval, dependentsTimeout, func(ctx context.Context) (bool, error) {
+		enis, err := rm.networkInterfaces(ctx, vpcID)
+		if err != nil {
+			return false, err
+		}
+		released := true
+		for _, eni := range enis {
+			if eni.Status != types.NetworkInterfaceStatusAvailable {
+				released = false
+				continue
+			}
+			if _, err := rm.client.DeleteNetworkInterface(ctx, &ec2.DeleteNetworkInterfaceInput{
+				NetworkInterfaceId: eni.NetworkInterfaceId,
+			}); err != nil && !apierror.IsNotFound(err) {
+				return false, err
+			}
+		}
+		return released, nil
+	})
+}
+
+func (rm *manager) detachInternetGateways(ctx context.Context, vpcID string) error {
+	igws, err := rm.internetGateways(ctx, vpcID)
+	if err != nil {
+		return err
+	}
+	for _, igw := range igws {
+		if _, err := rm.client.DetachInternetGateway(ctx, &ec2.DetachInternetGatewayInput{
+			InternetGatewayId: igw.InternetGatewayId,
+			VpcId:             aws.String(vpcID),
+		}); err != nil && !apierror.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// revokeDefaultSecurityGroupRules empties the default security group, so no rule references other groups
+func (rm *manager) revokeDefaultSecurityGroupRules(ctx context.Context, vpcID string) error {
+	output, err := rm.client.DescribeSecurityGroups(ctx, &ec2.DescribeSecurityGroupsInput{
+		Filters: []types.Filter{
+			{Name: aws.String("vpc-id"), Values: []string{vpcID}},
+			{Name: aws.String("group-name"), Values: []string{"default"}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	for _, sg := range output.SecurityGroups {
+		if len(sg.IpPermissions) > 0 {
+			if _, err := rm.client.RevokeSecurityGroupIngress(ctx, &ec2.RevokeSecurityGroupIngressInput{
+				GroupId:       sg.GroupId,
+				IpPermissions: sg.IpPermissions,
+			}); err != nil {
+				return err
+			}
+		}
+		if len(sg.IpPermissionsEgress) > 0 {
+			if _, err := rm.client.RevokeSecurityGroupEgress(ctx, &ec2.RevokeSecurityGroupEgressInput{
+				GroupId:       sg.GroupId,
+				IpPermissions: sg.IpPermissionsEgress,
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// blocked lists the resources keeping the VPC alive
+func (rm *manager) blocked(ctx context.Context, vpcID string, cause error) error {
+	blocked := &BlockedError{VpcID: vpcID, CausedBy: cause}
+	if enis, err := rm.networkInterfaces(ctx, vpcID); err == nil {
+		for _, eni := range enis {
+			blocked.Blockers = append(blocked.Blockers, fmt.Sprintf("network interface %s (%s)", aws.ToString(eni.NetworkInterfaceId), eni.Status))
+		}
+	}
+	if igws, err := rm.internetGateways(ctx, vpcID); err == nil {
+		for _, igw := range igws {
+			blocked.Blockers = append(blocked.Blockers, fmt.Sprintf("internet gateway %s", aws.ToString(igw.InternetGatewayId)))
+		}
+	}
+	//Best effort, the cause is reported anyway
+	dependents, _ := rm.dependents(ctx, vpcID)
+	blocked.Blockers = append(blocked.Blockers, dependents...)
+	return blocked
+}
+
+// dependents lists the resources of the VPC destroyed by their own managers: subnets, security groups
+// other than the default one, route tables other than the main one and NAT gateways.
+// On error it returns the ones found so far.
+func (rm *manager) dependents(ctx context.Context, vpcID string) ([]string, error) {
+	var dependents []string
+	filter := []types.Filter{{Name: aws.String("vpc-id"), Values: []string{vpcID}}}
+	subnets, err := rm.client.DescribeSubnets(ctx, &ec2.DescribeSubnetsInput{Filters: filter})
+	if err != nil {
+		return dependents, err
+	}
+	for _, subnet := range subnets.Subnets {
+		dependents = append(dependents, fmt.Sprintf("subnet %s", aws.ToString(subnet.SubnetId)))
+	}
+	groups, err := rm.client.DescribeSecurityGroups(ctx, &ec2.DescribeSecurityGroupsInput{Filters: filter})
+	if err != nil {
+		return dependents, err
+	}
+	for _, sg := range groups.SecurityGroups {
+		if aws.ToString(sg.GroupName) != "default" {
+			dependents = append(dependents, fmt.Sprintf("security group %s", aws.ToString(sg.GroupId)))
+		}
+	}
+	routeTables, err := rm.client.DescribeRouteTables(ctx, &ec2.DescribeRouteTablesInput{Filters: filter})
+	if err != nil {
+		return dependents, err
+	}
+	for _, rt := range routeTables.RouteTables {
+		if !isMainRouteTable(rt) {
+			dependents = append(dependents, fmt.Sprintf("route table %s", aws.ToString(rt.RouteTableId)))
+		}
+	}
+	nats, err := rm.client.DescribeNatGateways(ctx, &ec2.DescribeNatGatewaysInput{Filter: filter})
+	if err != nil {
+		return dependents, err
+	}
+	for _, nat := range nats.NatGateways {
+		if nat.State != types.NatGatewayStateDeleted {
+			dependents = append(dependents, fmt.Sprintf("NAT gateway %s", aws.ToString(nat.NatGatewayId)))
+		}
+	}
+	return dependents, nil
+}
+
+func (rm *manager) networkInterfaces(ctx context.Context, vpcID string) ([]types.NetworkInterface, error) {
+	var enis []types.NetworkInterface
+	paginator := ec2.NewDescribeNetworkInterfacesPaginator(rm.client, &ec2.DescribeNetworkInterfacesInput{
+		Filters: []types.Filter{{Name: aws.String("vpc-id"), Values: []string{vpcID}}},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		enis = append(enis, page.NetworkInterfaces...)
+	}
+	return enis, nil
+}
+
+func (rm *manager) internetGateways(ctx context.Context, vpcID string) ([]types.InternetGateway, error) {
+	output, err := rm.client.DescribeInternetGateways(ctx, &ec2.DescribeInternetGatewaysInput{
+		Filters: []types.Filter{{Name: aws.String("attachment.vpc-id"), Values: []string{vpcID}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return output.InternetGateways, nil
+}
+
+func isMainRouteTable(rt types.RouteTable) bool {
+	for _, assoc := range rt.Associations {
+		if aws.ToBool(assoc.Main) {
+			return true
+		}
+	}
+	return false
+}
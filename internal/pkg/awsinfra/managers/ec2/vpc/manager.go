@@ -3,6 +3,7 @@ package ec2vpcmanager
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
@@ -10,8 +11,11 @@ import (
 	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra"
 )
 
+// availableTimeout is the maximum time to wait for a new VPC to become available
+const availableTimeout = 5 * time.Minute
+
 // New Creates a new instsance of the resource manager
-func New(client *ec2.Client) awsinfra.ResourceManager[*ec2.CreateVpcInput, *types.Vpc] {
+func New(client *ec2.Client) awsinfra.ResourceManager[*awsinfra.VPCInput, *types.Vpc] {
 	return &manager{
 		client,
 	}
@@ -21,18 +25,70 @@ type manager struct {
 	client *ec2.Client
 }
 
-func (rm *manager) Create(input *ec2.CreateVpcInput) (awsinfra.ExternalID, *types.Vpc, error) {
-	output, err := rm.client.CreateVpc(context.TODO(), input)
+func (rm *manager) Create(ctx context.Context, input *awsinfra.VPCInput) (awsinfra.ExternalID, *types.Vpc, error) {
+	output, err := rm.client.CreateVpc(ctx, input.CreateVpcInput)
 	if err != nil {
 		return aws.String(""), nil, err
 	}
-	return output.Vpc.VpcId, output.Vpc, nil
+	vpcID := output.Vpc.VpcId
+	if !needsReconcile(input) {
+		return vpcID, output.Vpc, nil
+	}
+	//Attributes and associations can only be changed once the VPC is available
+	if err := ec2.NewVpcAvailableWaiter(rm.client).Wait(ctx, &ec2.DescribeVpcsInput{
+		VpcIds: []string{*vpcID},
+	}, availableTimeout); err != nil {
+		return vpcID, output.Vpc, err
+	}
+	changes, err := rm.changes(ctx, input, output.Vpc)
+	if err != nil {
+		return vpcID, output.Vpc, err
+	}
+	if err := rm.apply(ctx, *vpcID, changes); err != nil {
+		return vpcID, output.Vpc, err
+	}
+	vpc, err := rm.Load(ctx, vpcID)
+	if err != nil {
+		return vpcID, output.Vpc, err
+	}
+	return vpcID, vpc, nil
+}
+
+// Update reconciles tags, DNS attributes, tenancy and CIDR associations in place.
+// A change of the primary CIDR block can't be applied to an existing VPC, so a new
+// VPC is created and its id returned; the last one is destroyed by awsinfra.Infra.Prune.
+func (rm *manager) Update(ctx context.Context, input *awsinfra.VPCInput, last *types.Vpc) (awsinfra.ExternalID, *types.Vpc, error) {
+	changes, err := rm.changes(ctx, input, last)
+	if err != nil {
+		return last.VpcId, last, err
+	}
+	if changes.replace {
+		return rm.Create(ctx, input)
+	}
+	if changes.empty() {
+		return last.VpcId, last, nil
+	}
+	if err := rm.apply(ctx, *last.VpcId, changes); err != nil {
+		return last.VpcId, last, err
+	}
+	vpc, err := rm.Load(ctx, last.VpcId)
+	if err != nil {
+		return last.VpcId, last, err
+	}
+	return vpc.VpcId, vpc, nil
 }
-func (rm *manager) Update(input *ec2.CreateVpcInput, last *types.Vpc) (awsinfra.ExternalID, *types.Vpc, error) {
-	return aws.String(""), &types.Vpc{}, fmt.Errorf("TODO: Need to implement")
+
+// Diff describes the changes Update would make, without making them
+func (rm *manager) Diff(ctx context.Context, input *awsinfra.VPCInput, last *types.Vpc) ([]awsinfra.FieldChange, error) {
+	changes, err := rm.changes(ctx, input, last)
+	if err != nil {
+		return nil, err
+	}
+	return changes.fields, nil
 }
-func (rm *manager) Load(id awsinfra.ExternalID) (*types.Vpc, error) {
-	output, err := rm.client.DescribeVpcs(context.TODO(), &ec2.DescribeVpcsInput{
+
+func (rm *manager) Load(ctx context.Context, id awsinfra.ExternalID) (*types.Vpc, error) {
+	output, err := rm.client.DescribeVpcs(ctx, &ec2.DescribeVpcsInput{
 		VpcIds: []string{string(*id)},
 	})
 	if err != nil {
@@ -44,6 +100,7 @@ func (rm *manager) Load(id awsinfra.ExternalID) (*types.Vpc, error) {
 	return &output.Vpcs[0], nil
 }
 
-func (rm *manager) Destroy(id awsinfra.ExternalID) error {
-	return fmt.Errorf("TODO: Need to implement")
+// needsReconcile reports whether the input has settings CreateVpc can't apply
+func needsReconcile(input *awsinfra.VPCInput) bool {
+	return input.EnableDnsSupport != nil || input.EnableDnsHostnames != nil || len(input.SecondaryCidrBlocks) > 0
 }
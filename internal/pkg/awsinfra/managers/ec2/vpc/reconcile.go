@@ -0,0 +1,285 @@
+package ec2vpcmanager
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra"
+	ec2tags "github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/managers/ec2/tags"
+)
+
+// vpcChanges are the operations needed to reconcile a VPC with its input
+type vpcChanges struct {
+	replace            bool
+	tags               ec2tags.Changes
+	tenancy            types.VpcTenancy
+	enableDnsSupport   *bool
+	enableDnsHostnames *bool
+	associateCidrs     []string
+	disassociateCidrs  []string //association ids
+	associateIpv6      *ec2.AssociateVpcCidrBlockInput
+	disassociateIpv6   []string //association ids
+	fields             []awsinfra.FieldChange
+}
+
+func (c *vpcChanges) empty() bool {
+	return len(c.fields) == 0
+}
+
+// changes compares the input with the last VPC. It only reads from the cloud provider.
+func (rm *manager) changes(ctx context.Context, input *awsinfra.VPCInput, last *types.Vpc) (*vpcChanges, error) {
+	c := &vpcChanges{}
+	if input.CidrBlock != nil && aws.ToString(input.CidrBlock) != aws.ToString(last.CidrBlock) {
+		c.replace = true
+		c.fields = append(c.fields, awsinfra.FieldChange{
+			Field:             "CidrBlock",
+			Current:           aws.ToString(last.CidrBlock),
+			Desired:           aws.ToString(input.CidrBlock),
+			ForcesReplacement: true,
+		})
+	}
+
+	desiredTags := ec2tags.Desired(input.TagSpecifications, types.ResourceTypeVpc)
+	currentTags := ec2tags.Current(last.Tags)
+	if c.tags = ec2tags.Diff(desiredTags, currentTags); !c.tags.Empty() {
+		c.fields = append(c.fields, awsinfra.FieldChange{Field: "Tags", Current: currentTags, Desired: desiredTags})
+	}
+
+	if input.InstanceTenancy != "" && string(input.InstanceTenancy) != string(last.InstanceTenancy) {
+		if input.InstanceTenancy != types.TenancyDefault {
+			return nil, fmt.Errorf("VPC %s tenancy can only be changed to %s", aws.ToString(last.VpcId), types.TenancyDefault)
+		}
+		c.tenancy = types.VpcTenancyDefault
+		c.fields = append(c.fields, awsinfra.FieldChange{Field: "InstanceTenancy", Current: last.InstanceTenancy, Desired: input.InstanceTenancy})
+	}
+
+	var err error
+	if c.enableDnsSupport, err = rm.attributeChange(ctx, last.VpcId, types.VpcAttributeNameEnableDnsSupport, input.EnableDnsSupport, &c.fields); err != nil {
+		return nil, err
+	}
+	if c.enableDnsHostnames, err = rm.attributeChange(ctx, last.VpcId, types.VpcAttributeNameEnableDnsHostnames, input.EnableDnsHostnames, &c.fields); err != nil {
+		return nil, err
+	}
+
+	if input.SecondaryCidrBlocks != nil {
+		current := make(map[string]string) //cidr to association id
+		for _, assoc := range last.CidrBlockAssociationSet {
+			cidr := aws.ToString(assoc.CidrBlock)
+			if cidr == aws.ToString(last.CidrBlock) || !activeCidr(assoc.CidrBlockState) {
+				continue
+			}
+			current[cidr] = aws.ToString(assoc.AssociationId)
+		}
+		desired := make(map[string]bool, len(input.SecondaryCidrBlocks))
+		for _, cidr := range input.SecondaryCidrBlocks {
+			desired[cidr] = true
+			if _, ok := current[cidr]; !ok {
+				c.associateCidrs = append(c.associateCidrs, cidr)
+			}
+		}
+		currentCidrs := make([]string, 0, len(current))
+		for cidr, associationID := range current {
+			currentCidrs = append(currentCidrs, cidr)
+			if !desired[cidr] {
+				c.disassociateCidrs = append(c.disassociateCidrs, associationID)
+			}
+		}
+		sort.Strings(currentCidrs)
+		sort.Strings(c.disassociateCidrs)
+		if len(c.associateCidrs) > 0 || len(c.disassociateCidrs) > 0 {
+			desiredCidrs := append([]string{}, input.SecondaryCidrBlocks...)
+			sort.Strings(desiredCidrs)
+			c.fields = append(c.fields, awsinfra.FieldChange{Field: "SecondaryCidrBlocks", Current: currentCidrs, Desired: desiredCidrs})
+		}
+	}
+
+	if err := rm.ipv6Changes(ctx, input, last, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// attributeChange returns the value to set on a DNS attribute, or nil if it already matches
+func (rm *manager) attributeChange(ctx context.Context, vpcID *string, attribute types.VpcAttributeName, desired *bool, fields *[]awsinfra.FieldChange) (*bool, error) {
+	if desired == nil {
+		return nil, nil
+	}
+	output, err := rm.client.DescribeVpcAttribute(ctx, &ec2.DescribeVpcAttributeInput{
+		VpcId:     vpcID,
+		Attribute: attribute,
+	})
+	if err != nil {
+		return nil, err
+	}
+	value := output.EnableDnsSupport
+	field := "EnableDnsSupport"
+	if attribute == types.VpcAttributeNameEnableDnsHostnames {
+		value = output.EnableDnsHostnames
+		field = "EnableDnsHostnames"
+	}
+	current := value != nil && aws.ToBool(value.Value)
+	if current == *desired {
+		return nil, nil
+	}
+	*fields = append(*fields, awsinfra.FieldChange{Field: field, Current: current, Desired: *desired})
+	return desired, nil
+}
+
+// ipv6Changes compares the IPv6 block requested by the input with the associated ones.
+// IPv6 is only reconciled when the input sets AmazonProvidedIpv6CidrBlock, Ipv6Pool or Ipv6IpamPoolId;
+// AmazonProvidedIpv6CidrBlock set to false alone removes the IPv6 blocks.
+// A VPC has at most one IPv6 block from each source, the others are disassociated.
+func (rm *manager) ipv6Changes(ctx context.Context, input *awsinfra.VPCInput, last *types.Vpc, c *vpcChanges) error {
+	if input.AmazonProvidedIpv6CidrBlock == nil && input.Ipv6Pool == nil && input.Ipv6IpamPoolId == nil {
+		return nil
+	}
+	wantAmazon := aws.ToBool(input.AmazonProvidedIpv6CidrBlock)
+	wantPool := input.Ipv6Pool != nil
+	wantIpam := input.Ipv6IpamPoolId != nil
+	var ipamCidrs map[string]bool
+	if wantIpam {
+		var err error
+		if ipamCidrs, err = rm.ipamCidrs(ctx, aws.ToString(input.Ipv6IpamPoolId), aws.ToString(last.VpcId)); err != nil {
+			return err
+		}
+	}
+	matches := func(assoc types.VpcIpv6CidrBlockAssociation) bool {
+		if input.Ipv6CidrBlock != nil && aws.ToString(assoc.Ipv6CidrBlock) != aws.ToString(input.Ipv6CidrBlock) {
+			return false
+		}
+		switch {
+		case wantAmazon:
+			return aws.ToString(assoc.Ipv6Pool) == "Amazon"
+		case wantPool:
+			return aws.ToString(assoc.Ipv6Pool) == aws.ToString(input.Ipv6Pool)
+		case wantIpam:
+			return ipamCidrs[aws.ToString(assoc.Ipv6CidrBlock)]
+		}
+		return false
+	}
+	matched := false
+	var current []string
+	for _, assoc := range last.Ipv6CidrBlockAssociationSet {
+		if !activeCidr(assoc.Ipv6CidrBlockState) {
+			continue
+		}
+		current = append(current, aws.ToString(assoc.Ipv6CidrBlock))
+		if !matched && matches(assoc) {
+			matched = true
+			continue
+		}
+		c.disassociateIpv6 = append(c.disassociateIpv6, aws.ToString(assoc.AssociationId))
+	}
+	if (wantAmazon || wantPool || wantIpam) && !matched {
+		c.associateIpv6 = &ec2.AssociateVpcCidrBlockInput{
+			VpcId:                           last.VpcId,
+			AmazonProvidedIpv6CidrBlock:     input.AmazonProvidedIpv6CidrBlock,
+			Ipv6CidrBlock:                   input.Ipv6CidrBlock,
+			Ipv6CidrBlockNetworkBorderGroup: input.Ipv6CidrBlockNetworkBorderGroup,
+			Ipv6IpamPoolId:                  input.Ipv6IpamPoolId,
+			Ipv6NetmaskLength:               input.Ipv6NetmaskLength,
+			Ipv6Pool:                        input.Ipv6Pool,
+		}
+	}
+	if c.associateIpv6 != nil || len(c.disassociateIpv6) > 0 {
+		var desired any
+		switch {
+		case wantAmazon:
+			desired = "Amazon"
+		case wantPool:
+			desired = aws.ToString(input.Ipv6Pool)
+		case wantIpam:
+			desired = aws.ToString(input.Ipv6IpamPoolId)
+		}
+		c.fields = append(c.fields, awsinfra.FieldChange{Field: "Ipv6CidrBlock", Current: current, Desired: desired})
+	}
+	return nil
+}
+
+// ipamCidrs returns the CIDR blocks the IPAM pool allocated to the VPC
+func (rm *manager) ipamCidrs(ctx context.Context, poolID string, vpcID string) (map[string]bool, error) {
+	cidrs := make(map[string]bool)
+	paginator := ec2.NewGetIpamPoolAllocationsPaginator(rm.client, &ec2.GetIpamPoolAllocationsInput{IpamPoolId: aws.String(poolID)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, allocation := range page.IpamPoolAllocations {
+			if aws.ToString(allocation.ResourceId) == vpcID {
+				cidrs[aws.ToString(allocation.Cidr)] = true
+			}
+		}
+	}
+	return cidrs, nil
+}
+
+// apply makes the changes to the VPC
+func (rm *manager) apply(ctx context.Context, vpcID string, c *vpcChanges) error {
+	if err := c.tags.Apply(ctx, rm.client, vpcID); err != nil {
+		return err
+	}
+	if c.tenancy != "" {
+		if _, err := rm.client.ModifyVpcTenancy(ctx, &ec2.ModifyVpcTenancyInput{
+			VpcId:           aws.String(vpcID),
+			InstanceTenancy: c.tenancy,
+		}); err != nil {
+			return err
+		}
+	}
+	//DNS hostnames require DNS support, so support is enabled first and disabled last
+	if c.enableDnsSupport != nil && *c.enableDnsSupport {
+		if err := rm.modifyAttribute(ctx, vpcID, &ec2.ModifyVpcAttributeInput{EnableDnsSupport: &types.AttributeBooleanValue{Value: c.enableDnsSupport}}); err != nil {
+			return err
+		}
+	}
+	if c.enableDnsHostnames != nil {
+		if err := rm.modifyAttribute(ctx, vpcID, &ec2.ModifyVpcAttributeInput{EnableDnsHostnames: &types.AttributeBooleanValue{Value: c.enableDnsHostnames}}); err != nil {
+			return err
+		}
+	}
+	if c.enableDnsSupport != nil && !*c.enableDnsSupport {
+		if err := rm.modifyAttribute(ctx, vpcID, &ec2.ModifyVpcAttributeInput{EnableDnsSupport: &types.AttributeBooleanValue{Value: c.enableDnsSupport}}); err != nil {
+			return err
+		}
+	}
+	for _, associationID := range append(c.disassociateCidrs, c.disassociateIpv6...) {
+		if _, err := rm.client.DisassociateVpcCidrBlock(ctx, &ec2.DisassociateVpcCidrBlockInput{
+			AssociationId: aws.String(associationID),
+		}); err != nil {
+			return err
+		}
+	}
+	for _, cidr := range c.associateCidrs {
+		if _, err := rm.client.AssociateVpcCidrBlock(ctx, &ec2.AssociateVpcCidrBlockInput{
+			VpcId:     aws.String(vpcID),
+			CidrBlock: aws.String(cidr),
+		}); err != nil {
+			return err
+		}
+	}
+	if c.associateIpv6 != nil {
+		if _, err := rm.client.AssociateVpcCidrBlock(ctx, c.associateIpv6); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (rm *manager) modifyAttribute(ctx context.Context, vpcID string, input *ec2.ModifyVpcAttributeInput) error {
+	input.VpcId = aws.String(vpcID)
+	_, err := rm.client.ModifyVpcAttribute(ctx, input)
+	return err
+}
+
+// activeCidr reports whether a CIDR association is in use or about to be
+func activeCidr(state *types.VpcCidrBlockState) bool {
+	if state == nil {
+		return false
+	}
+	return state.State == types.VpcCidrBlockStateCodeAssociated || state.State == types.VpcCidrBlockStateCodeAssociating
+}
@@ -0,0 +1,47 @@
+package elbv2actions
+
+import (
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra"
+)
+
+// Diff compares the desired actions of a listener or rule with the current ones.
+// It returns the change of the field, or nil when every value set in desired has the same current value.
+func Diff(field string, desired []types.Action, current []types.Action) ([]awsinfra.FieldChange, error) {
+	if desired == nil {
+		return nil, nil
+	}
+	d, c := normalize(desired), normalize(current)
+	if len(d) != len(c) {
+		return []awsinfra.FieldChange{{Field: field, Current: current, Desired: desired}}, nil
+	}
+	changes, err := awsinfra.DiffFields(map[string]any{field: d}, map[string]any{field: c})
+	if err != nil || len(changes) == 0 {
+		return nil, err
+	}
+	return []awsinfra.FieldChange{{Field: field, Current: current, Desired: desired}}, nil
+}
+
+// normalize copies the actions, sorted by order, with the target groups of forward actions sorted by ARN
+func normalize(actions []types.Action) []types.Action {
+	normalized := make([]types.Action, len(actions))
+	copy(normalized, actions)
+	sort.SliceStable(normalized, func(i, j int) bool {
+		return aws.ToInt32(normalized[i].Order) < aws.ToInt32(normalized[j].Order)
+	})
+	for i, action := range normalized {
+		if action.ForwardConfig == nil {
+			continue
+		}
+		forward := *action.ForwardConfig
+		forward.TargetGroups = append([]types.TargetGroupTuple{}, forward.TargetGroups...)
+		sort.Slice(forward.TargetGroups, func(i, j int) bool {
+			return aws.ToString(forward.TargetGroups[i].TargetGroupArn) < aws.ToString(forward.TargetGroups[j].TargetGroupArn)
+		})
+		normalized[i].ForwardConfig = &forward
+	}
+	return normalized
+}
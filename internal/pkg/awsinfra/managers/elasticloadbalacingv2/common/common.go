@@ -0,0 +1,53 @@
+// Package elbv2common holds the helpers shared by the Elastic Load Balancing v2 resource managers
+package elbv2common
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra"
+)
+
+// AttributeChanges returns the attributes of desired whose current value differs, nil when none does,
+// and their changes. Attributes left out of desired keep their current value.
+func AttributeChanges(desired map[string]string, current map[string]string) (map[string]string, []awsinfra.FieldChange) {
+	var changed map[string]string
+	var fields []awsinfra.FieldChange
+	for _, key := range SortedKeys(desired) {
+		if value, ok := current[key]; !ok || value != desired[key] {
+			if changed == nil {
+				changed = make(map[string]string)
+			}
+			changed[key] = desired[key]
+			fields = append(fields, awsinfra.FieldChange{Field: "Attributes." + key, Current: value, Desired: desired[key]})
+		}
+	}
+	return changed, fields
+}
+
+// SameNameReplacement returns an error when the changes force a replacement and the name stays lastName.
+// kind names the resource in the error.
+func SameNameReplacement(kind string, name *string, lastName string, fields []awsinfra.FieldChange) error {
+	var replaced []string
+	for _, field := range fields {
+		if field.ForcesReplacement {
+			replaced = append(replaced, field.Field)
+		}
+	}
+	if len(replaced) == 0 || (name != nil && *name != lastName) {
+		return nil
+	}
+	return fmt.Errorf("%s %s can't be replaced under the same name, set a new Name to change %s",
+		kind, lastName, strings.Join(replaced, ", "))
+}
+
+// SortedKeys returns the keys of the map in order
+func SortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
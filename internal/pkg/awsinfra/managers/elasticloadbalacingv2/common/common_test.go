@@ -0,0 +1,53 @@
+package elbv2common
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSameNameReplacement(t *testing.T) {
+	scheme := awsinfra.FieldChange{Field: "Scheme", Current: "internet-facing", Desired: "internal", ForcesReplacement: true}
+	name := awsinfra.FieldChange{Field: "Name", Current: "web", Desired: "web-internal", ForcesReplacement: true}
+	subnets := awsinfra.FieldChange{Field: "Subnets"}
+	testCases := []struct {
+		name    string
+		input   *string
+		fields  []awsinfra.FieldChange
+		wantErr string
+	}{
+		{name: "no replacement", input: aws.String("web"), fields: []awsinfra.FieldChange{subnets}},
+		{name: "same name", input: aws.String("web"), fields: []awsinfra.FieldChange{scheme, subnets},
+			wantErr: "LoadBalancer web can't be replaced under the same name, set a new Name to change Scheme"},
+		{name: "name left out", fields: []awsinfra.FieldChange{scheme}, wantErr: "set a new Name to change Scheme"},
+		{name: "new name", input: aws.String("web-internal"), fields: []awsinfra.FieldChange{name, scheme}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := SameNameReplacement("LoadBalancer", tc.input, "web", tc.fields)
+			if tc.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tc.wantErr)
+		})
+	}
+}
+
+func TestAttributeChanges(t *testing.T) {
+	changed, fields := AttributeChanges(
+		map[string]string{"b": "2", "a": "1", "c": "3"},
+		map[string]string{"a": "1", "b": "1", "d": "4"},
+	)
+	assert.Equal(t, map[string]string{"b": "2", "c": "3"}, changed)
+	assert.Equal(t, []awsinfra.FieldChange{
+		{Field: "Attributes.b", Current: "1", Desired: "2"},
+		{Field: "Attributes.c", Current: "", Desired: "3"},
+	}, fields)
+
+	changed, fields = AttributeChanges(nil, map[string]string{"a": "1"})
+	assert.Nil(t, changed)
+	assert.Nil(t, fields)
+}
@@ -0,0 +1,123 @@
+package elasticloadbalancingv2listenermanager
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/managers/apierror"
+)
+
+// New Creates a new instsance of the resource manager
+func New(client *elasticloadbalancingv2.Client) awsinfra.ResourceManager[*awsinfra.ListenerInput, *awsinfra.Listener] {
+	return &manager{
+		client,
+	}
+}
+
+type manager struct {
+	client *elasticloadbalancingv2.Client
+}
+
+// Create creates the listener and adds its extra certificates, its ARN is the ExternalID
+func (rm *manager) Create(ctx context.Context, input *awsinfra.ListenerInput) (awsinfra.ExternalID, *awsinfra.Listener, error) {
+	output, err := rm.client.CreateListener(ctx, input.CreateListenerInput)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(output.Listeners) == 0 {
+		return nil, nil, fmt.Errorf("Listener on port %d was not created", aws.ToInt32(input.Port))
+	}
+	arn := output.Listeners[0].ListenerArn
+	if len(input.ExtraCertificates) > 0 {
+		if err := rm.addCertificates(ctx, arn, input.ExtraCertificates); err != nil {
+			return arn, &awsinfra.Listener{Listener: output.Listeners[0]}, err
+		}
+	}
+	listener, err := rm.Load(ctx, arn)
+	if err != nil {
+		return arn, &awsinfra.Listener{Listener: output.Listeners[0]}, err
+	}
+	return arn, listener, nil
+}
+
+// Update modifies the changed settings of the listener in place.
+// A listener can't be moved to another load balancer, so a new one is created instead
+// and its ARN returned; the last one is destroyed by awsinfra.Infra.Prune.
+func (rm *manager) Update(ctx context.Context, input *awsinfra.ListenerInput, last *awsinfra.Listener) (awsinfra.ExternalID, *awsinfra.Listener, error) {
+	changes, err := rm.changes(ctx, input, last)
+	if err != nil {
+		return last.ListenerArn, last, err
+	}
+	if changes.replace {
+		return rm.Create(ctx, input)
+	}
+	if changes.empty() {
+		return last.ListenerArn, last, nil
+	}
+	if err := rm.apply(ctx, last, changes); err != nil {
+		return last.ListenerArn, last, err
+	}
+	listener, err := rm.Load(ctx, last.ListenerArn)
+	if err != nil {
+		return last.ListenerArn, last, err
+	}
+	return listener.ListenerArn, listener, nil
+}
+
+// Diff describes the changes Update would make, without making them
+func (rm *manager) Diff(ctx context.Context, input *awsinfra.ListenerInput, last *awsinfra.Listener) ([]awsinfra.FieldChange, error) {
+	changes, err := rm.changes(ctx, input, last)
+	if err != nil {
+		return nil, err
+	}
+	return changes.fields, nil
+}
+
+func (rm *manager) Load(ctx context.Context, id awsinfra.ExternalID) (*awsinfra.Listener, error) {
+	output, err := rm.client.DescribeListeners(ctx, &elasticloadbalancingv2.DescribeListenersInput{
+		ListenerArns: []string{*id},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(output.Listeners) == 0 {
+		return nil, fmt.Errorf("Listener %s not found", *id)
+	}
+	listener := &awsinfra.Listener{Listener: output.Listeners[0]}
+	if listener.Protocol != types.ProtocolEnumHttps && listener.Protocol != types.ProtocolEnumTls {
+		return listener, nil
+	}
+	input := &elasticloadbalancingv2.DescribeListenerCertificatesInput{ListenerArn: id}
+	for {
+		page, err := rm.client.DescribeListenerCertificates(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		for _, certificate := range page.Certificates {
+			if !aws.ToBool(certificate.IsDefault) {
+				listener.ExtraCertificates = append(listener.ExtraCertificates, aws.ToString(certificate.CertificateArn))
+			}
+		}
+		if page.NextMarker == nil {
+			break
+		}
+		input.Marker = page.NextMarker
+	}
+	return listener, nil
+}
+
+// Destroy deletes the listener and its rules.
+// Destroying a listener that no longer exists succeeds.
+func (rm *manager) Destroy(ctx context.Context, id awsinfra.ExternalID) error {
+	_, err := rm.client.DeleteListener(ctx, &elasticloadbalancingv2.DeleteListenerInput{
+		ListenerArn: id,
+	})
+	if apierror.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,163 @@
+package elasticloadbalancingv2listenermanager
+
+import (
+	"context"
+	"slices"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra"
+	elbv2actions "github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/managers/elasticloadbalacingv2/actions"
+	elbv2tags "github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/managers/elasticloadbalacingv2/tags"
+)
+
+// certificatesBatch is the maximum number of certificates added or removed by a single call
+const certificatesBatch = 25
+
+// listenerChanges are the operations needed to reconcile a listener with its input
+type listenerChanges struct {
+	replace            bool
+	tags               elbv2tags.Changes
+	modify             *elasticloadbalancingv2.ModifyListenerInput //nil when no setting changed
+	addCertificates    []string
+	removeCertificates []string
+	fields             []awsinfra.FieldChange
+}
+
+func (c *listenerChanges) empty() bool {
+	return len(c.fields) == 0
+}
+
+// changes compares the input with the last listener. It only reads from the cloud provider.
+// Only the settings that changed are sent to ModifyListener.
+func (rm *manager) changes(ctx context.Context, input *awsinfra.ListenerInput, last *awsinfra.Listener) (*listenerChanges, error) {
+	c := &listenerChanges{}
+	if arn := aws.ToString(input.LoadBalancerArn); arn != "" && arn != aws.ToString(last.LoadBalancerArn) {
+		c.replace = true
+		c.fields = append(c.fields, awsinfra.FieldChange{Field: "LoadBalancerArn", Current: aws.ToString(last.LoadBalancerArn), Desired: arn, ForcesReplacement: true})
+	}
+
+	modify := &elasticloadbalancingv2.ModifyListenerInput{ListenerArn: last.ListenerArn}
+	changed := func(field string, desired any, current any) {
+		c.modify = modify
+		c.fields = append(c.fields, awsinfra.FieldChange{Field: field, Current: current, Desired: desired})
+	}
+	if input.Port != nil && aws.ToInt32(input.Port) != aws.ToInt32(last.Port) {
+		modify.Port = input.Port
+		changed("Port", aws.ToInt32(input.Port), aws.ToInt32(last.Port))
+	}
+	if input.Protocol != "" && input.Protocol != last.Protocol {
+		modify.Protocol = input.Protocol
+		changed("Protocol", input.Protocol, last.Protocol)
+	}
+	if input.SslPolicy != nil && aws.ToString(input.SslPolicy) != aws.ToString(last.SslPolicy) {
+		modify.SslPolicy = input.SslPolicy
+		changed("SslPolicy", aws.ToString(input.SslPolicy), aws.ToString(last.SslPolicy))
+	}
+	if input.AlpnPolicy != nil && !slices.Equal(input.AlpnPolicy, last.AlpnPolicy) {
+		modify.AlpnPolicy = input.AlpnPolicy
+		changed("AlpnPolicy", input.AlpnPolicy, last.AlpnPolicy)
+	}
+	if desired, current := defaultCertificate(input.Certificates), defaultCertificate(last.Certificates); desired != "" && desired != current {
+		modify.Certificates = input.Certificates
+		changed("Certificates", desired, current)
+	}
+	if !input.IgnoreDefaultActionChanges {
+		actions, err := elbv2actions.Diff("DefaultActions", input.DefaultActions, last.DefaultActions)
+		if err != nil {
+			return nil, err
+		}
+		if len(actions) > 0 {
+			modify.DefaultActions = input.DefaultActions
+			changed("DefaultActions", input.DefaultActions, last.DefaultActions)
+		}
+	}
+
+	if input.ExtraCertificates != nil {
+		c.addCertificates = missing(input.ExtraCertificates, last.ExtraCertificates)
+		c.removeCertificates = missing(last.ExtraCertificates, input.ExtraCertificates)
+		if len(c.addCertificates) > 0 || len(c.removeCertificates) > 0 {
+			c.fields = append(c.fields, awsinfra.FieldChange{Field: "ExtraCertificates", Current: last.ExtraCertificates, Desired: input.ExtraCertificates})
+		}
+	}
+
+	desiredTags := elbv2tags.Desired(input.Tags)
+	if desiredTags != nil {
+		currentTags, err := elbv2tags.Current(ctx, rm.client, aws.ToString(last.ListenerArn))
+		if err != nil {
+			return nil, err
+		}
+		if c.tags = elbv2tags.Diff(desiredTags, currentTags); !c.tags.Empty() {
+			c.fields = append(c.fields, awsinfra.FieldChange{Field: "Tags", Current: currentTags, Desired: desiredTags})
+		}
+	}
+	return c, nil
+}
+
+// apply makes the changes to the listener. The listener is modified before the extra
+// certificates change, so a new protocol accepting certificates is already set.
+func (rm *manager) apply(ctx context.Context, last *awsinfra.Listener, c *listenerChanges) error {
+	arn := last.ListenerArn
+	if err := c.tags.Apply(ctx, rm.client, aws.ToString(arn)); err != nil {
+		return err
+	}
+	if c.modify != nil {
+		if _, err := rm.client.ModifyListener(ctx, c.modify); err != nil {
+			return err
+		}
+	}
+	if err := rm.addCertificates(ctx, arn, c.addCertificates); err != nil {
+		return err
+	}
+	for start := 0; start < len(c.removeCertificates); start += certificatesBatch {
+		if _, err := rm.client.RemoveListenerCertificates(ctx, &elasticloadbalancingv2.RemoveListenerCertificatesInput{
+			ListenerArn:  arn,
+			Certificates: certificates(c.removeCertificates[start:min(start+certificatesBatch, len(c.removeCertificates))]),
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (rm *manager) addCertificates(ctx context.Context, arn *string, arns []string) error {
+	for start := 0; start < len(arns); start += certificatesBatch {
+		if _, err := rm.client.AddListenerCertificates(ctx, &elasticloadbalancingv2.AddListenerCertificatesInput{
+			ListenerArn:  arn,
+			Certificates: certificates(arns[start:min(start+certificatesBatch, len(arns))]),
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func certificates(arns []string) []types.Certificate {
+	certificates := make([]types.Certificate, 0, len(arns))
+	for _, arn := range arns {
+		certificates = append(certificates, types.Certificate{CertificateArn: aws.String(arn)})
+	}
+	return certificates
+}
+
+// defaultCertificate returns the ARN of the default certificate, the only one in the listener Certificates
+func defaultCertificate(certificates []types.Certificate) string {
+	if len(certificates) == 0 {
+		return ""
+	}
+	return aws.ToString(certificates[0].CertificateArn)
+}
+
+// missing returns the values of a missing from b, sorted
+func missing(a []string, b []string) []string {
+	var values []string
+	for _, v := range a {
+		if !slices.Contains(b, v) {
+			values = append(values, v)
+		}
+	}
+	sort.Strings(values)
+	return values
+}
@@ -0,0 +1,93 @@
+package elasticloadbalancingv2listenerrulemanager
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/managers/apierror"
+)
+
+// New Creates a new instsance of the resource manager
+func New(client *elasticloadbalancingv2.Client) awsinfra.ResourceManager[*awsinfra.ListenerRuleInput, *types.Rule] {
+	return &manager{
+		client,
+	}
+}
+
+type manager struct {
+	client *elasticloadbalancingv2.Client
+}
+
+// Create creates the rule, its ARN is the ExternalID
+func (rm *manager) Create(ctx context.Context, input *awsinfra.ListenerRuleInput) (awsinfra.ExternalID, *types.Rule, error) {
+	output, err := rm.client.CreateRule(ctx, input.CreateRuleInput)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(output.Rules) == 0 {
+		return nil, nil, fmt.Errorf("Rule with priority %d was not created", aws.ToInt32(input.Priority))
+	}
+	return output.Rules[0].RuleArn, &output.Rules[0], nil
+}
+
+// Update changes the priority, the conditions and the actions of the rule in place,
+// calling only the APIs of what changed. A rule can't be moved to another listener, so a new
+// one is created instead and its ARN returned; the last one is destroyed by awsinfra.Infra.Prune.
+func (rm *manager) Update(ctx context.Context, input *awsinfra.ListenerRuleInput, last *types.Rule) (awsinfra.ExternalID, *types.Rule, error) {
+	changes, err := rm.changes(ctx, input, last)
+	if err != nil {
+		return last.RuleArn, last, err
+	}
+	if changes.replace {
+		return rm.Create(ctx, input)
+	}
+	if changes.empty() {
+		return last.RuleArn, last, nil
+	}
+	if err := rm.apply(ctx, input, last, changes); err != nil {
+		return last.RuleArn, last, err
+	}
+	rule, err := rm.Load(ctx, last.RuleArn)
+	if err != nil {
+		return last.RuleArn, last, err
+	}
+	return rule.RuleArn, rule, nil
+}
+
+// Diff describes the changes Update would make, without making them
+func (rm *manager) Diff(ctx context.Context, input *awsinfra.ListenerRuleInput, last *types.Rule) ([]awsinfra.FieldChange, error) {
+	changes, err := rm.changes(ctx, input, last)
+	if err != nil {
+		return nil, err
+	}
+	return changes.fields, nil
+}
+
+func (rm *manager) Load(ctx context.Context, id awsinfra.ExternalID) (*types.Rule, error) {
+	output, err := rm.client.DescribeRules(ctx, &elasticloadbalancingv2.DescribeRulesInput{
+		RuleArns: []string{*id},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(output.Rules) == 0 {
+		return nil, fmt.Errorf("Rule %s not found", *id)
+	}
+	return &output.Rules[0], nil
+}
+
+// Destroy deletes the rule.
+// Destroying a rule that no longer exists succeeds.
+func (rm *manager) Destroy(ctx context.Context, id awsinfra.ExternalID) error {
+	_, err := rm.client.DeleteRule(ctx, &elasticloadbalancingv2.DeleteRuleInput{
+		RuleArn: id,
+	})
+	if apierror.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
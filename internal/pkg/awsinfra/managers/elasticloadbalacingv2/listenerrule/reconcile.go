@@ -0,0 +1,113 @@
+package elasticloadbalancingv2listenerrulemanager
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra"
+	elbv2actions "github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/managers/elasticloadbalacingv2/actions"
+	elbv2tags "github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/managers/elasticloadbalacingv2/tags"
+)
+
+// ruleChanges are the operations needed to reconcile a rule with its input
+type ruleChanges struct {
+	replace    bool
+	tags       elbv2tags.Changes
+	priority   bool
+	conditions bool
+	actions    bool
+	fields     []awsinfra.FieldChange
+}
+
+func (c *ruleChanges) empty() bool {
+	return len(c.fields) == 0
+}
+
+// changes compares the input with the last rule. It only reads from the cloud provider.
+func (rm *manager) changes(ctx context.Context, input *awsinfra.ListenerRuleInput, last *types.Rule) (*ruleChanges, error) {
+	c := &ruleChanges{}
+	current := listenerARN(aws.ToString(last.RuleArn))
+	if desired := aws.ToString(input.ListenerArn); desired != "" && desired != current {
+		c.replace = true
+		c.fields = append(c.fields, awsinfra.FieldChange{Field: "ListenerArn", Current: current, Desired: desired, ForcesReplacement: true})
+	}
+
+	if input.Priority != nil && strconv.Itoa(int(*input.Priority)) != aws.ToString(last.Priority) {
+		c.priority = true
+		c.fields = append(c.fields, awsinfra.FieldChange{Field: "Priority", Current: aws.ToString(last.Priority), Desired: *input.Priority})
+	}
+
+	if input.Conditions != nil {
+		conditions, err := awsinfra.DiffFields(map[string]any{"Conditions": input.Conditions}, map[string]any{"Conditions": last.Conditions})
+		if err != nil {
+			return nil, err
+		}
+		if len(conditions) > 0 {
+			c.conditions = true
+			c.fields = append(c.fields, awsinfra.FieldChange{Field: "Conditions", Current: last.Conditions, Desired: input.Conditions})
+		}
+	}
+
+	actions, err := elbv2actions.Diff("Actions", input.Actions, last.Actions)
+	if err != nil {
+		return nil, err
+	}
+	if len(actions) > 0 {
+		c.actions = true
+		c.fields = append(c.fields, actions...)
+	}
+
+	desiredTags := elbv2tags.Desired(input.Tags)
+	if desiredTags != nil {
+		currentTags, err := elbv2tags.Current(ctx, rm.client, aws.ToString(last.RuleArn))
+		if err != nil {
+			return nil, err
+		}
+		if c.tags = elbv2tags.Diff(desiredTags, currentTags); !c.tags.Empty() {
+			c.fields = append(c.fields, awsinfra.FieldChange{Field: "Tags", Current: currentTags, Desired: desiredTags})
+		}
+	}
+	return c, nil
+}
+
+// apply makes the changes to the rule
+func (rm *manager) apply(ctx context.Context, input *awsinfra.ListenerRuleInput, last *types.Rule, c *ruleChanges) error {
+	arn := last.RuleArn
+	if err := c.tags.Apply(ctx, rm.client, aws.ToString(arn)); err != nil {
+		return err
+	}
+	if c.priority {
+		if _, err := rm.client.SetRulePriorities(ctx, &elasticloadbalancingv2.SetRulePrioritiesInput{
+			RulePriorities: []types.RulePriorityPair{{RuleArn: arn, Priority: input.Priority}},
+		}); err != nil {
+			return err
+		}
+	}
+	if c.conditions || c.actions {
+		modify := &elasticloadbalancingv2.ModifyRuleInput{RuleArn: arn}
+		if c.conditions {
+			modify.Conditions = input.Conditions
+		}
+		if c.actions {
+			modify.Actions = input.Actions
+		}
+		if _, err := rm.client.ModifyRule(ctx, modify); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listenerARN returns the ARN of the listener of a rule.
+// arn:...:listener-rule/app/lb/lb-id/listener-id/rule-id belongs to arn:...:listener/app/lb/lb-id/listener-id
+func listenerARN(ruleARN string) string {
+	arn := strings.Replace(ruleARN, ":listener-rule/", ":listener/", 1)
+	if i := strings.LastIndex(arn, "/"); i >= 0 {
+		return arn[:i]
+	}
+	return arn
+}
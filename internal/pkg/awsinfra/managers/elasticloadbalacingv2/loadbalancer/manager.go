@@ -2,17 +2,20 @@ package elasticloadbalancingv2loadbalancermanager
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
-	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
 	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/managers/apierror"
 )
 
+// deleteTimeout is the maximum time to wait for a load balancer to be deleted
+const deleteTimeout = 10 * time.Minute
+
 // New Creates a new instsance of the resource manager
-func New(client *elasticloadbalancingv2.Client) awsinfra.ResourceManager[*elasticloadbalancingv2.CreateLoadBalancerInput, []types.LoadBalancer] {
+func New(client *elasticloadbalancingv2.Client) awsinfra.ResourceManager[*awsinfra.LoadBalancerInput, *awsinfra.LoadBalancer] {
 	return &manager{
 		client,
 	}
@@ -22,43 +25,100 @@ type manager struct {
 	client *elasticloadbalancingv2.Client
 }
 
-func (rm *manager) Create(input *elasticloadbalancingv2.CreateLoadBalancerInput) (awsinfra.ExternalID, []types.LoadBalancer, error) {
-	output, err := rm.client.CreateLoadBalancer(context.TODO(), input)
+// Create creates a single load balancer, its ARN is the ExternalID
+func (rm *manager) Create(ctx context.Context, input *awsinfra.LoadBalancerInput) (awsinfra.ExternalID, *awsinfra.LoadBalancer, error) {
+	output, err := rm.client.CreateLoadBalancer(ctx, input.CreateLoadBalancerInput)
 	if err != nil {
 		return nil, nil, err
 	}
-	//Encode arn ids into the ExternalID string
-	arns := make([]string, len(output.LoadBalancers))
-	for i, v := range output.LoadBalancers {
-		arns[i] = *v.LoadBalancerArn
+	if len(output.LoadBalancers) == 0 {
+		return nil, nil, fmt.Errorf("LoadBalancer %s was not created", aws.ToString(input.Name))
+	}
+	arn := output.LoadBalancers[0].LoadBalancerArn
+	if len(input.Attributes) > 0 {
+		if err := rm.modifyAttributes(ctx, arn, input.Attributes); err != nil {
+			return arn, &awsinfra.LoadBalancer{LoadBalancer: output.LoadBalancers[0]}, err
+		}
 	}
-	loadBalancerArns, err := json.Marshal(arns)
+	lb, err := rm.Load(ctx, arn)
 	if err != nil {
-		fmt.Println("Error:", err)
-		return nil, nil, err
+		return arn, &awsinfra.LoadBalancer{LoadBalancer: output.LoadBalancers[0]}, err
 	}
+	return arn, lb, nil
+}
 
-	return aws.String(string(loadBalancerArns)), output.LoadBalancers, nil
+// Update reconciles subnets, security groups, IP address type, attributes and tags in place.
+// Name, scheme and type can't be changed, so a new load balancer is created instead and
+// its ARN returned; the last one is destroyed by awsinfra.Infra.Prune. As names are unique and both exist
+// until then, a change of scheme, type or customer-owned pool is rejected unless the Name changes too.
+func (rm *manager) Update(ctx context.Context, input *awsinfra.LoadBalancerInput, last *awsinfra.LoadBalancer) (awsinfra.ExternalID, *awsinfra.LoadBalancer, error) {
+	changes, err := rm.changes(ctx, input, last)
+	if err != nil {
+		return last.LoadBalancerArn, last, err
+	}
+	if changes.replace {
+		return rm.Create(ctx, input)
+	}
+	if changes.empty() {
+		return last.LoadBalancerArn, last, nil
+	}
+	if err := rm.apply(ctx, input, last, changes); err != nil {
+		return last.LoadBalancerArn, last, err
+	}
+	lb, err := rm.Load(ctx, last.LoadBalancerArn)
+	if err != nil {
+		return last.LoadBalancerArn, last, err
+	}
+	return lb.LoadBalancerArn, lb, nil
 }
 
-func (rm *manager) Update(input *elasticloadbalancingv2.CreateLoadBalancerInput, last []types.LoadBalancer) (awsinfra.ExternalID, []types.LoadBalancer, error) {
-	return nil, nil, fmt.Errorf("TODO: Need to implement")
+// Diff describes the changes Update would make, without making them
+func (rm *manager) Diff(ctx context.Context, input *awsinfra.LoadBalancerInput, last *awsinfra.LoadBalancer) ([]awsinfra.FieldChange, error) {
+	changes, err := rm.changes(ctx, input, last)
+	if err != nil {
+		return nil, err
+	}
+	return changes.fields, nil
 }
 
-func (rm *manager) Load(id awsinfra.ExternalID) ([]types.LoadBalancer, error) {
-	var loadBalancerArns []string
-	if err := json.Unmarshal([]byte(*id), &loadBalancerArns); err != nil {
+func (rm *manager) Load(ctx context.Context, id awsinfra.ExternalID) (*awsinfra.LoadBalancer, error) {
+	output, err := rm.client.DescribeLoadBalancers(ctx, &elasticloadbalancingv2.DescribeLoadBalancersInput{
+		LoadBalancerArns: []string{*id},
+	})
+	if err != nil {
 		return nil, err
 	}
-	output, err := rm.client.DescribeLoadBalancers(context.TODO(), &elasticloadbalancingv2.DescribeLoadBalancersInput{
-		LoadBalancerArns: loadBalancerArns,
+	if len(output.LoadBalancers) == 0 {
+		return nil, fmt.Errorf("LoadBalancer %s not found", *id)
+	}
+	attributes, err := rm.client.DescribeLoadBalancerAttributes(ctx, &elasticloadbalancingv2.DescribeLoadBalancerAttributesInput{
+		LoadBalancerArn: id,
 	})
 	if err != nil {
 		return nil, err
 	}
-	return output.LoadBalancers, nil
+	lb := &awsinfra.LoadBalancer{
+		LoadBalancer: output.LoadBalancers[0],
+		Attributes:   make(map[string]string, len(attributes.Attributes)),
+	}
+	for _, attribute := range attributes.Attributes {
+		lb.Attributes[aws.ToString(attribute.Key)] = aws.ToString(attribute.Value)
+	}
+	return lb, nil
 }
 
-func (rm *manager) Destroy(id awsinfra.ExternalID) error {
-	return fmt.Errorf("TODO: Need to implement")
+// Destroy deletes the load balancer and waits until it is gone.
+// Destroying a load balancer that no longer exists succeeds.
+func (rm *manager) Destroy(ctx context.Context, id awsinfra.ExternalID) error {
+	if _, err := rm.client.DeleteLoadBalancer(ctx, &elasticloadbalancingv2.DeleteLoadBalancerInput{
+		LoadBalancerArn: id,
+	}); err != nil {
+		if apierror.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	return elasticloadbalancingv2.NewLoadBalancersDeletedWaiter(rm.client).Wait(ctx, &elasticloadbalancingv2.DescribeLoadBalancersInput{
+		LoadBalancerArns: []string{*id},
+	}, deleteTimeout)
 }
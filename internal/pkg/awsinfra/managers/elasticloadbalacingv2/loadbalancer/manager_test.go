@@ -0,0 +1,36 @@
+package elasticloadbalancingv2loadbalancermanager
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra"
+	elbv2common "github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/managers/elasticloadbalacingv2/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateRejectsReplacementUnderTheSameName(t *testing.T) {
+	ctx := context.Background()
+	client, calls := elbv2common.NewTestClient(t)
+	rm := New(client).(*manager)
+	last := &awsinfra.LoadBalancer{LoadBalancer: types.LoadBalancer{
+		LoadBalancerArn:  aws.String("arn:aws:elasticloadbalancing:us-east-1:123456789012:loadbalancer/app/web/1"),
+		LoadBalancerName: aws.String("web"),
+		Scheme:           types.LoadBalancerSchemeEnumInternetFacing,
+		Type:             types.LoadBalancerTypeEnumApplication,
+	}}
+	input := &awsinfra.LoadBalancerInput{CreateLoadBalancerInput: &elasticloadbalancingv2.CreateLoadBalancerInput{
+		Name:   aws.String("web"),
+		Scheme: types.LoadBalancerSchemeEnumInternal,
+	}}
+
+	externalID, _, err := rm.Update(ctx, input, last)
+	assert.ErrorContains(t, err, "set a new Name to change Scheme")
+	assert.Equal(t, last.LoadBalancerArn, externalID)
+	assert.Equal(t, int32(0), calls.Load(), "No load balancer is created")
+	_, err = rm.Diff(ctx, input, last)
+	assert.Error(t, err, "Plan reports the rejected replacement")
+}
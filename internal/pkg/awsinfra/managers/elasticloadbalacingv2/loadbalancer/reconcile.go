@@ -0,0 +1,149 @@
+package elasticloadbalancingv2loadbalancermanager
+
+import (
+	"context"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra"
+	elbv2common "github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/managers/elasticloadbalacingv2/common"
+	elbv2tags "github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/managers/elasticloadbalacingv2/tags"
+)
+
+// lbChanges are the operations needed to reconcile a load balancer with its input
+type lbChanges struct {
+	replace        bool
+	tags           elbv2tags.Changes
+	subnets        bool
+	securityGroups bool
+	ipAddressType  bool
+	attributes     map[string]string
+	fields         []awsinfra.FieldChange
+}
+
+func (c *lbChanges) empty() bool {
+	return len(c.fields) == 0
+}
+
+// changes compares the input with the last load balancer. It only reads from the cloud provider.
+func (rm *manager) changes(ctx context.Context, input *awsinfra.LoadBalancerInput, last *awsinfra.LoadBalancer) (*lbChanges, error) {
+	c := &lbChanges{}
+	replacement := func(field string, desired string, current string) {
+		if desired != "" && desired != current {
+			c.replace = true
+			c.fields = append(c.fields, awsinfra.FieldChange{Field: field, Current: current, Desired: desired, ForcesReplacement: true})
+		}
+	}
+	replacement("Name", aws.ToString(input.Name), aws.ToString(last.LoadBalancerName))
+	replacement("Scheme", string(input.Scheme), string(last.Scheme))
+	replacement("Type", string(input.Type), string(last.Type))
+	replacement("CustomerOwnedIpv4Pool", aws.ToString(input.CustomerOwnedIpv4Pool), aws.ToString(last.CustomerOwnedIpv4Pool))
+	//Load balancer names are unique in a region, and the new load balancer is created before Prune deletes the last one
+	if err := elbv2common.SameNameReplacement("LoadBalancer", input.Name, aws.ToString(last.LoadBalancerName), c.fields); err != nil {
+		return nil, err
+	}
+
+	desiredSubnets := append([]string{}, input.Subnets...)
+	for _, mapping := range input.SubnetMappings {
+		desiredSubnets = append(desiredSubnets, aws.ToString(mapping.SubnetId))
+	}
+	var currentSubnets []string
+	for _, zone := range last.AvailabilityZones {
+		currentSubnets = append(currentSubnets, aws.ToString(zone.SubnetId))
+	}
+	if len(desiredSubnets) > 0 && !sameSet(desiredSubnets, currentSubnets) {
+		c.subnets = true
+		sort.Strings(desiredSubnets)
+		sort.Strings(currentSubnets)
+		c.fields = append(c.fields, awsinfra.FieldChange{Field: "Subnets", Current: currentSubnets, Desired: desiredSubnets})
+	}
+
+	if input.SecurityGroups != nil && !sameSet(input.SecurityGroups, last.SecurityGroups) {
+		c.securityGroups = true
+		c.fields = append(c.fields, awsinfra.FieldChange{Field: "SecurityGroups", Current: last.SecurityGroups, Desired: input.SecurityGroups})
+	}
+
+	if input.IpAddressType != "" && input.IpAddressType != last.IpAddressType {
+		c.ipAddressType = true
+		c.fields = append(c.fields, awsinfra.FieldChange{Field: "IpAddressType", Current: last.IpAddressType, Desired: input.IpAddressType})
+	}
+
+	var attributeChanges []awsinfra.FieldChange
+	c.attributes, attributeChanges = elbv2common.AttributeChanges(input.Attributes, last.Attributes)
+	c.fields = append(c.fields, attributeChanges...)
+
+	desiredTags := elbv2tags.Desired(input.Tags)
+	if desiredTags != nil {
+		currentTags, err := elbv2tags.Current(ctx, rm.client, aws.ToString(last.LoadBalancerArn))
+		if err != nil {
+			return nil, err
+		}
+		if c.tags = elbv2tags.Diff(desiredTags, currentTags); !c.tags.Empty() {
+			c.fields = append(c.fields, awsinfra.FieldChange{Field: "Tags", Current: currentTags, Desired: desiredTags})
+		}
+	}
+	return c, nil
+}
+
+// apply makes the changes to the load balancer
+func (rm *manager) apply(ctx context.Context, input *awsinfra.LoadBalancerInput, last *awsinfra.LoadBalancer, c *lbChanges) error {
+	arn := last.LoadBalancerArn
+	if err := c.tags.Apply(ctx, rm.client, aws.ToString(arn)); err != nil {
+		return err
+	}
+	if c.subnets {
+		if _, err := rm.client.SetSubnets(ctx, &elasticloadbalancingv2.SetSubnetsInput{
+			LoadBalancerArn: arn,
+			Subnets:         input.Subnets,
+			SubnetMappings:  input.SubnetMappings,
+		}); err != nil {
+			return err
+		}
+	}
+	if c.securityGroups {
+		if _, err := rm.client.SetSecurityGroups(ctx, &elasticloadbalancingv2.SetSecurityGroupsInput{
+			LoadBalancerArn: arn,
+			SecurityGroups:  input.SecurityGroups,
+		}); err != nil {
+			return err
+		}
+	}
+	if c.ipAddressType {
+		if _, err := rm.client.SetIpAddressType(ctx, &elasticloadbalancingv2.SetIpAddressTypeInput{
+			LoadBalancerArn: arn,
+			IpAddressType:   input.IpAddressType,
+		}); err != nil {
+			return err
+		}
+	}
+	if len(c.attributes) > 0 {
+		return rm.modifyAttributes(ctx, arn, c.attributes)
+	}
+	return nil
+}
+
+func (rm *manager) modifyAttributes(ctx context.Context, arn *string, attributes map[string]string) error {
+	input := &elasticloadbalancingv2.ModifyLoadBalancerAttributesInput{LoadBalancerArn: arn}
+	for _, key := range elbv2common.SortedKeys(attributes) {
+		input.Attributes = append(input.Attributes, types.LoadBalancerAttribute{Key: aws.String(key), Value: aws.String(attributes[key])})
+	}
+	_, err := rm.client.ModifyLoadBalancerAttributes(ctx, input)
+	return err
+}
+
+func sameSet(a []string, b []string) bool {
+	in := make(map[string]bool, len(b))
+	for _, v := range b {
+		in[v] = true
+	}
+	seen := make(map[string]bool, len(a))
+	for _, v := range a {
+		if !in[v] {
+			return false
+		}
+		seen[v] = true
+	}
+	return len(seen) == len(in)
+}
@@ -0,0 +1,107 @@
+package elbv2tags
+
+import (
+	"context"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	elbv2 "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	elbv2common "github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/managers/elasticloadbalacingv2/common"
+)
+
+// Desired returns the tags of an input as a map.
+// It returns nil when the input has no tags, meaning tags are not managed.
+func Desired(tags []types.Tag) map[string]string {
+	if tags == nil {
+		return nil
+	}
+	desired := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		desired[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	return desired
+}
+
+// unmanagedPrefixes are the prefixes of tags the managers leave alone: aws: tags are reserved
+// and deploy: tags record the active environment, written by the deploy package
+var unmanagedPrefixes = []string{"aws:", "deploy:"}
+
+// Current loads the tags of a resource as a map, skipping the aws: and deploy: tags
+func Current(ctx context.Context, client *elbv2.Client, arn string) (map[string]string, error) {
+	output, err := client.DescribeTags(ctx, &elbv2.DescribeTagsInput{ResourceArns: []string{arn}})
+	if err != nil {
+		return nil, err
+	}
+	current := make(map[string]string)
+	for _, description := range output.TagDescriptions {
+		for _, tag := range description.Tags {
+			if unmanaged(aws.ToString(tag.Key)) {
+				continue
+			}
+			current[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+		}
+	}
+	return current, nil
+}
+
+// Changes are the tag operations needed to reconcile a resource
+type Changes struct {
+	Add    []types.Tag
+	Remove []string
+}
+
+// Diff computes the tags to add or overwrite and the tags to remove.
+// A nil desired map means tags are not managed, so nothing changes.
+func Diff(desired map[string]string, current map[string]string) Changes {
+	var changes Changes
+	if desired == nil {
+		return changes
+	}
+	for _, k := range elbv2common.SortedKeys(desired) {
+		if v, ok := current[k]; !ok || v != desired[k] {
+			changes.Add = append(changes.Add, types.Tag{Key: aws.String(k), Value: aws.String(desired[k])})
+		}
+	}
+	for _, k := range elbv2common.SortedKeys(current) {
+		if _, ok := desired[k]; !ok {
+			changes.Remove = append(changes.Remove, k)
+		}
+	}
+	return changes
+}
+
+// Empty reports whether there is nothing to change
+func (c Changes) Empty() bool {
+	return len(c.Add) == 0 && len(c.Remove) == 0
+}
+
+// Apply adds and removes the tags of the resource
+func (c Changes) Apply(ctx context.Context, client *elbv2.Client, arn string) error {
+	if len(c.Add) > 0 {
+		if _, err := client.AddTags(ctx, &elbv2.AddTagsInput{
+			ResourceArns: []string{arn},
+			Tags:         c.Add,
+		}); err != nil {
+			return err
+		}
+	}
+	if len(c.Remove) > 0 {
+		if _, err := client.RemoveTags(ctx, &elbv2.RemoveTagsInput{
+			ResourceArns: []string{arn},
+			TagKeys:      c.Remove,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func unmanaged(key string) bool {
+	for _, prefix := range unmanagedPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
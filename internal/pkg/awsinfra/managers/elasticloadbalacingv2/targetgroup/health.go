@@ -0,0 +1,47 @@
+package elasticloadbalancingv2targetgroupmanager
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/wait"
+)
+
+// healthPollInterval is the time between two DescribeTargetHealth calls of WaitHealthy
+const healthPollInterval = 10 * time.Second
+
+// Healthy reports whether the target group has at least minHealthy healthy targets
+// and no target failing or still running its health checks.
+// Targets being drained are leaving the group, so they are not taken into account.
+func Healthy(ctx context.Context, client *elasticloadbalancingv2.Client, arn string, minHealthy int32) (bool, error) {
+	output, err := client.DescribeTargetHealth(ctx, &elasticloadbalancingv2.DescribeTargetHealthInput{
+		TargetGroupArn: aws.String(arn),
+	})
+	if err != nil {
+		return false, err
+	}
+	var healthy int32
+	for _, target := range output.TargetHealthDescriptions {
+		if target.TargetHealth == nil {
+			return false, nil
+		}
+		switch target.TargetHealth.State {
+		case types.TargetHealthStateEnumHealthy:
+			healthy++
+		case types.TargetHealthStateEnumDraining:
+		default:
+			return false, nil
+		}
+	}
+	return healthy >= minHealthy, nil
+}
+
+// WaitHealthy waits until the target group is Healthy, and fails with wait.ErrTimeout after timeout
+func WaitHealthy(ctx context.Context, client *elasticloadbalancingv2.Client, arn string, minHealthy int32, timeout time.Duration) error {
+	return wait.Until(ctx, healthPollInterval, timeout, func(ctx context.Context) (bool, error) {
+		return Healthy(ctx, client, arn, minHealthy)
+	})
+}
@@ -0,0 +1,116 @@
+package elasticloadbalancingv2targetgroupmanager
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/managers/apierror"
+)
+
+// New Creates a new instsance of the resource manager
+func New(client *elasticloadbalancingv2.Client) awsinfra.ResourceManager[*awsinfra.TargetGroupInput, *awsinfra.TargetGroup] {
+	return &manager{
+		client,
+	}
+}
+
+type manager struct {
+	client *elasticloadbalancingv2.Client
+}
+
+// Create creates a single target group, its ARN is the ExternalID
+func (rm *manager) Create(ctx context.Context, input *awsinfra.TargetGroupInput) (awsinfra.ExternalID, *awsinfra.TargetGroup, error) {
+	output, err := rm.client.CreateTargetGroup(ctx, input.CreateTargetGroupInput)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(output.TargetGroups) == 0 {
+		return nil, nil, fmt.Errorf("TargetGroup %s was not created", aws.ToString(input.Name))
+	}
+	arn := output.TargetGroups[0].TargetGroupArn
+	if len(input.Attributes) > 0 {
+		if err := rm.modifyAttributes(ctx, arn, input.Attributes); err != nil {
+			return arn, &awsinfra.TargetGroup{TargetGroup: output.TargetGroups[0]}, err
+		}
+	}
+	tg, err := rm.Load(ctx, arn)
+	if err != nil {
+		return arn, &awsinfra.TargetGroup{TargetGroup: output.TargetGroups[0]}, err
+	}
+	return arn, tg, nil
+}
+
+// Update reconciles the health check, attributes and tags in place.
+// Name, protocol, port, VPC and target type can't be changed, so a new target group is created
+// instead and its ARN returned; the last one is destroyed by awsinfra.Infra.Prune. As names are unique and both
+// exist until then, a change of protocol, port, VPC, target type or IP address type is rejected unless the Name changes too.
+func (rm *manager) Update(ctx context.Context, input *awsinfra.TargetGroupInput, last *awsinfra.TargetGroup) (awsinfra.ExternalID, *awsinfra.TargetGroup, error) {
+	changes, err := rm.changes(ctx, input, last)
+	if err != nil {
+		return last.TargetGroupArn, last, err
+	}
+	if changes.replace {
+		return rm.Create(ctx, input)
+	}
+	if changes.empty() {
+		return last.TargetGroupArn, last, nil
+	}
+	if err := rm.apply(ctx, last, changes); err != nil {
+		return last.TargetGroupArn, last, err
+	}
+	tg, err := rm.Load(ctx, last.TargetGroupArn)
+	if err != nil {
+		return last.TargetGroupArn, last, err
+	}
+	return tg.TargetGroupArn, tg, nil
+}
+
+// Diff describes the changes Update would make, without making them
+func (rm *manager) Diff(ctx context.Context, input *awsinfra.TargetGroupInput, last *awsinfra.TargetGroup) ([]awsinfra.FieldChange, error) {
+	changes, err := rm.changes(ctx, input, last)
+	if err != nil {
+		return nil, err
+	}
+	return changes.fields, nil
+}
+
+func (rm *manager) Load(ctx context.Context, id awsinfra.ExternalID) (*awsinfra.TargetGroup, error) {
+	output, err := rm.client.DescribeTargetGroups(ctx, &elasticloadbalancingv2.DescribeTargetGroupsInput{
+		TargetGroupArns: []string{*id},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(output.TargetGroups) == 0 {
+		return nil, fmt.Errorf("TargetGroup %s not found", *id)
+	}
+	attributes, err := rm.client.DescribeTargetGroupAttributes(ctx, &elasticloadbalancingv2.DescribeTargetGroupAttributesInput{
+		TargetGroupArn: id,
+	})
+	if err != nil {
+		return nil, err
+	}
+	tg := &awsinfra.TargetGroup{
+		TargetGroup: output.TargetGroups[0],
+		Attributes:  make(map[string]string, len(attributes.Attributes)),
+	}
+	for _, attribute := range attributes.Attributes {
+		tg.Attributes[aws.ToString(attribute.Key)] = aws.ToString(attribute.Value)
+	}
+	return tg, nil
+}
+
+// Destroy deletes the target group. It fails while a listener or rule still forwards to it.
+// Destroying a target group that no longer exists succeeds.
+func (rm *manager) Destroy(ctx context.Context, id awsinfra.ExternalID) error {
+	_, err := rm.client.DeleteTargetGroup(ctx, &elasticloadbalancingv2.DeleteTargetGroupInput{
+		TargetGroupArn: id,
+	})
+	if apierror.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
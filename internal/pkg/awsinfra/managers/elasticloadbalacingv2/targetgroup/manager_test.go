@@ -0,0 +1,40 @@
+package elasticloadbalancingv2targetgroupmanager
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra"
+	elbv2common "github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/managers/elasticloadbalacingv2/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateReplacesUnderANewName(t *testing.T) {
+	ctx := context.Background()
+	client, calls := elbv2common.NewTestClient(t)
+	rm := New(client)
+	last := &awsinfra.TargetGroup{TargetGroup: types.TargetGroup{
+		TargetGroupArn:  aws.String("arn:aws:elasticloadbalancing:us-east-1:123456789012:targetgroup/web/1"),
+		TargetGroupName: aws.String("web"),
+		Protocol:        types.ProtocolEnumHttp,
+		Port:            aws.Int32(80),
+		VpcId:           aws.String("vpc-0a1b2c"),
+	}}
+	input := &awsinfra.TargetGroupInput{CreateTargetGroupInput: &elasticloadbalancingv2.CreateTargetGroupInput{
+		Name:  aws.String("web"),
+		Port:  aws.Int32(8080),
+		VpcId: aws.String("vpc-0d1e2f"),
+	}}
+
+	_, _, err := rm.Update(ctx, input, last)
+	assert.ErrorContains(t, err, "set a new Name to change Port, VpcId")
+	assert.Equal(t, int32(0), calls.Load(), "No target group is created under the same name")
+
+	input.Name = aws.String("web-8080")
+	_, _, err = rm.Update(ctx, input, last)
+	assert.Error(t, err, "The test client fails every call")
+	assert.Equal(t, int32(1), calls.Load(), "The new target group is created")
+}
@@ -0,0 +1,141 @@
+package elasticloadbalancingv2targetgroupmanager
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra"
+	elbv2common "github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/managers/elasticloadbalacingv2/common"
+	elbv2tags "github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/managers/elasticloadbalacingv2/tags"
+)
+
+// tgChanges are the operations needed to reconcile a target group with its input
+type tgChanges struct {
+	replace     bool
+	tags        elbv2tags.Changes
+	healthCheck *elasticloadbalancingv2.ModifyTargetGroupInput //nil when the health check is unchanged
+	attributes  map[string]string
+	fields      []awsinfra.FieldChange
+}
+
+func (c *tgChanges) empty() bool {
+	return len(c.fields) == 0
+}
+
+// changes compares the input with the last target group. It only reads from the cloud provider.
+func (rm *manager) changes(ctx context.Context, input *awsinfra.TargetGroupInput, last *awsinfra.TargetGroup) (*tgChanges, error) {
+	c := &tgChanges{}
+	replacement := func(field string, desired string, current string) {
+		if desired != "" && desired != current {
+			c.replace = true
+			c.fields = append(c.fields, awsinfra.FieldChange{Field: field, Current: current, Desired: desired, ForcesReplacement: true})
+		}
+	}
+	replacement("Name", aws.ToString(input.Name), aws.ToString(last.TargetGroupName))
+	replacement("Protocol", string(input.Protocol), string(last.Protocol))
+	replacement("ProtocolVersion", aws.ToString(input.ProtocolVersion), aws.ToString(last.ProtocolVersion))
+	replacement("Port", int32String(input.Port), int32String(last.Port))
+	replacement("VpcId", aws.ToString(input.VpcId), aws.ToString(last.VpcId))
+	replacement("TargetType", string(input.TargetType), string(last.TargetType))
+	replacement("IpAddressType", string(input.IpAddressType), string(last.IpAddressType))
+	//The last target group keeps its name until Prune deletes it, once the listeners forward to the new one
+	if err := elbv2common.SameNameReplacement("TargetGroup", input.Name, aws.ToString(last.TargetGroupName), c.fields); err != nil {
+		return nil, err
+	}
+
+	//Health check settings left out of the input keep their current value
+	healthCheck := &elasticloadbalancingv2.ModifyTargetGroupInput{TargetGroupArn: last.TargetGroupArn}
+	changed := func(field string, desired any, current any) {
+		c.healthCheck = healthCheck
+		c.fields = append(c.fields, awsinfra.FieldChange{Field: field, Current: current, Desired: desired})
+	}
+	stringField := func(field string, desired *string, current *string, set **string) {
+		if desired != nil && aws.ToString(desired) != aws.ToString(current) {
+			*set = desired
+			changed(field, aws.ToString(desired), aws.ToString(current))
+		}
+	}
+	int32Field := func(field string, desired *int32, current *int32, set **int32) {
+		if desired != nil && aws.ToInt32(desired) != aws.ToInt32(current) {
+			*set = desired
+			changed(field, aws.ToInt32(desired), aws.ToInt32(current))
+		}
+	}
+	if input.HealthCheckEnabled != nil && aws.ToBool(input.HealthCheckEnabled) != aws.ToBool(last.HealthCheckEnabled) {
+		healthCheck.HealthCheckEnabled = input.HealthCheckEnabled
+		changed("HealthCheckEnabled", aws.ToBool(input.HealthCheckEnabled), aws.ToBool(last.HealthCheckEnabled))
+	}
+	if input.HealthCheckProtocol != "" && input.HealthCheckProtocol != last.HealthCheckProtocol {
+		healthCheck.HealthCheckProtocol = input.HealthCheckProtocol
+		changed("HealthCheckProtocol", input.HealthCheckProtocol, last.HealthCheckProtocol)
+	}
+	stringField("HealthCheckPath", input.HealthCheckPath, last.HealthCheckPath, &healthCheck.HealthCheckPath)
+	stringField("HealthCheckPort", input.HealthCheckPort, last.HealthCheckPort, &healthCheck.HealthCheckPort)
+	int32Field("HealthCheckIntervalSeconds", input.HealthCheckIntervalSeconds, last.HealthCheckIntervalSeconds, &healthCheck.HealthCheckIntervalSeconds)
+	int32Field("HealthCheckTimeoutSeconds", input.HealthCheckTimeoutSeconds, last.HealthCheckTimeoutSeconds, &healthCheck.HealthCheckTimeoutSeconds)
+	int32Field("HealthyThresholdCount", input.HealthyThresholdCount, last.HealthyThresholdCount, &healthCheck.HealthyThresholdCount)
+	int32Field("UnhealthyThresholdCount", input.UnhealthyThresholdCount, last.UnhealthyThresholdCount, &healthCheck.UnhealthyThresholdCount)
+	if input.Matcher != nil && !sameMatcher(input.Matcher, last.Matcher) {
+		healthCheck.Matcher = input.Matcher
+		changed("Matcher", input.Matcher, last.Matcher)
+	}
+
+	var attributeChanges []awsinfra.FieldChange
+	c.attributes, attributeChanges = elbv2common.AttributeChanges(input.Attributes, last.Attributes)
+	c.fields = append(c.fields, attributeChanges...)
+
+	desiredTags := elbv2tags.Desired(input.Tags)
+	if desiredTags != nil {
+		currentTags, err := elbv2tags.Current(ctx, rm.client, aws.ToString(last.TargetGroupArn))
+		if err != nil {
+			return nil, err
+		}
+		if c.tags = elbv2tags.Diff(desiredTags, currentTags); !c.tags.Empty() {
+			c.fields = append(c.fields, awsinfra.FieldChange{Field: "Tags", Current: currentTags, Desired: desiredTags})
+		}
+	}
+	return c, nil
+}
+
+// apply makes the changes to the target group
+func (rm *manager) apply(ctx context.Context, last *awsinfra.TargetGroup, c *tgChanges) error {
+	arn := last.TargetGroupArn
+	if err := c.tags.Apply(ctx, rm.client, aws.ToString(arn)); err != nil {
+		return err
+	}
+	if c.healthCheck != nil {
+		if _, err := rm.client.ModifyTargetGroup(ctx, c.healthCheck); err != nil {
+			return err
+		}
+	}
+	if len(c.attributes) > 0 {
+		return rm.modifyAttributes(ctx, arn, c.attributes)
+	}
+	return nil
+}
+
+func (rm *manager) modifyAttributes(ctx context.Context, arn *string, attributes map[string]string) error {
+	input := &elasticloadbalancingv2.ModifyTargetGroupAttributesInput{TargetGroupArn: arn}
+	for _, key := range elbv2common.SortedKeys(attributes) {
+		input.Attributes = append(input.Attributes, types.TargetGroupAttribute{Key: aws.String(key), Value: aws.String(attributes[key])})
+	}
+	_, err := rm.client.ModifyTargetGroupAttributes(ctx, input)
+	return err
+}
+
+func sameMatcher(desired *types.Matcher, current *types.Matcher) bool {
+	if current == nil {
+		return false
+	}
+	return aws.ToString(desired.HttpCode) == aws.ToString(current.HttpCode) && aws.ToString(desired.GrpcCode) == aws.ToString(current.GrpcCode)
+}
+
+func int32String(v *int32) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(*v)
+}
@@ -0,0 +1,72 @@
+package route53resourcerecodsetmanager
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra"
+)
+
+// idSeparator separates the parts of a record set ExternalID.
+// The set identifier is the last part, so it may contain the separator.
+const idSeparator = "|"
+
+// RecordKey identifies a record set in Route53
+type RecordKey struct {
+	HostedZoneID  string
+	Name          string
+	Type          types.RRType
+	SetIdentifier string //empty for simple routing records
+}
+
+// KeyOf returns the key of the record set described by the input
+func KeyOf(input *awsinfra.DNSRecordInput) RecordKey {
+	return RecordKey{
+		HostedZoneID:  normalizeZoneID(aws.ToString(input.HostedZoneId)),
+		Name:          normalizeName(aws.ToString(input.Name)),
+		Type:          input.Type,
+		SetIdentifier: aws.ToString(input.SetIdentifier),
+	}
+}
+
+// ExternalID encodes the key as zone|name|type[|set identifier]
+func (k RecordKey) ExternalID() awsinfra.ExternalID {
+	parts := []string{k.HostedZoneID, k.Name, string(k.Type)}
+	if k.SetIdentifier != "" {
+		parts = append(parts, k.SetIdentifier)
+	}
+	return aws.String(strings.Join(parts, idSeparator))
+}
+
+// ParseExternalID decodes an ExternalID returned by the manager
+func ParseExternalID(id awsinfra.ExternalID) (RecordKey, error) {
+	parts := strings.SplitN(aws.ToString(id), idSeparator, 4)
+	if len(parts) < 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return RecordKey{}, fmt.Errorf("invalid record set id %q, expected zone%sname%stype[%sset identifier]", aws.ToString(id), idSeparator, idSeparator, idSeparator)
+	}
+	key := RecordKey{HostedZoneID: parts[0], Name: parts[1], Type: types.RRType(parts[2])}
+	if len(parts) == 4 {
+		key.SetIdentifier = parts[3]
+	}
+	return key, nil
+}
+
+// normalizeZoneID strips the /hostedzone/ prefix Route53 returns in some responses
+func normalizeZoneID(id string) string {
+	return strings.TrimPrefix(id, "/hostedzone/")
+}
+
+// normalizeName returns the name the way Route53 lists it: lower case, fully
+// qualified with a trailing dot and with the wildcard escaped
+func normalizeName(name string) string {
+	name = strings.ToLower(name)
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	if strings.HasPrefix(name, "*.") {
+		name = `\052` + name[1:]
+	}
+	return name
+}
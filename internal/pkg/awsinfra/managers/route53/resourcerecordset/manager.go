@@ -3,6 +3,7 @@ package route53resourcerecodsetmanager
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/route53"
@@ -10,8 +11,15 @@ import (
 	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra"
 )
 
+const (
+	// syncTimeout is the maximum time to wait for a change to propagate to all Route53 servers
+	syncTimeout = 5 * time.Minute
+	// listPageSize is the number of record sets listed when looking for one
+	listPageSize = 100
+)
+
 // New Creates a new instsance of the resource manager
-func New(client *route53.Client) awsinfra.ResourceManager[*route53.ChangeResourceRecordSetsInput, *types.ChangeInfo] {
+func New(client *route53.Client) awsinfra.ResourceManager[*awsinfra.DNSRecordInput, *types.ResourceRecordSet] {
 	return &manager{
 		client,
 	}
@@ -21,28 +29,141 @@ type manager struct {
 	client *route53.Client
 }
 
-func (rm *manager) Create(input *route53.ChangeResourceRecordSetsInput) (awsinfra.ExternalID, *types.ChangeInfo, error) {
-	output, err := rm.client.ChangeResourceRecordSets(context.TODO(), input)
-	if err != nil {
+// Create creates the record set and waits until the change is INSYNC.
+// The ExternalID is the key of the record set, see RecordKey.
+func (rm *manager) Create(ctx context.Context, input *awsinfra.DNSRecordInput) (awsinfra.ExternalID, *types.ResourceRecordSet, error) {
+	key := KeyOf(input)
+	if err := rm.change(ctx, key.HostedZoneID, types.ChangeActionCreate, input.ResourceRecordSet); err != nil {
 		return aws.String(""), nil, err
 	}
-	return output.ChangeInfo.Id, output.ChangeInfo, nil
+	id := key.ExternalID()
+	record, err := rm.Load(ctx, id)
+	if err != nil {
+		return id, input.ResourceRecordSet, err
+	}
+	return id, record, nil
 }
 
-func (rm *manager) Update(input *route53.ChangeResourceRecordSetsInput, last *types.ChangeInfo) (awsinfra.ExternalID, *types.ChangeInfo, error) {
-	return aws.String(""), &types.ChangeInfo{}, fmt.Errorf("TODO: Need to implement")
+// Update upserts the record set and waits until the change is INSYNC.
+// A change of hosted zone, name, type or set identifier is a different record set,
+// so it is created and its id returned; the last one is destroyed by awsinfra.Infra.Prune.
+// The record set is looked up by its key, last is only returned on errors and may be nil.
+func (rm *manager) Update(ctx context.Context, input *awsinfra.DNSRecordInput, last *types.ResourceRecordSet) (awsinfra.ExternalID, *types.ResourceRecordSet, error) {
+	key := KeyOf(input)
+	id := key.ExternalID()
+	current, err := rm.find(ctx, key)
+	if err != nil {
+		return id, last, err
+	}
+	if current == nil {
+		return rm.Create(ctx, input)
+	}
+	changes, err := rm.Diff(ctx, input, current)
+	if err != nil {
+		return id, current, err
+	}
+	if len(changes) == 0 {
+		return id, current, nil
+	}
+	if err := rm.change(ctx, key.HostedZoneID, types.ChangeActionUpsert, input.ResourceRecordSet); err != nil {
+		return id, current, err
+	}
+	record, err := rm.Load(ctx, id)
+	if err != nil {
+		return id, current, err
+	}
+	return id, record, nil
 }
 
-func (rm *manager) Load(id awsinfra.ExternalID) (*types.ChangeInfo, error) {
-	output, err := rm.client.GetChange(context.TODO(), &route53.GetChangeInput{
-		Id: id,
-	})
+// Diff describes the changes Update would make, without making them.
+// The hosted zone isn't part of the loaded record set, so moving a record
+// to another zone doesn't show up as a change.
+func (rm *manager) Diff(ctx context.Context, input *awsinfra.DNSRecordInput, last *types.ResourceRecordSet) ([]awsinfra.FieldChange, error) {
+	desired := *input.ResourceRecordSet
+	desired.Name = aws.String(normalizeName(aws.ToString(input.Name)))
+	changes, err := awsinfra.DiffFields(&desired, last)
 	if err != nil {
 		return nil, err
 	}
-	return output.ChangeInfo, nil
+	if input.SetIdentifier == nil && last.SetIdentifier != nil {
+		changes = append(changes, awsinfra.FieldChange{Field: "SetIdentifier", Current: aws.ToString(last.SetIdentifier), Desired: nil})
+	}
+	return awsinfra.MarkReplacement(changes, "Name", "Type", "SetIdentifier"), nil
 }
 
-func (rm *manager) Destroy(id awsinfra.ExternalID) error {
-	return fmt.Errorf("TODO: Need to implement")
+// Load returns the live record set
+func (rm *manager) Load(ctx context.Context, id awsinfra.ExternalID) (*types.ResourceRecordSet, error) {
+	key, err := ParseExternalID(id)
+	if err != nil {
+		return nil, err
+	}
+	record, err := rm.find(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, fmt.Errorf("record set %s not found", *id)
+	}
+	return record, nil
+}
+
+// Destroy deletes the record set with its current values and waits until the change is INSYNC.
+// Destroying a record set that no longer exists succeeds.
+func (rm *manager) Destroy(ctx context.Context, id awsinfra.ExternalID) error {
+	key, err := ParseExternalID(id)
+	if err != nil {
+		return err
+	}
+	record, err := rm.find(ctx, key)
+	if err != nil || record == nil {
+		return err
+	}
+	return rm.change(ctx, key.HostedZoneID, types.ChangeActionDelete, record)
+}
+
+// find returns the record set with the given key, or nil if it doesn't exist
+func (rm *manager) find(ctx context.Context, key RecordKey) (*types.ResourceRecordSet, error) {
+	input := &route53.ListResourceRecordSetsInput{
+		HostedZoneId:    aws.String(key.HostedZoneID),
+		StartRecordName: aws.String(key.Name),
+		StartRecordType: key.Type,
+		MaxItems:        aws.Int32(listPageSize),
+	}
+	for {
+		output, err := rm.client.ListResourceRecordSets(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		for i, record := range output.ResourceRecordSets {
+			if normalizeName(aws.ToString(record.Name)) != key.Name || record.Type != key.Type {
+				//Record sets are listed in order, so there is no match past the name and type
+				return nil, nil
+			}
+			if aws.ToString(record.SetIdentifier) == key.SetIdentifier {
+				return &output.ResourceRecordSets[i], nil
+			}
+		}
+		if !output.IsTruncated {
+			return nil, nil
+		}
+		input.StartRecordName = output.NextRecordName
+		input.StartRecordType = output.NextRecordType
+		input.StartRecordIdentifier = output.NextRecordIdentifier
+	}
+}
+
+// change submits a single change and waits until it is INSYNC
+func (rm *manager) change(ctx context.Context, zoneID string, action types.ChangeAction, record *types.ResourceRecordSet) error {
+	output, err := rm.client.ChangeResourceRecordSets(ctx, &route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String(zoneID),
+		ChangeBatch: &types.ChangeBatch{
+			Changes: []types.Change{{Action: action, ResourceRecordSet: record}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return route53.NewResourceRecordSetsChangedWaiter(rm.client).Wait(ctx, &route53.GetChangeInput{
+		Id: output.ChangeInfo.Id,
+	}, syncTimeout)
 }
@@ -0,0 +1,24 @@
+package awsinfra
+
+import (
+	elbv2types "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+)
+
+// LoadBalancer is a load balancer with its attributes.
+// DNSName and CanonicalHostedZoneId are the ones to use in DNS records pointing to it.
+type LoadBalancer struct {
+	elbv2types.LoadBalancer
+	Attributes map[string]string
+}
+
+// TargetGroup is a target group with its attributes
+type TargetGroup struct {
+	elbv2types.TargetGroup
+	Attributes map[string]string
+}
+
+// Listener is a listener with the certificates it serves besides the default one
+type Listener struct {
+	elbv2types.Listener
+	ExtraCertificates []string
+}
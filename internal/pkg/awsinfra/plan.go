@@ -0,0 +1,407 @@
+package awsinfra
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Action is the change a plan expects to make to a resource
+type Action string
+
+const (
+	// ActionCreate means the resource does not exist and will be created
+	ActionCreate Action = "create"
+	// ActionUpdate means the resource exists and will be updated in place
+	ActionUpdate Action = "update"
+	// ActionReplace means the resource exists and will be created again, changing its ExternalID
+	ActionReplace Action = "replace"
+	// ActionNoOp means the resource already matches the desired input
+	ActionNoOp Action = "no-op"
+)
+
+// FieldChange is the difference between the desired and the current value of a field.
+// Values are kept as decoded JSON, so they render the same way in text and in JSON.
+type FieldChange struct {
+	Field             string `json:"field"`
+	Current           any    `json:"current"`
+	Desired           any    `json:"desired"`
+	ForcesReplacement bool   `json:"forcesReplacement,omitempty"`
+}
+
+// ResourceChange is the planned change of a declared resource
+type ResourceChange struct {
+	ID         InternalID    `json:"id"`
+	ExternalID string        `json:"externalId,omitempty"`
+	Action     Action        `json:"action"`
+	DependsOn  []InternalID  `json:"dependsOn,omitempty"`
+	Changes    []FieldChange `json:"changes,omitempty"`
+	Reason     string        `json:"reason,omitempty"`
+}
+
+// Plan is the preview of what Apply would do with the declared resources.
+// It marshals to JSON for machine review and String renders it as human text.
+type Plan struct {
+	Changes []ResourceChange `json:"changes"`
+}
+
+// ResourceDiffer is implemented by resource managers that know how their input maps onto
+// the loaded resource and which fields can't be updated in place.
+// Plan uses DiffFields for managers that don't implement it.
+// Diff must not mutate anything, but may read from the cloud provider using ctx.
+type ResourceDiffer[Input any, Output any] interface {
+	Diff(ctx context.Context, input Input, last Output) ([]FieldChange, error)
+}
+
+// Plan previews Apply for every declared resource without mutating anything.
+// Existing resources are loaded with ResourceManager.Load and compared with the
+// desired input. The input of a resource depending on another one that will be
+// created or replaced can't be known until Apply, so it is not evaluated.
+// Declared resources are kept, so Apply can follow the Plan.
+func (i *Infra) Plan(ctx context.Context) (*Plan, error) {
+	if err := i.validateInitialization(); err != nil {
+		return nil, err
+	}
+	i.mu.Lock()
+	declared := resourceGraph{}
+	for _, id := range i.declared.ids() {
+		declared.add(i.declared.nodes[id])
+	}
+	for _, id := range declared.ids() {
+		for _, dep := range declared.nodes[id].dependsOn {
+			if _, exists := i.localStore[dep]; !exists && !declared.has(dep) {
+				i.mu.Unlock()
+				return nil, &InfraError{ErrUnknownDependency, fmt.Errorf("ID: %s, depends on %s", id, dep)}
+			}
+		}
+	}
+	i.mu.Unlock()
+	ids, err := declared.topologicalOrder()
+	if err != nil {
+		return nil, &InfraError{ErrDependencyCycle, err}
+	}
+	var mu sync.Mutex
+	changes := make(map[InternalID]ResourceChange, len(ids))
+	//Dependents read the loaded resources through ctx, leaving the Resource handles to Apply
+	ctx = context.WithValue(ctx, planOutputsKey{}, &planOutputs{outputs: make(map[InternalID]any)})
+	err = walk(ctx, ids, declared.dependencies(), i.maxWorkers, func(ctx context.Context, id InternalID) error {
+		node := declared.nodes[id]
+		//Outputs of resources to be created or replaced are unknown until Apply
+		var unknown []InternalID
+		mu.Lock()
+		for _, dep := range node.dependsOn {
+			if change, ok := changes[dep]; ok && (change.Action == ActionCreate || change.Action == ActionReplace) {
+				unknown = append(unknown, dep)
+			}
+		}
+		mu.Unlock()
+		change, err := node.plan(ctx, unknown)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		changes[id] = change
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		if _, ok := err.(*InfraError); !ok {
+			return nil, &InfraError{ErrPlanInterrupted, err}
+		}
+		return nil, err
+	}
+	plan := &Plan{Changes: make([]ResourceChange, 0, len(ids))}
+	for _, id := range ids {
+		plan.Changes = append(plan.Changes, changes[id])
+	}
+	return plan, nil
+}
+
+// planResource computes the change Apply would make to a declared resource.
+// unknown lists the dependencies whose outputs are not known until Apply.
+func planResource[Input any, Output any](ctx context.Context, infra *Infra, id InternalID, input InputFunc[Input], resourceManager ResourceManager[Input, Output], dependsOn []InternalID, unknown []InternalID) (ResourceChange, error) {
+	change := ResourceChange{ID: id, DependsOn: dependsOn}
+	exists, err := infra.resourceStore.Exists(id)
+	if err != nil {
+		return change, &InfraError{ErrFailedResourceStoreExists, fmt.Errorf("ID: %s, Caused by %v ", id, err)}
+	}
+	if !exists {
+		change.Action = ActionCreate
+		if len(unknown) > 0 {
+			change.Reason = fmt.Sprintf("input depends on %s, known after apply", strings.Join(unknown, ", "))
+			return change, nil
+		}
+		in, err := input(ctx)
+		if err != nil {
+			return change, &InfraError{ErrFailedResourceInput, fmt.Errorf("ID: %s, Caused by %v ", id, err)}
+		}
+		change.Changes, err = DesiredFields(in)
+		if err != nil {
+			return change, &InfraError{ErrFailedResourceDiff, fmt.Errorf("ID: %s, Caused by %v ", id, err)}
+		}
+		return change, nil
+	}
+	lastID, err := infra.resourceStore.Get(id)
+	if err != nil {
+		return change, &InfraError{ErrFailedResourceStoreGet, fmt.Errorf("ID: %s, Caused by %v ", id, err)}
+	}
+	if lastID != nil {
+		change.ExternalID = *lastID
+	}
+	last, err := resourceManager.Load(ctx, lastID)
+	if err != nil {
+		return change, &InfraError{ErrFailedResourceManagerLoad, fmt.Errorf("ID: %s, Caused by %v ", id, err)}
+	}
+	//Dependents read the loaded resource while planning
+	if outputs := planOutputsFrom(ctx); outputs != nil {
+		outputs.set(id, last)
+	}
+	if len(unknown) > 0 {
+		change.Action = ActionUpdate
+		change.Reason = fmt.Sprintf("input depends on %s, known after apply", strings.Join(unknown, ", "))
+		return change, nil
+	}
+	in, err := input(ctx)
+	if err != nil {
+		return change, &InfraError{ErrFailedResourceInput, fmt.Errorf("ID: %s, Caused by %v ", id, err)}
+	}
+	if differ, ok := resourceManager.(ResourceDiffer[Input, Output]); ok {
+		change.Changes, err = differ.Diff(ctx, in, last)
+	} else {
+		change.Changes, err = DiffFields(in, last)
+	}
+	if err != nil {
+		return change, &InfraError{ErrFailedResourceDiff, fmt.Errorf("ID: %s, Caused by %v ", id, err)}
+	}
+	change.Action = ActionNoOp
+	for _, fc := range change.Changes {
+		change.Action = ActionUpdate
+		if fc.ForcesReplacement {
+			change.Action = ActionReplace
+			break
+		}
+	}
+	return change, nil
+}
+
+// planOutputsKey is the context key of the planOutputs of a Plan
+type planOutputsKey struct{}
+
+// planOutputs are the resources loaded by a Plan, read by the input functions of their dependents
+type planOutputs struct {
+	mu      sync.Mutex
+	outputs map[InternalID]any
+}
+
+func (p *planOutputs) set(id InternalID, output any) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.outputs[id] = output
+}
+
+func (p *planOutputs) get(id InternalID) (any, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	output, ok := p.outputs[id]
+	return output, ok
+}
+
+// planOutputsFrom returns the planOutputs of the Plan running with ctx, nil outside of a Plan
+func planOutputsFrom(ctx context.Context) *planOutputs {
+	outputs, _ := ctx.Value(planOutputsKey{}).(*planOutputs)
+	return outputs
+}
+
+// DiffFields compares a desired input with the current resource, field by field.
+// Both values are compared through their JSON representation, so fields match by name
+// even when input and resource are different types. Fields left unset in the input,
+// and fields the resource doesn't have, are ignored. Nested objects are compared
+// recursively and reported with dotted paths, lists are compared as a whole.
+func DiffFields(desired any, current any) ([]FieldChange, error) {
+	d, err := jsonTree(desired)
+	if err != nil {
+		return nil, err
+	}
+	c, err := jsonTree(current)
+	if err != nil {
+		return nil, err
+	}
+	var changes []FieldChange
+	diffTree("", d, c, &changes)
+	return changes, nil
+}
+
+// DesiredFields lists every field set in the input, as if the current resource was empty.
+func DesiredFields(desired any) ([]FieldChange, error) {
+	d, err := jsonTree(desired)
+	if err != nil {
+		return nil, err
+	}
+	fields, ok := d.(map[string]any)
+	if !ok {
+		if d == nil {
+			return nil, nil
+		}
+		return []FieldChange{{Desired: d}}, nil
+	}
+	var changes []FieldChange
+	for _, k := range sortedKeys(fields) {
+		if fields[k] != nil {
+			changes = append(changes, FieldChange{Field: k, Desired: fields[k]})
+		}
+	}
+	return changes, nil
+}
+
+// MarkReplacement flags the changes of the given fields, or of any field nested in them, as forcing a replacement
+func MarkReplacement(changes []FieldChange, fields ...string) []FieldChange {
+	for i := range changes {
+		for _, field := range fields {
+			if changes[i].Field == field || strings.HasPrefix(changes[i].Field, field+".") {
+				changes[i].ForcesReplacement = true
+			}
+		}
+	}
+	return changes
+}
+
+func jsonTree(v any) (any, error) {
+	if v == nil {
+		return nil, nil
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Pointer && rv.IsNil() {
+		return nil, nil
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var tree any
+	if err := json.Unmarshal(raw, &tree); err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+func diffTree(path string, desired any, current any, changes *[]FieldChange) {
+	if desired == nil {
+		return
+	}
+	d, isObject := desired.(map[string]any)
+	c, currentIsObject := current.(map[string]any)
+	if !isObject || !currentIsObject {
+		if !subset(desired, current) {
+			*changes = append(*changes, FieldChange{Field: path, Current: current, Desired: desired})
+		}
+		return
+	}
+	for _, k := range sortedKeys(d) {
+		cv, ok := c[k]
+		if !ok {
+			continue
+		}
+		field := k
+		if path != "" {
+			field = path + "." + k
+		}
+		diffTree(field, d[k], cv, changes)
+	}
+}
+
+// subset reports whether every value set in desired has the same value in current
+func subset(desired any, current any) bool {
+	switch d := desired.(type) {
+	case nil:
+		return true
+	case map[string]any:
+		c, ok := current.(map[string]any)
+		if !ok {
+			return false
+		}
+		for k, v := range d {
+			if !subset(v, c[k]) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		c, ok := current.([]any)
+		if !ok || len(c) != len(d) {
+			return len(d) == 0 && current == nil
+		}
+		for i := range d {
+			if !subset(d[i], c[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(desired, current)
+	}
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// HasChanges reports whether Apply would change anything
+func (p *Plan) HasChanges() bool {
+	for _, c := range p.Changes {
+		if c.Action != ActionNoOp {
+			return true
+		}
+	}
+	return false
+}
+
+// String renders the plan as human readable text
+func (p *Plan) String() string {
+	counts := make(map[Action]int)
+	for _, c := range p.Changes {
+		counts[c.Action]++
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Plan: %d to create, %d to update, %d to replace, %d unchanged.\n",
+		counts[ActionCreate], counts[ActionUpdate], counts[ActionReplace], counts[ActionNoOp])
+	for _, c := range p.Changes {
+		b.WriteString("\n")
+		symbol := map[Action]string{ActionCreate: "+", ActionUpdate: "~", ActionReplace: "-/+", ActionNoOp: "="}[c.Action]
+		fmt.Fprintf(&b, "%s %s (%s)", symbol, c.ID, c.Action)
+		if c.ExternalID != "" {
+			fmt.Fprintf(&b, " %s", c.ExternalID)
+		}
+		b.WriteString("\n")
+		if c.Reason != "" {
+			fmt.Fprintf(&b, "    # %s\n", c.Reason)
+		}
+		for _, fc := range c.Changes {
+			if c.Action == ActionCreate {
+				fmt.Fprintf(&b, "    %s: %s\n", fc.Field, renderValue(fc.Desired))
+				continue
+			}
+			fmt.Fprintf(&b, "    %s: %s -> %s", fc.Field, renderValue(fc.Current), renderValue(fc.Desired))
+			if fc.ForcesReplacement {
+				b.WriteString(" (forces replacement)")
+			}
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+func renderValue(v any) string {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(raw)
+}
@@ -0,0 +1,236 @@
+package awsinfra
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+)
+
+type tNetworkInput struct {
+	CidrBlock *string
+	VpcId     *string
+	EnableDns *bool
+	Tags      map[string]string
+}
+
+type tNetwork struct {
+	Id        *string
+	CidrBlock *string
+	VpcId     *string
+	EnableDns *bool
+	Tags      map[string]string
+	State     string
+}
+
+// TDifferManager is a resource manager implementing ResourceDiffer
+type TDifferManager struct {
+	TResourceManager[*tNetworkInput, *tNetwork]
+}
+
+func (rm *TDifferManager) Diff(ctx context.Context, input *tNetworkInput, last *tNetwork) ([]FieldChange, error) {
+	changes, err := DiffFields(input, last)
+	return MarkReplacement(changes, "CidrBlock"), err
+}
+
+func networkInput(input *tNetworkInput) InputFunc[*tNetworkInput] {
+	return func(ctx context.Context) (*tNetworkInput, error) {
+		return input, nil
+	}
+}
+
+func TestPlan(t *testing.T) {
+	store := &TResourceStore{store: map[InternalID]ExternalID{
+		"vpc":      aws.String("vpc-1"),
+		"replaced": aws.String("vpc-2"),
+		"same":     aws.String("vpc-3"),
+	}}
+	infra := New(&TestProvider{}, store, false)
+	vpcManager := &TResourceManager[*tNetworkInput, *tNetwork]{Output: &tNetwork{
+		Id:        aws.String("vpc-1"),
+		CidrBlock: aws.String("10.0.0.0/16"),
+		EnableDns: aws.Bool(false),
+		Tags:      map[string]string{"Name": "main"},
+		State:     "available",
+	}}
+	vpc, err := declare(infra, "vpc", networkInput(&tNetworkInput{
+		CidrBlock: aws.String("10.0.0.0/16"),
+		EnableDns: aws.Bool(true),
+		Tags:      map[string]string{"Name": "main"},
+	}), ResourceManager[*tNetworkInput, *tNetwork](vpcManager), nil)
+	assert.Nil(t, err)
+	subnetManager := &TResourceManager[*tNetworkInput, *tNetwork]{}
+	_, err = declare(infra, "subnet", func(ctx context.Context) (*tNetworkInput, error) {
+		return &tNetworkInput{CidrBlock: aws.String("10.0.1.0/24"), VpcId: vpc.OutputFor(ctx).Id}, nil
+	}, ResourceManager[*tNetworkInput, *tNetwork](subnetManager), []InternalID{"vpc"})
+	assert.Nil(t, err)
+	_, err = declare(infra, "instance", func(ctx context.Context) (*tNetworkInput, error) {
+		return nil, fmt.Errorf("input of a resource depending on a new resource should not be evaluated")
+	}, ResourceManager[*tNetworkInput, *tNetwork](&TResourceManager[*tNetworkInput, *tNetwork]{}), []InternalID{"subnet"})
+	assert.Nil(t, err)
+	replacedManager := &TDifferManager{TResourceManager[*tNetworkInput, *tNetwork]{Output: &tNetwork{
+		Id:        aws.String("vpc-2"),
+		CidrBlock: aws.String("10.1.0.0/16"),
+	}}}
+	_, err = declare(infra, "replaced", networkInput(&tNetworkInput{CidrBlock: aws.String("10.2.0.0/16")}), ResourceManager[*tNetworkInput, *tNetwork](replacedManager), nil)
+	assert.Nil(t, err)
+	sameManager := &TResourceManager[*tNetworkInput, *tNetwork]{Output: &tNetwork{
+		Id:        aws.String("vpc-3"),
+		CidrBlock: aws.String("10.3.0.0/16"),
+		State:     "available",
+	}}
+	_, err = declare(infra, "same", networkInput(&tNetworkInput{CidrBlock: aws.String("10.3.0.0/16")}), ResourceManager[*tNetworkInput, *tNetwork](sameManager), nil)
+	assert.Nil(t, err)
+
+	plan, err := infra.Plan(context.Background())
+	assert.Nil(t, err)
+	assert.True(t, plan.HasChanges())
+	assert.Equal(t, []ResourceChange{
+		{
+			ID:         "vpc",
+			ExternalID: "vpc-1",
+			Action:     ActionUpdate,
+			Changes:    []FieldChange{{Field: "EnableDns", Current: false, Desired: true}},
+		},
+		{
+			ID:     "replaced",
+			Action: ActionReplace,
+			Changes: []FieldChange{
+				{Field: "CidrBlock", Current: "10.1.0.0/16", Desired: "10.2.0.0/16", ForcesReplacement: true},
+			},
+			ExternalID: "vpc-2",
+		},
+		{ID: "same", ExternalID: "vpc-3", Action: ActionNoOp},
+		{
+			ID:        "subnet",
+			Action:    ActionCreate,
+			DependsOn: []InternalID{"vpc"},
+			Changes: []FieldChange{
+				{Field: "CidrBlock", Desired: "10.0.1.0/24"},
+				{Field: "VpcId", Desired: "vpc-1"},
+			},
+		},
+		{
+			ID:        "instance",
+			Action:    ActionCreate,
+			DependsOn: []InternalID{"subnet"},
+			Reason:    "input depends on subnet, known after apply",
+		},
+	}, plan.Changes)
+
+	//Plan must not mutate anything
+	for _, rm := range []*TResourceManager[*tNetworkInput, *tNetwork]{vpcManager, subnetManager, &replacedManager.TResourceManager, sameManager} {
+		assert.Equal(t, uint(0), rm.creates+rm.updates+rm.deletes)
+	}
+	assert.Len(t, store.store, 3)
+	assert.Equal(t, 0, infra.resourceGraph.len())
+	assert.Equal(t, 5, infra.declared.len(), "Declared resources should be kept for Apply")
+	assert.Nil(t, vpc.Output(), "The handles only hold the outputs of Apply")
+
+	assert.Equal(t, `Plan: 2 to create, 1 to update, 1 to replace, 1 unchanged.
+
+~ vpc (update) vpc-1
+    EnableDns: false -> true
+
+-/+ replaced (replace) vpc-2
+    CidrBlock: "10.1.0.0/16" -> "10.2.0.0/16" (forces replacement)
+
+= same (no-op) vpc-3
+
++ subnet (create)
+    CidrBlock: "10.0.1.0/24"
+    VpcId: "vpc-1"
+
++ instance (create)
+    # input depends on subnet, known after apply
+`, plan.String())
+
+	raw, err := json.Marshal(plan)
+	assert.Nil(t, err)
+	var decoded Plan
+	assert.Nil(t, json.Unmarshal(raw, &decoded))
+	assert.Equal(t, plan.Changes, decoded.Changes)
+}
+
+func TestPlanUpdateWithUnknownInput(t *testing.T) {
+	store := &TResourceStore{store: map[InternalID]ExternalID{"subnet": aws.String("subnet-1")}}
+	infra := New(&TestProvider{}, store, false)
+	_, err := declare(infra, "vpc", networkInput(&tNetworkInput{}), ResourceManager[*tNetworkInput, *tNetwork](&TResourceManager[*tNetworkInput, *tNetwork]{}), nil)
+	assert.Nil(t, err)
+	_, err = declare(infra, "subnet", func(ctx context.Context) (*tNetworkInput, error) {
+		return nil, fmt.Errorf("should not be evaluated")
+	}, ResourceManager[*tNetworkInput, *tNetwork](&TResourceManager[*tNetworkInput, *tNetwork]{Output: &tNetwork{}}), []InternalID{"vpc"})
+	assert.Nil(t, err)
+	plan, err := infra.Plan(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, ActionUpdate, plan.Changes[1].Action)
+	assert.Equal(t, "subnet-1", plan.Changes[1].ExternalID)
+	assert.Equal(t, "input depends on vpc, known after apply", plan.Changes[1].Reason)
+}
+
+func TestPlanLoadError(t *testing.T) {
+	store := &TResourceStore{store: map[InternalID]ExternalID{"vpc": aws.String("vpc-1")}}
+	infra := New(&TestProvider{}, store, false)
+	_, err := declare(infra, "vpc", networkInput(&tNetworkInput{}), ResourceManager[*tNetworkInput, *tNetwork](&TResourceManager[*tNetworkInput, *tNetwork]{LoadErr: fmt.Errorf("Something bad has happened")}), nil)
+	assert.Nil(t, err)
+	_, err = infra.Plan(context.Background())
+	if assert.Error(t, err) {
+		assert.Equal(t, ErrFailedResourceManagerLoad, err.(*InfraError).Code)
+	}
+}
+
+func TestPlanInterrupted(t *testing.T) {
+	infra := New(&TestProvider{}, &TResourceStore{store: make(map[InternalID]ExternalID)}, false)
+	_, err := declare(infra, "vpc", networkInput(&tNetworkInput{}), ResourceManager[*tNetworkInput, *tNetwork](&TResourceManager[*tNetworkInput, *tNetwork]{}), nil)
+	assert.Nil(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = infra.Plan(ctx)
+	if assert.Error(t, err) {
+		assert.Equal(t, ErrPlanInterrupted, err.(*InfraError).Code)
+		assert.Contains(t, err.Error(), "Plan was interrupted")
+	}
+}
+
+func TestDiffFields(t *testing.T) {
+	type block struct {
+		CidrBlock *string
+		State     *string
+	}
+	type desired struct {
+		Name      *string
+		Size      int
+		Blocks    []block
+		Nested    *block
+		Unknown   *string
+		Untouched *string
+	}
+	type current struct {
+		Name      *string
+		Size      int
+		Blocks    []block
+		Nested    *block
+		Untouched *string
+	}
+	changes, err := DiffFields(&desired{
+		Name:    aws.String("main"),
+		Size:    2,
+		Blocks:  []block{{CidrBlock: aws.String("10.0.0.0/16")}},
+		Nested:  &block{CidrBlock: aws.String("10.1.0.0/16")},
+		Unknown: aws.String("ignored"),
+	}, &current{
+		Name:      aws.String("main"),
+		Size:      1,
+		Blocks:    []block{{CidrBlock: aws.String("10.0.0.0/16"), State: aws.String("associated")}},
+		Nested:    &block{CidrBlock: aws.String("10.2.0.0/16"), State: aws.String("associated")},
+		Untouched: aws.String("kept"),
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []FieldChange{
+		{Field: "Nested.CidrBlock", Current: "10.2.0.0/16", Desired: "10.1.0.0/16", ForcesReplacement: true},
+		{Field: "Size", Current: float64(1), Desired: float64(2)},
+	}, MarkReplacement(changes, "Nested"))
+}
@@ -13,10 +13,17 @@ import (
 
 	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra"
 	autoscalingautoscalinggroupmanager "github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/managers/autoscaling/autoscalinggroup"
+	ec2internetgatewaymanager "github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/managers/ec2/internetgateway"
 	ec2launchtemplatemanager "github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/managers/ec2/launchtemplate"
+	ec2natgatewaymanager "github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/managers/ec2/natgateway"
+	ec2routetablemanager "github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/managers/ec2/routetable"
+	ec2securitygroupmanager "github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/managers/ec2/securitygroup"
 	ec2subnetmanager "github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/managers/ec2/subnet"
 	ec2vpcmanager "github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/managers/ec2/vpc"
+	elasticloadbalancingv2listenermanager "github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/managers/elasticloadbalacingv2/listener"
+	elasticloadbalancingv2listenerrulemanager "github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/managers/elasticloadbalacingv2/listenerrule"
 	elasticloadbalancingv2loadbalancermanager "github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/managers/elasticloadbalacingv2/loadbalancer"
+	elasticloadbalancingv2targetgroupmanager "github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/managers/elasticloadbalacingv2/targetgroup"
 	route53resourcerecodsetmanager "github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/managers/route53/resourcerecordset"
 )
 
@@ -24,23 +31,44 @@ import (
 func NewResourceProvider(config aws.Config) awsinfra.ResourceProvider {
 	return &provider{config}
 }
-func (p *provider) VPC() awsinfra.ResourceManager[*ec2.CreateVpcInput, *ec2types.Vpc] {
+func (p *provider) VPC() awsinfra.ResourceManager[*awsinfra.VPCInput, *ec2types.Vpc] {
 	return ec2vpcmanager.New(ec2.NewFromConfig(p.config))
 }
-func (p *provider) DNSRecordSet() awsinfra.ResourceManager[*route53.ChangeResourceRecordSetsInput, *route53types.ChangeInfo] {
+func (p *provider) DNSRecordSet() awsinfra.ResourceManager[*awsinfra.DNSRecordInput, *route53types.ResourceRecordSet] {
 	return route53resourcerecodsetmanager.New(route53.NewFromConfig(p.config))
 }
-func (p *provider) Subnet() awsinfra.ResourceManager[*ec2.CreateSubnetInput, *ec2types.Subnet] {
+func (p *provider) Subnet() awsinfra.ResourceManager[*awsinfra.SubnetInput, *ec2types.Subnet] {
 	return ec2subnetmanager.New(ec2.NewFromConfig(p.config))
 }
-func (p *provider) LaunchTemplate() awsinfra.ResourceManager[*ec2.CreateLaunchTemplateInput, *ec2types.LaunchTemplate] {
-	return ec2launchtemplatemanager.New(ec2.NewFromConfig(p.config))
+func (p *provider) InternetGateway() awsinfra.ResourceManager[*awsinfra.InternetGatewayInput, *ec2types.InternetGateway] {
+	return ec2internetgatewaymanager.New(ec2.NewFromConfig(p.config))
 }
-func (p *provider) LoadBalancer() awsinfra.ResourceManager[*elbv2.CreateLoadBalancerInput, []elbv2types.LoadBalancer] {
+func (p *provider) NatGateway() awsinfra.ResourceManager[*awsinfra.NatGatewayInput, *ec2types.NatGateway] {
+	return ec2natgatewaymanager.New(ec2.NewFromConfig(p.config))
+}
+func (p *provider) RouteTable() awsinfra.ResourceManager[*awsinfra.RouteTableInput, *ec2types.RouteTable] {
+	return ec2routetablemanager.New(ec2.NewFromConfig(p.config))
+}
+func (p *provider) SecurityGroup() awsinfra.ResourceManager[*awsinfra.SecurityGroupInput, *ec2types.SecurityGroup] {
+	return ec2securitygroupmanager.New(ec2.NewFromConfig(p.config))
+}
+func (p *provider) LaunchTemplate() awsinfra.ResourceManager[*awsinfra.LaunchTemplateInput, *ec2types.LaunchTemplate] {
+	return ec2launchtemplatemanager.New(ec2.NewFromConfig(p.config), autoscaling.NewFromConfig(p.config))
+}
+func (p *provider) LoadBalancer() awsinfra.ResourceManager[*awsinfra.LoadBalancerInput, *awsinfra.LoadBalancer] {
 	return elasticloadbalancingv2loadbalancermanager.New(elbv2.NewFromConfig(p.config))
 }
-func (p *provider) AutoScalingGroup() awsinfra.ResourceManager[*autoscaling.CreateAutoScalingGroupInput, *autoscalingtypes.AutoScalingGroup] {
-	return autoscalingautoscalinggroupmanager.New(autoscaling.NewFromConfig(p.config))
+func (p *provider) TargetGroup() awsinfra.ResourceManager[*awsinfra.TargetGroupInput, *awsinfra.TargetGroup] {
+	return elasticloadbalancingv2targetgroupmanager.New(elbv2.NewFromConfig(p.config))
+}
+func (p *provider) Listener() awsinfra.ResourceManager[*awsinfra.ListenerInput, *awsinfra.Listener] {
+	return elasticloadbalancingv2listenermanager.New(elbv2.NewFromConfig(p.config))
+}
+func (p *provider) ListenerRule() awsinfra.ResourceManager[*awsinfra.ListenerRuleInput, *elbv2types.Rule] {
+	return elasticloadbalancingv2listenerrulemanager.New(elbv2.NewFromConfig(p.config))
+}
+func (p *provider) AutoScalingGroup() awsinfra.ResourceManager[*awsinfra.AutoScalingGroupInput, *autoscalingtypes.AutoScalingGroup] {
+	return autoscalingautoscalinggroupmanager.New(autoscaling.NewFromConfig(p.config), ec2.NewFromConfig(p.config))
 }
 
 type provider struct {
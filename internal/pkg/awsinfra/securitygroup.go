@@ -0,0 +1,79 @@
+package awsinfra
+
+import (
+	"fmt"
+	"slices"
+)
+
+// resolveSecurityGroups returns a copy of the input where the managed security groups referenced
+// by the rules are replaced with their ids. A rule referencing the group being created allows itself.
+func (i *Infra) resolveSecurityGroups(id InternalID, input *SecurityGroupInput) (*SecurityGroupInput, error) {
+	if input == nil {
+		return nil, nil
+	}
+	resolved := *input
+	var err error
+	if resolved.Ingress, err = i.resolveRules(id, input.Ingress); err != nil {
+		return nil, err
+	}
+	if resolved.Egress, err = i.resolveRules(id, input.Egress); err != nil {
+		return nil, err
+	}
+	return &resolved, nil
+}
+
+func (i *Infra) resolveRules(id InternalID, rules []SecurityGroupRule) ([]SecurityGroupRule, error) {
+	if rules == nil {
+		return nil, nil
+	}
+	resolved := make([]SecurityGroupRule, 0, len(rules))
+	for _, rule := range rules {
+		rule.SecurityGroupIds = slices.Clone(rule.SecurityGroupIds)
+		for _, ref := range rule.SecurityGroups {
+			if ref == id {
+				rule.Self = true
+				continue
+			}
+			groupID, err := i.externalID(id, ref)
+			if err != nil {
+				return nil, err
+			}
+			rule.SecurityGroupIds = append(rule.SecurityGroupIds, *groupID)
+		}
+		rule.SecurityGroups = nil
+		resolved = append(resolved, rule)
+	}
+	return resolved, nil
+}
+
+// externalID returns the ExternalID of the resource ref referenced by the resource id.
+// Resources applied in this execution are looked up first, as they may have been replaced.
+func (i *Infra) externalID(id InternalID, ref InternalID) (ExternalID, error) {
+	externalID, err := i.knownExternalID(ref)
+	if err == nil && externalID == nil {
+		return nil, &InfraError{ErrUnknownDependency, fmt.Errorf("ID: %s, references %s", id, ref)}
+	}
+	return externalID, err
+}
+
+// knownExternalID returns the ExternalID of the resource id, nil when it was never created
+func (i *Infra) knownExternalID(id InternalID) (ExternalID, error) {
+	i.mu.Lock()
+	externalID, ok := i.localStore[id]
+	i.mu.Unlock()
+	if ok {
+		return externalID, nil
+	}
+	exists, err := i.resourceStore.Exists(id)
+	if err != nil {
+		return nil, &InfraError{ErrFailedResourceStoreExists, fmt.Errorf("ID: %s, Caused by %v ", id, err)}
+	}
+	if !exists {
+		return nil, nil
+	}
+	externalID, err = i.resourceStore.Get(id)
+	if err != nil {
+		return nil, &InfraError{ErrFailedResourceStoreGet, fmt.Errorf("ID: %s, Caused by %v ", id, err)}
+	}
+	return externalID, nil
+}
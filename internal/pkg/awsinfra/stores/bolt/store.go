@@ -0,0 +1,194 @@
+package boltstore
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra"
+	bolt "go.etcd.io/bbolt"
+)
+
+// SchemaVersion is the version of the database layout written by the Store
+const SchemaVersion = 1
+
+// DefaultOpenTimeout is the time Open waits for another process to close the database
+const DefaultOpenTimeout = 10 * time.Second
+
+// ErrUnsupportedVersion is returned when the database was written with another schema version
+var ErrUnsupportedVersion = errors.New("unsupported schema version")
+
+var (
+	metaBucket    = []byte("meta")
+	currentBucket = []byte("current") //InternalID -> ExternalID
+	historyBucket = []byte("history") //InternalID -> sequence -> Mapping
+	versionKey    = []byte("version")
+)
+
+// Store is an awsinfra.ResourceHistory keeping the mappings in an embedded bbolt database.
+// Besides the current ExternalID of each InternalID, every Set is kept with its time and
+// the deploy that made it, so GetAt can tell what a resource pointed to in the past.
+// The database is locked by the process that opened it until Close.
+type Store struct {
+	db       *bolt.DB
+	deployID string
+	now      func() time.Time
+}
+
+// Option configures optional Store settings
+type Option func(*options)
+
+type options struct {
+	timeout time.Duration
+}
+
+// WithOpenTimeout sets the time Open waits for another process to close the database
+func WithOpenTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.timeout = d
+	}
+}
+
+// Open opens, or creates, the database at path
+func Open(path string, opts ...Option) (*Store, error) {
+	o := &options{timeout: DefaultOpenTimeout}
+	for _, opt := range opts {
+		opt(o)
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: o.timeout})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{currentBucket, historyBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		meta, err := tx.CreateBucketIfNotExists(metaBucket)
+		if err != nil {
+			return err
+		}
+		version := meta.Get(versionKey)
+		if version == nil {
+			return meta.Put(versionKey, []byte(strconv.Itoa(SchemaVersion)))
+		}
+		if string(version) != strconv.Itoa(SchemaVersion) {
+			return fmt.Errorf("%w %s, expected %d", ErrUnsupportedVersion, version, SchemaVersion)
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &Store{db: db, now: time.Now}, nil
+}
+
+// ForDeploy returns a Store sharing the database, recording deployID as the author of its Sets
+func (s *Store) ForDeploy(deployID string) *Store {
+	return &Store{db: s.db, deployID: deployID, now: s.now}
+}
+
+// Close closes the database, for every Store sharing it
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Exists reports whether the InternalID has an ExternalID
+func (s *Store) Exists(internalID awsinfra.InternalID) (bool, error) {
+	externalID, err := s.Get(internalID)
+	return externalID != nil, err
+}
+
+// Get returns the current ExternalID of the InternalID, nil when it has none
+func (s *Store) Get(internalID awsinfra.InternalID) (awsinfra.ExternalID, error) {
+	var externalID awsinfra.ExternalID
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(currentBucket).Get([]byte(internalID)); v != nil {
+			id := string(v)
+			externalID = &id
+		}
+		return nil
+	})
+	return externalID, err
+}
+
+// Set maps the InternalID to the ExternalID and records the change in its history.
+// A nil ExternalID removes the mapping. Setting the current ExternalID again changes nothing.
+func (s *Store) Set(internalID awsinfra.InternalID, externalID awsinfra.ExternalID) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		current := tx.Bucket(currentBucket)
+		last := current.Get([]byte(internalID))
+		if (last == nil && externalID == nil) || (last != nil && externalID != nil && string(last) == *externalID) {
+			return nil
+		}
+		if externalID == nil {
+			if err := current.Delete([]byte(internalID)); err != nil {
+				return err
+			}
+		} else if err := current.Put([]byte(internalID), []byte(*externalID)); err != nil {
+			return err
+		}
+		history, err := tx.Bucket(historyBucket).CreateBucketIfNotExists([]byte(internalID))
+		if err != nil {
+			return err
+		}
+		seq, err := history.NextSequence()
+		if err != nil {
+			return err
+		}
+		raw, err := json.Marshal(awsinfra.Mapping{ExternalID: externalID, SetAt: s.now().UTC(), DeployID: s.deployID})
+		if err != nil {
+			return err
+		}
+		return history.Put(binary.BigEndian.AppendUint64(nil, seq), raw)
+	})
+}
+
+// History returns every mapping of the InternalID, oldest first
+func (s *Store) History(internalID awsinfra.InternalID) ([]awsinfra.Mapping, error) {
+	var mappings []awsinfra.Mapping
+	err := s.db.View(func(tx *bolt.Tx) error {
+		history := tx.Bucket(historyBucket).Bucket([]byte(internalID))
+		if history == nil {
+			return nil
+		}
+		return history.ForEach(func(_, v []byte) error {
+			var m awsinfra.Mapping
+			if err := json.Unmarshal(v, &m); err != nil {
+				return err
+			}
+			mappings = append(mappings, m)
+			return nil
+		})
+	})
+	return mappings, err
+}
+
+// GetAt returns the ExternalID the InternalID was mapped to at the given time, nil when it had none
+func (s *Store) GetAt(internalID awsinfra.InternalID, at time.Time) (awsinfra.ExternalID, error) {
+	var externalID awsinfra.ExternalID
+	err := s.db.View(func(tx *bolt.Tx) error {
+		history := tx.Bucket(historyBucket).Bucket([]byte(internalID))
+		if history == nil {
+			return nil
+		}
+		c := history.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			var m awsinfra.Mapping
+			if err := json.Unmarshal(v, &m); err != nil {
+				return err
+			}
+			if !m.SetAt.After(at) {
+				externalID = m.ExternalID
+				return nil
+			}
+		}
+		return nil
+	})
+	return externalID, err
+}
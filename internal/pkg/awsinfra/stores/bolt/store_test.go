@@ -0,0 +1,103 @@
+package boltstore
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra"
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "infra.db")
+	store, err := Open(path)
+	assert.Nil(t, err)
+
+	exists, err := store.Exists("lb")
+	assert.Nil(t, err)
+	assert.False(t, exists)
+	assert.Nil(t, store.Set("lb", aws.String("arn:lb/1")))
+	assert.Nil(t, store.Close())
+
+	store, err = Open(path)
+	assert.Nil(t, err)
+	defer store.Close()
+	externalID, err := store.Get("lb")
+	assert.Nil(t, err)
+	assert.Equal(t, "arn:lb/1", aws.ToString(externalID))
+	assert.Nil(t, store.Set("lb", nil))
+	exists, err = store.Exists("lb")
+	assert.Nil(t, err)
+	assert.False(t, exists)
+}
+
+func TestStoreHistory(t *testing.T) {
+	store, err := Open(filepath.Join(t.TempDir(), "infra.db"))
+	assert.Nil(t, err)
+	defer store.Close()
+	monday := time.Date(2024, 4, 1, 12, 0, 0, 0, time.UTC)
+	now := monday
+	store.now = func() time.Time { return now }
+
+	assert.Nil(t, store.ForDeploy("deploy-1").Set("asg", aws.String("asg-blue")))
+	now = monday.AddDate(0, 0, 1)
+	assert.Nil(t, store.ForDeploy("deploy-2").Set("asg", aws.String("asg-green")))
+	assert.Nil(t, store.ForDeploy("deploy-2").Set("asg", aws.String("asg-green")), "Unchanged mappings are not recorded")
+	now = monday.AddDate(0, 0, 2)
+	assert.Nil(t, store.Set("asg", nil))
+
+	history, err := store.History("asg")
+	assert.Nil(t, err)
+	assert.Equal(t, []awsinfra.Mapping{
+		{ExternalID: aws.String("asg-blue"), SetAt: monday, DeployID: "deploy-1"},
+		{ExternalID: aws.String("asg-green"), SetAt: monday.AddDate(0, 0, 1), DeployID: "deploy-2"},
+		{SetAt: monday.AddDate(0, 0, 2)},
+	}, history)
+
+	tests := []struct {
+		at   time.Time
+		want awsinfra.ExternalID
+	}{
+		{monday.Add(-time.Hour), nil},
+		{monday, aws.String("asg-blue")},
+		{monday.Add(36 * time.Hour), aws.String("asg-green")},
+		{monday.AddDate(0, 1, 0), nil},
+	}
+	for _, tt := range tests {
+		got, err := store.GetAt("asg", tt.at)
+		assert.Nil(t, err)
+		assert.Equal(t, tt.want, got, tt.at.String())
+	}
+	history, err = store.History("unknown")
+	assert.Nil(t, err)
+	assert.Empty(t, history)
+}
+
+func TestStoreVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "infra.db")
+	db, err := bolt.Open(path, 0o600, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucket(metaBucket)
+		if err != nil {
+			return err
+		}
+		return meta.Put(versionKey, []byte("2"))
+	}))
+	assert.Nil(t, db.Close())
+	_, err = Open(path)
+	assert.True(t, errors.Is(err, ErrUnsupportedVersion))
+}
+
+func TestStoreLocked(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "infra.db")
+	store, err := Open(path)
+	assert.Nil(t, err)
+	defer store.Close()
+	_, err = Open(path, WithOpenTimeout(10*time.Millisecond))
+	assert.NotNil(t, err, "The database is locked by the first Store")
+}
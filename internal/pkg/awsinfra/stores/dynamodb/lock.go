@@ -0,0 +1,278 @@
+package dynamodbstore
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/wait"
+)
+
+// DefaultLease is the time the lock is held without being renewed.
+// The holder renews it every third of the lease, so a crashed holder blocks the others for a lease at most.
+const DefaultLease = 30 * time.Second
+
+var (
+	// ErrLocked is returned by Lock when another holder has the lock. The error is a *LockedError.
+	ErrLocked = errors.New("locked")
+	// ErrLockLost is returned when the lease expired and another holder took the lock, or it was force-unlocked
+	ErrLockLost = errors.New("lock lost")
+	// ErrLockNotHeld is returned when unlocking a lock that isn't held by the given holder
+	ErrLockNotHeld = errors.New("lock not held")
+)
+
+// WithLease sets the time the lock is held without being renewed
+func WithLease(d time.Duration) Option {
+	return func(s *Store) {
+		s.lease = d
+	}
+}
+
+// WithLockWait sets the time Lock waits for another holder to release the lock, none by default
+func WithLockWait(d time.Duration) Option {
+	return func(s *Store) {
+		s.lockWait = d
+	}
+}
+
+// WithLockInfo sets the description of the holder recorded in the lock, the host and process id by default
+func WithLockInfo(info string) Option {
+	return func(s *Store) {
+		s.info = info
+	}
+}
+
+// Lock is the lock item of the table
+type Lock struct {
+	Owner      string //random id of the holder
+	Info       string //description of the holder
+	AcquiredAt time.Time
+	ExpiresAt  time.Time //the lock can be taken by others once it expires
+}
+
+// LockedError is returned by Lock when another holder has the lock
+type LockedError struct {
+	Lock Lock
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("locked by %s (%s) since %s, until %s", e.Lock.Owner, e.Lock.Info,
+		e.Lock.AcquiredAt.Format(time.RFC3339), e.Lock.ExpiresAt.Format(time.RFC3339))
+}
+
+// Unwrap makes the error match ErrLocked
+func (e *LockedError) Unwrap() error {
+	return ErrLocked
+}
+
+// heldLock is the lock acquired by the Store
+type heldLock struct {
+	owner string
+	stop  chan struct{} //closed to stop renewing the lease
+	done  chan struct{} //closed once the lease is no longer renewed
+	err   error         //ErrLockLost once a renewal found another holder, guarded by Store.mu
+}
+
+// Lock acquires the lock, or fails with a *LockedError when another holder keeps it past the lock wait.
+// An expired lock is taken over. The lease is renewed in the background until Unlock.
+// Expiry is judged with the local clock, so the lease must be well above the clock skew between holders.
+func (s *Store) Lock(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.held != nil {
+		return fmt.Errorf("%s: already locked by this Store", s.table)
+	}
+	owner, err := newOwner()
+	if err != nil {
+		return err
+	}
+	var locked *LockedError
+	err = wait.Until(ctx, min(s.lease/3, time.Second), s.lockWait, func(ctx context.Context) (bool, error) {
+		err := s.acquire(ctx, owner)
+		if !conditionFailed(err) {
+			return err == nil, err
+		}
+		current, err := s.LockInfo(ctx)
+		if err != nil {
+			return false, err
+		}
+		if current != nil {
+			locked = &LockedError{Lock: *current}
+		}
+		return false, nil
+	})
+	if errors.Is(err, wait.ErrTimeout) && locked != nil {
+		return locked
+	}
+	if err != nil {
+		return err
+	}
+	s.held = &heldLock{owner: owner, stop: make(chan struct{}), done: make(chan struct{})}
+	go s.renew(s.held)
+	return nil
+}
+
+// Unlock stops renewing the lease and releases the lock.
+// It fails with ErrLockLost when another holder took the lock in the meantime.
+func (s *Store) Unlock(ctx context.Context) error {
+	s.mu.Lock()
+	held := s.held
+	s.held = nil
+	s.mu.Unlock()
+	if held == nil {
+		return fmt.Errorf("%s: %w by this Store", s.table, ErrLockNotHeld)
+	}
+	close(held.stop)
+	<-held.done
+	s.mu.Lock()
+	lost := held.err
+	s.mu.Unlock()
+	if lost != nil {
+		return lost
+	}
+	if err := s.release(ctx, held.owner); err != nil {
+		if errors.Is(err, ErrLockNotHeld) {
+			return fmt.Errorf("%s: %w", s.table, ErrLockLost)
+		}
+		return err
+	}
+	return nil
+}
+
+// ForceUnlock releases the lock of another holder, whose process died before releasing it.
+// The owner is checked, so a lock taken by someone else meanwhile is left alone with ErrLockNotHeld.
+func (s *Store) ForceUnlock(ctx context.Context, owner string) error {
+	return s.release(ctx, owner)
+}
+
+// LockInfo returns the lock item, nil when nobody has the lock. The lock may have expired.
+func (s *Store) LockInfo(ctx context.Context) (*Lock, error) {
+	output, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.table),
+		Key:            key(LockID),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if output.Item == nil {
+		return nil, nil
+	}
+	lock := &Lock{
+		AcquiredAt: time.UnixMilli(number(output.Item, acquiredAttribute)),
+		ExpiresAt:  time.UnixMilli(number(output.Item, expiresAttribute)),
+	}
+	if v, ok := output.Item[ownerAttribute].(*types.AttributeValueMemberS); ok {
+		lock.Owner = v.Value
+	}
+	if v, ok := output.Item[infoAttribute].(*types.AttributeValueMemberS); ok {
+		lock.Info = v.Value
+	}
+	return lock, nil
+}
+
+// owner returns the owner of the lock held by the Store, empty when it holds none
+func (s *Store) owner() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.held == nil {
+		return "", nil
+	}
+	if s.held.err != nil {
+		return "", s.held.err
+	}
+	return s.held.owner, nil
+}
+
+// acquire writes the lock item unless another holder has an unexpired lock
+func (s *Store) acquire(ctx context.Context, owner string) error {
+	now := s.now()
+	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.table),
+		Item: map[string]types.AttributeValue{
+			idAttribute:       &types.AttributeValueMemberS{Value: LockID},
+			ownerAttribute:    &types.AttributeValueMemberS{Value: owner},
+			infoAttribute:     &types.AttributeValueMemberS{Value: s.info},
+			acquiredAttribute: millis(now),
+			expiresAttribute:  millis(now.Add(s.lease)),
+			versionAttribute:  &types.AttributeValueMemberN{Value: strconv.Itoa(SchemaVersion)},
+		},
+		ConditionExpression:       aws.String("attribute_not_exists(#id) OR #expiresAt < :now"),
+		ExpressionAttributeNames:  map[string]string{"#id": idAttribute, "#expiresAt": expiresAttribute},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":now": millis(now)},
+	})
+	return err
+}
+
+// renew extends the lease every third of it, until the lock is released or lost
+func (s *Store) renew(held *heldLock) {
+	defer close(held.done)
+	ticker := time.NewTicker(s.lease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-held.stop:
+			return
+		case <-ticker.C:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), min(s.timeout, s.lease/3))
+		_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName:                 aws.String(s.table),
+			Key:                       key(LockID),
+			UpdateExpression:          aws.String("SET #expiresAt = :expiresAt"),
+			ConditionExpression:       aws.String("#owner = :owner"),
+			ExpressionAttributeNames:  map[string]string{"#expiresAt": expiresAttribute, "#owner": ownerAttribute},
+			ExpressionAttributeValues: map[string]types.AttributeValue{":expiresAt": millis(s.now().Add(s.lease)), ":owner": &types.AttributeValueMemberS{Value: held.owner}},
+		})
+		cancel()
+		if conditionFailed(err) {
+			s.mu.Lock()
+			held.err = fmt.Errorf("%s: %w", s.table, ErrLockLost)
+			s.mu.Unlock()
+			return
+		}
+		//Other errors are retried on the next tick, the lease outlives a couple of failed renewals
+	}
+}
+
+// release deletes the lock item if it belongs to the owner
+func (s *Store) release(ctx context.Context, owner string) error {
+	_, err := s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:                 aws.String(s.table),
+		Key:                       key(LockID),
+		ConditionExpression:       aws.String("#owner = :owner"),
+		ExpressionAttributeNames:  map[string]string{"#owner": ownerAttribute},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":owner": &types.AttributeValueMemberS{Value: owner}},
+	})
+	if conditionFailed(err) {
+		return fmt.Errorf("%s: %w by %s", s.table, ErrLockNotHeld, owner)
+	}
+	return err
+}
+
+// newOwner returns a random id for a new holder of the lock
+func newOwner() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// defaultLockInfo describes the current process
+func defaultLockInfo() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s pid %d", host, os.Getpid())
+}
+
+func millis(t time.Time) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: strconv.FormatInt(t.UnixMilli(), 10)}
+}
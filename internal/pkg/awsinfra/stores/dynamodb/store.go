@@ -0,0 +1,212 @@
+package dynamodbstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra"
+)
+
+// SchemaVersion is the version of the items written by the Store
+const SchemaVersion = 1
+
+// DefaultTimeout is the maximum time of a call to the Store
+const DefaultTimeout = 30 * time.Second
+
+// LockID is the id of the lock item, no InternalID can use it
+const LockID = "awsinfra:lock"
+
+// Attributes of the items. The table's partition key is the string attribute "id".
+const (
+	idAttribute         = "id"
+	externalIDAttribute = "externalId"
+	versionAttribute    = "version"
+	ownerAttribute      = "owner"
+	infoAttribute       = "info"
+	acquiredAttribute   = "acquiredAt" //unix milliseconds
+	expiresAttribute    = "expiresAt"  //unix milliseconds
+)
+
+var (
+	// ErrUnsupportedVersion is returned when an item was written with another schema version
+	ErrUnsupportedVersion = errors.New("unsupported schema version")
+	// ErrReservedID is returned when an InternalID is the LockID
+	ErrReservedID = errors.New("reserved id")
+)
+
+// Store is an awsinfra.ResourceStore keeping the mappings in a DynamoDB table, one item per InternalID,
+// so several teams or CI runners can share them.
+// It is also an awsinfra.ResourceLocker: the lock is an item of the same table, leased for a while and
+// renewed until Unlock. While the Store holds the lock, Set only writes if the lock is still its own.
+type Store struct {
+	client   *dynamodb.Client
+	table    string
+	timeout  time.Duration
+	lease    time.Duration
+	lockWait time.Duration
+	info     string
+	now      func() time.Time
+	mu       sync.Mutex //guards held
+	held     *heldLock  //nil unless the Store holds the lock
+}
+
+// Option configures optional Store settings
+type Option func(*Store)
+
+// WithTimeout sets the maximum time of a call to the Store
+func WithTimeout(d time.Duration) Option {
+	return func(s *Store) {
+		s.timeout = d
+	}
+}
+
+// New returns a Store keeping its mappings in the table, which must exist. See CreateTable.
+func New(client *dynamodb.Client, table string, opts ...Option) *Store {
+	s := &Store{
+		client:  client,
+		table:   table,
+		timeout: DefaultTimeout,
+		lease:   DefaultLease,
+		info:    defaultLockInfo(),
+		now:     time.Now,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// CreateTable creates a table for a Store, billed per request, and waits until it is active.
+// A table that already exists is left as is.
+func CreateTable(ctx context.Context, client *dynamodb.Client, table string) error {
+	_, err := client.CreateTable(ctx, &dynamodb.CreateTableInput{
+		TableName:            aws.String(table),
+		BillingMode:          types.BillingModePayPerRequest,
+		AttributeDefinitions: []types.AttributeDefinition{{AttributeName: aws.String(idAttribute), AttributeType: types.ScalarAttributeTypeS}},
+		KeySchema:            []types.KeySchemaElement{{AttributeName: aws.String(idAttribute), KeyType: types.KeyTypeHash}},
+	})
+	var inUse *types.ResourceInUseException
+	if err != nil && !errors.As(err, &inUse) {
+		return err
+	}
+	return dynamodb.NewTableExistsWaiter(client).Wait(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(table)}, 5*time.Minute)
+}
+
+// Exists reports whether the InternalID has an ExternalID
+func (s *Store) Exists(internalID awsinfra.InternalID) (bool, error) {
+	externalID, err := s.Get(internalID)
+	return externalID != nil, err
+}
+
+// Get returns the ExternalID of the InternalID, nil when it has none
+func (s *Store) Get(internalID awsinfra.InternalID) (awsinfra.ExternalID, error) {
+	if internalID == LockID {
+		return nil, fmt.Errorf("%s: %w", internalID, ErrReservedID)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	output, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.table),
+		Key:            key(internalID),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if output.Item == nil {
+		return nil, nil
+	}
+	if version := number(output.Item, versionAttribute); version != SchemaVersion {
+		return nil, fmt.Errorf("%s: %w %d, expected %d", internalID, ErrUnsupportedVersion, version, SchemaVersion)
+	}
+	externalID, ok := output.Item[externalIDAttribute].(*types.AttributeValueMemberS)
+	if !ok {
+		return nil, nil
+	}
+	return aws.String(externalID.Value), nil
+}
+
+// Set maps the InternalID to the ExternalID. A nil ExternalID removes the mapping.
+// While the Store holds the lock, it fails with ErrLockLost once another holder took it.
+func (s *Store) Set(internalID awsinfra.InternalID, externalID awsinfra.ExternalID) error {
+	if internalID == LockID {
+		return fmt.Errorf("%s: %w", internalID, ErrReservedID)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	write := types.TransactWriteItem{}
+	if externalID == nil {
+		write.Delete = &types.Delete{TableName: aws.String(s.table), Key: key(internalID)}
+	} else {
+		write.Put = &types.Put{TableName: aws.String(s.table), Item: map[string]types.AttributeValue{
+			idAttribute:         &types.AttributeValueMemberS{Value: internalID},
+			externalIDAttribute: &types.AttributeValueMemberS{Value: *externalID},
+			versionAttribute:    &types.AttributeValueMemberN{Value: strconv.Itoa(SchemaVersion)},
+		}}
+	}
+	owner, err := s.owner()
+	if err != nil {
+		return err
+	}
+	if owner == "" {
+		if write.Delete != nil {
+			_, err = s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{TableName: write.Delete.TableName, Key: write.Delete.Key})
+		} else {
+			_, err = s.client.PutItem(ctx, &dynamodb.PutItemInput{TableName: write.Put.TableName, Item: write.Put.Item})
+		}
+		return err
+	}
+	//Written along with a check of the lock, so a holder that lost it can't overwrite the new holder's mappings
+	_, err = s.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{write, {ConditionCheck: &types.ConditionCheck{
+			TableName:                 aws.String(s.table),
+			Key:                       key(LockID),
+			ConditionExpression:       aws.String("#owner = :owner"),
+			ExpressionAttributeNames:  map[string]string{"#owner": ownerAttribute},
+			ExpressionAttributeValues: map[string]types.AttributeValue{":owner": &types.AttributeValueMemberS{Value: owner}},
+		}}},
+	})
+	if conditionFailed(err) {
+		return fmt.Errorf("%s: %w", internalID, ErrLockLost)
+	}
+	return err
+}
+
+// key returns the primary key of the item with the id
+func key(id string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{idAttribute: &types.AttributeValueMemberS{Value: id}}
+}
+
+// number returns the numeric attribute of the item, 0 when it is missing
+func number(item map[string]types.AttributeValue, name string) int64 {
+	v, ok := item[name].(*types.AttributeValueMemberN)
+	if !ok {
+		return 0
+	}
+	n, _ := strconv.ParseInt(v.Value, 10, 64)
+	return n
+}
+
+// conditionFailed reports whether err is a failed condition of a write, or of a transaction
+func conditionFailed(err error) bool {
+	var failed *types.ConditionalCheckFailedException
+	if errors.As(err, &failed) {
+		return true
+	}
+	var canceled *types.TransactionCanceledException
+	if errors.As(err, &canceled) {
+		for _, reason := range canceled.CancellationReasons {
+			if aws.ToString(reason.Code) == "ConditionalCheckFailed" {
+				return true
+			}
+		}
+	}
+	return false
+}
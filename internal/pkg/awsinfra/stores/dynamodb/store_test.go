@@ -0,0 +1,317 @@
+package dynamodbstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// The tests run against DynamoDB Local when DYNAMODB_ENDPOINT is set, e.g. http://localhost:8000,
+// and against fakeDynamoDB otherwise.
+const endpointEnv = "DYNAMODB_ENDPOINT"
+
+// item is an item as sent over the wire, attribute name -> {"S": ...} or {"N": ...}
+type item map[string]map[string]string
+
+// fakeDynamoDB serves the subset of the DynamoDB API used by the Store, evaluating only
+// the kind of expressions it writes
+type fakeDynamoDB struct {
+	mu     sync.Mutex
+	tables map[string]map[string]item //by table and id
+}
+
+func newFakeDynamoDB() *fakeDynamoDB {
+	return &fakeDynamoDB{tables: make(map[string]map[string]item)}
+}
+
+type request struct {
+	TableName                 string
+	Key                       item
+	Item                      item
+	ConditionExpression       string
+	UpdateExpression          string
+	ExpressionAttributeNames  map[string]string
+	ExpressionAttributeValues item
+	TransactItems             []map[string]*request
+}
+
+func (f *fakeDynamoDB) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "ValidationException", nil)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	op := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "DynamoDB_20120810.")
+	switch op {
+	case "CreateTable":
+		if _, ok := f.tables[req.TableName]; ok {
+			writeError(w, "ResourceInUseException", nil)
+			return
+		}
+		f.tables[req.TableName] = make(map[string]item)
+		fmt.Fprint(w, `{}`)
+	case "DescribeTable":
+		fmt.Fprintf(w, `{"Table": {"TableName": %q, "TableStatus": "ACTIVE"}}`, req.TableName)
+	case "DeleteTable":
+		delete(f.tables, req.TableName)
+		fmt.Fprint(w, `{}`)
+	case "GetItem":
+		found := f.tables[req.TableName][req.Key["id"]["S"]]
+		json.NewEncoder(w).Encode(map[string]item{"Item": found})
+	case "PutItem", "DeleteItem", "UpdateItem":
+		if !f.check(&req, req.TableName) {
+			writeError(w, "ConditionalCheckFailedException", nil)
+			return
+		}
+		f.write(op, &req)
+		fmt.Fprint(w, `{}`)
+	case "TransactWriteItems":
+		var reasons []string
+		failed := false
+		for _, t := range req.TransactItems {
+			for _, sub := range t {
+				if f.check(sub, sub.TableName) {
+					reasons = append(reasons, `{"Code": "None"}`)
+				} else {
+					reasons, failed = append(reasons, `{"Code": "ConditionalCheckFailed"}`), true
+				}
+			}
+		}
+		if failed {
+			writeError(w, "TransactionCanceledException", reasons)
+			return
+		}
+		for _, t := range req.TransactItems {
+			for op, sub := range t {
+				f.write(op+"Item", sub)
+			}
+		}
+		fmt.Fprint(w, `{}`)
+	default:
+		writeError(w, "UnknownOperationException", nil)
+	}
+}
+
+func (f *fakeDynamoDB) write(op string, req *request) {
+	table := f.tables[req.TableName]
+	switch op {
+	case "PutItem":
+		table[req.Item["id"]["S"]] = req.Item
+	case "DeleteItem":
+		delete(table, req.Key["id"]["S"])
+	case "UpdateItem":
+		//SET #a = :a, #b = :b
+		updated := item{}
+		for k, v := range table[req.Key["id"]["S"]] {
+			updated[k] = v
+		}
+		for k, v := range req.Key {
+			updated[k] = v
+		}
+		for _, assignment := range strings.Split(strings.TrimPrefix(req.UpdateExpression, "SET "), ",") {
+			name, value, _ := strings.Cut(assignment, "=")
+			updated[req.ExpressionAttributeNames[strings.TrimSpace(name)]] = req.ExpressionAttributeValues[strings.TrimSpace(value)]
+		}
+		table[req.Key["id"]["S"]] = updated
+	}
+}
+
+// check evaluates conditions made of comparisons and attribute_(not_)exists joined by OR
+func (f *fakeDynamoDB) check(req *request, tableName string) bool {
+	if req.ConditionExpression == "" {
+		return true
+	}
+	id := req.Key["id"]["S"]
+	if req.Item != nil {
+		id = req.Item["id"]["S"]
+	}
+	current := f.tables[tableName][id]
+	for _, clause := range strings.Split(req.ConditionExpression, " OR ") {
+		clause = strings.TrimSpace(clause)
+		if name, ok := strings.CutPrefix(clause, "attribute_not_exists("); ok {
+			if _, exists := current[req.ExpressionAttributeNames[strings.TrimSuffix(name, ")")]]; !exists {
+				return true
+			}
+			continue
+		}
+		fields := strings.Fields(clause)
+		attribute, ok := current[req.ExpressionAttributeNames[fields[0]]]
+		if !ok {
+			continue
+		}
+		value := req.ExpressionAttributeValues[fields[2]]
+		switch fields[1] {
+		case "=":
+			if fmt.Sprint(attribute) == fmt.Sprint(value) {
+				return true
+			}
+		case "<":
+			var a, b int64
+			fmt.Sscan(attribute["N"], &a)
+			fmt.Sscan(value["N"], &b)
+			if a < b {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func writeError(w http.ResponseWriter, code string, reasons []string) {
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	w.WriteHeader(http.StatusBadRequest)
+	fmt.Fprintf(w, `{"__type": "com.amazonaws.dynamodb.v20120810#%s", "message": %q, "CancellationReasons": [%s]}`, code, code, strings.Join(reasons, ","))
+}
+
+// newTestStores returns Stores sharing a new table
+func newTestStores(t *testing.T, n int, opts ...Option) []*Store {
+	endpoint := os.Getenv(endpointEnv)
+	if endpoint == "" {
+		server := httptest.NewServer(newFakeDynamoDB())
+		t.Cleanup(server.Close)
+		endpoint = server.URL
+	}
+	client := dynamodb.New(dynamodb.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(endpoint),
+		Credentials:  credentials.NewStaticCredentialsProvider("local", "local", ""),
+	})
+	table := fmt.Sprintf("awsinfra-%d", time.Now().UnixNano())
+	assert.Nil(t, CreateTable(context.Background(), client, table))
+	t.Cleanup(func() {
+		client.DeleteTable(context.Background(), &dynamodb.DeleteTableInput{TableName: aws.String(table)})
+	})
+	stores := make([]*Store, n)
+	for i := range stores {
+		stores[i] = New(client, table, append([]Option{WithLockInfo(fmt.Sprintf("store %d", i))}, opts...)...)
+	}
+	return stores
+}
+
+func TestStore(t *testing.T) {
+	stores := newTestStores(t, 2)
+	store, other := stores[0], stores[1]
+
+	exists, err := store.Exists("vpc")
+	assert.Nil(t, err)
+	assert.False(t, exists)
+	assert.Nil(t, store.Set("vpc", aws.String("vpc-0a1b2c")))
+	externalID, err := other.Get("vpc")
+	assert.Nil(t, err)
+	assert.Equal(t, "vpc-0a1b2c", aws.ToString(externalID))
+
+	assert.Nil(t, other.Set("vpc", nil))
+	exists, err = store.Exists("vpc")
+	assert.Nil(t, err)
+	assert.False(t, exists)
+
+	assert.True(t, errors.Is(store.Set(LockID, aws.String("x")), ErrReservedID))
+	_, err = store.Get(LockID)
+	assert.True(t, errors.Is(err, ErrReservedID))
+}
+
+func TestStoreLock(t *testing.T) {
+	ctx := context.Background()
+	stores := newTestStores(t, 2, WithLease(300*time.Millisecond))
+	store, other := stores[0], stores[1]
+
+	assert.Nil(t, store.Lock(ctx))
+	assert.Nil(t, store.Set("vpc", aws.String("vpc-0a1b2c")))
+	time.Sleep(500 * time.Millisecond) //past the first lease, renewed meanwhile
+	err := other.Lock(ctx)
+	var locked *LockedError
+	if assert.True(t, errors.As(err, &locked)) {
+		assert.Equal(t, "store 0", locked.Lock.Info)
+		assert.True(t, errors.Is(err, ErrLocked))
+	}
+	assert.Nil(t, other.Set("subnet", aws.String("subnet-0a1b2c")), "Stores without the lock write unchecked")
+
+	assert.Nil(t, store.Unlock(ctx))
+	assert.True(t, errors.Is(store.Unlock(ctx), ErrLockNotHeld))
+	assert.Nil(t, other.Lock(ctx))
+	info, err := store.LockInfo(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "store 1", info.Info)
+	assert.Nil(t, other.Unlock(ctx))
+	info, err = store.LockInfo(ctx)
+	assert.Nil(t, err)
+	assert.Nil(t, info)
+}
+
+// crash stops renewing the lease without releasing the lock, as a dead process would
+func crash(s *Store) {
+	s.mu.Lock()
+	held := s.held
+	s.mu.Unlock()
+	close(held.stop)
+	<-held.done
+	held.stop = make(chan struct{}) //closed again by Unlock
+}
+
+func TestStoreStaleLock(t *testing.T) {
+	ctx := context.Background()
+	stores := newTestStores(t, 3, WithLease(time.Minute))
+	crashed, store, other := stores[0], stores[1], stores[2]
+	assert.Nil(t, crashed.Lock(ctx))
+	crash(crashed)
+
+	assert.True(t, errors.Is(store.Lock(ctx), ErrLocked))
+	info, err := store.LockInfo(ctx)
+	assert.Nil(t, err)
+	assert.True(t, errors.Is(store.ForceUnlock(ctx, "someone-else"), ErrLockNotHeld))
+	assert.Nil(t, store.ForceUnlock(ctx, info.Owner))
+	assert.Nil(t, store.Lock(ctx))
+
+	err = crashed.Set("vpc", aws.String("vpc-0a1b2c"))
+	assert.True(t, errors.Is(err, ErrLockLost), "A holder that lost the lock must not write")
+	exists, err := other.Exists("vpc")
+	assert.Nil(t, err)
+	assert.False(t, exists)
+	assert.True(t, errors.Is(crashed.Unlock(ctx), ErrLockLost))
+	assert.Nil(t, store.Unlock(ctx))
+}
+
+func TestStoreExpiredLock(t *testing.T) {
+	ctx := context.Background()
+	stores := newTestStores(t, 2, WithLease(200*time.Millisecond))
+	crashed := stores[0]
+	store := New(stores[1].client, stores[1].table, WithLease(200*time.Millisecond), WithLockWait(2*time.Second))
+	assert.Nil(t, crashed.Lock(ctx))
+	crash(crashed)
+
+	assert.Nil(t, store.Lock(ctx), "The lease of the crashed holder expires")
+	assert.True(t, errors.Is(crashed.Set("vpc", aws.String("vpc-0a1b2c")), ErrLockLost))
+	assert.Nil(t, store.Set("vpc", aws.String("vpc-0a1b2c")))
+	assert.Nil(t, store.Unlock(ctx))
+}
+
+func TestStoreVersion(t *testing.T) {
+	store := newTestStores(t, 1)[0]
+	_, err := store.client.PutItem(context.Background(), &dynamodb.PutItemInput{
+		TableName: aws.String(store.table),
+		Item: map[string]types.AttributeValue{
+			"id":         &types.AttributeValueMemberS{Value: "vpc"},
+			"externalId": &types.AttributeValueMemberS{Value: "vpc-0a1b2c"},
+			"version":    &types.AttributeValueMemberN{Value: "2"},
+		},
+	})
+	assert.Nil(t, err)
+	_, err = store.Get("vpc")
+	assert.True(t, errors.Is(err, ErrUnsupportedVersion))
+}
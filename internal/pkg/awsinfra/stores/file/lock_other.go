@@ -0,0 +1,15 @@
+//go:build !unix
+
+package filestore
+
+import "os"
+
+// lockFile doesn't lock on systems without flock: writes stay atomic, but concurrent
+// Sets from several processes may lose one another's mappings
+func lockFile(f *os.File, exclusive bool) error {
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
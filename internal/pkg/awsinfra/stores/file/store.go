@@ -0,0 +1,154 @@
+package filestore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra"
+)
+
+// SchemaVersion is the version of the file format written by the Store
+const SchemaVersion = 1
+
+// ErrUnsupportedVersion is returned when the file was written with another schema version
+var ErrUnsupportedVersion = errors.New("unsupported schema version")
+
+// state is the content of the file
+type state struct {
+	Version   int                            `json:"version"`
+	Resources map[awsinfra.InternalID]string `json:"resources"`
+}
+
+// Store is an awsinfra.ResourceStore keeping the InternalID to ExternalID mappings in a JSON file.
+// Every call reads the file, and every Set rewrites it atomically: the new content is written and
+// synced to a temporary file which is then renamed over the previous one, so a crash leaves either
+// the old or the new mappings. Calls are serialized with an advisory lock on a ".lock" file next to
+// it, so several Stores, in this or other processes of the host, can share the file.
+type Store struct {
+	path string
+}
+
+// New returns a Store keeping its mappings in the file at path, created by the first Set.
+// It fails when the file exists but can't be read or was written with another schema version.
+func New(path string) (*Store, error) {
+	s := &Store{path: path}
+	if _, err := s.read(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Exists reports whether the InternalID has an ExternalID
+func (s *Store) Exists(internalID awsinfra.InternalID) (bool, error) {
+	var exists bool
+	err := s.withLock(false, func() error {
+		st, err := s.read()
+		_, exists = st.Resources[internalID]
+		return err
+	})
+	return exists, err
+}
+
+// Get returns the ExternalID of the InternalID, nil when it has none
+func (s *Store) Get(internalID awsinfra.InternalID) (awsinfra.ExternalID, error) {
+	var externalID awsinfra.ExternalID
+	err := s.withLock(false, func() error {
+		st, err := s.read()
+		if v, ok := st.Resources[internalID]; ok {
+			externalID = &v
+		}
+		return err
+	})
+	return externalID, err
+}
+
+// Set maps the InternalID to the ExternalID. A nil ExternalID removes the mapping.
+func (s *Store) Set(internalID awsinfra.InternalID, externalID awsinfra.ExternalID) error {
+	return s.withLock(true, func() error {
+		st, err := s.read()
+		if err != nil {
+			return err
+		}
+		if externalID == nil {
+			delete(st.Resources, internalID)
+		} else {
+			st.Resources[internalID] = *externalID
+		}
+		return s.write(st)
+	})
+}
+
+// withLock runs f holding the lock of the file, exclusively to write it
+func (s *Store) withLock(exclusive bool, f func() error) error {
+	lock, err := os.OpenFile(s.path+".lock", os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := lockFile(lock, exclusive); err != nil {
+		return fmt.Errorf("%s: lock: %w", s.path, err)
+	}
+	defer unlockFile(lock)
+	return f()
+}
+
+// read returns the content of the file, empty when it doesn't exist yet
+func (s *Store) read() (*state, error) {
+	st := &state{Version: SchemaVersion, Resources: make(map[awsinfra.InternalID]string)}
+	raw, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return st, err
+	}
+	if err := json.Unmarshal(raw, st); err != nil {
+		return st, fmt.Errorf("%s: %w", s.path, err)
+	}
+	if st.Version != SchemaVersion {
+		return st, fmt.Errorf("%s: %w %d, expected %d", s.path, ErrUnsupportedVersion, st.Version, SchemaVersion)
+	}
+	if st.Resources == nil {
+		st.Resources = make(map[awsinfra.InternalID]string)
+	}
+	return st, nil
+}
+
+// write replaces the file with the state, through a synced temporary file renamed over it
+func (s *Store) write(st *state) error {
+	raw, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(s.path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(s.path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) //fails once renamed
+	if _, err := tmp.Write(append(raw, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+	//The rename is only durable once the directory is synced
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,86 @@
+package filestore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "infra.json")
+	store, err := New(path)
+	assert.Nil(t, err)
+
+	exists, err := store.Exists("vpc")
+	assert.Nil(t, err)
+	assert.False(t, exists)
+	externalID, err := store.Get("vpc")
+	assert.Nil(t, err)
+	assert.Nil(t, externalID)
+
+	assert.Nil(t, store.Set("vpc", aws.String("vpc-0a1b2c")))
+	exists, err = store.Exists("vpc")
+	assert.Nil(t, err)
+	assert.True(t, exists)
+
+	//Another store reads what was written
+	reopened, err := New(path)
+	assert.Nil(t, err)
+	externalID, err = reopened.Get("vpc")
+	assert.Nil(t, err)
+	assert.Equal(t, "vpc-0a1b2c", aws.ToString(externalID))
+
+	assert.Nil(t, reopened.Set("vpc", nil))
+	exists, err = store.Exists("vpc")
+	assert.Nil(t, err)
+	assert.False(t, exists)
+
+	raw, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"version": 1, "resources": {}}`, string(raw))
+	entries, err := os.ReadDir(filepath.Dir(path))
+	assert.Nil(t, err)
+	assert.Len(t, entries, 2, "Only the file and its lock are left")
+}
+
+func TestStoreVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "infra.json")
+	assert.Nil(t, os.WriteFile(path, []byte(`{"version": 2, "resources": {}}`), 0o600))
+	_, err := New(path)
+	assert.True(t, errors.Is(err, ErrUnsupportedVersion))
+
+	assert.Nil(t, os.WriteFile(path, []byte(`{"resources": {}`), 0o600))
+	_, err = New(path)
+	assert.NotNil(t, err)
+}
+
+func TestStoreConcurrentSets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "infra.json")
+	const n = 20
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			//Each goroutine has its own Store, as separate processes would
+			store, err := New(path)
+			if assert.Nil(t, err) {
+				assert.Nil(t, store.Set(fmt.Sprintf("subnet-%d", i), aws.String(fmt.Sprintf("subnet-%03d", i))))
+			}
+		}()
+	}
+	wg.Wait()
+	store, err := New(path)
+	assert.Nil(t, err)
+	for i := 0; i < n; i++ {
+		externalID, err := store.Get(fmt.Sprintf("subnet-%d", i))
+		assert.Nil(t, err)
+		assert.Equal(t, fmt.Sprintf("subnet-%03d", i), aws.ToString(externalID), "No mapping must be lost")
+	}
+}
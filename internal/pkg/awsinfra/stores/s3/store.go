@@ -0,0 +1,286 @@
+package s3store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/managers/apierror"
+)
+
+// SchemaVersion is the version of the state object written by the Store
+const SchemaVersion = 1
+
+const (
+	// DefaultTimeout is the maximum time of a call to the Store
+	DefaultTimeout = 30 * time.Second
+	// DefaultAttempts is the number of times Set retries when another writer changed the state
+	DefaultAttempts = 5
+)
+
+var (
+	// ErrUnsupportedVersion is returned when the state was written with another schema version
+	ErrUnsupportedVersion = errors.New("unsupported schema version")
+	// ErrConflict is returned by Set when other writers kept changing the state
+	ErrConflict = errors.New("state changed by another writer")
+	// ErrReadOnly is returned by Set on a Store reading a previous version of the state
+	ErrReadOnly = errors.New("read only version of the state")
+)
+
+// state is the content of the state object
+type state struct {
+	Version   int                            `json:"version"`
+	Resources map[awsinfra.InternalID]string `json:"resources"`
+}
+
+// Store is an awsinfra.ResourceStore keeping the mappings in a single JSON object of an S3 bucket,
+// so several teams or CI runners can share them.
+// Set only writes the object if it is still the one it read, comparing their ETags, and retries
+// with the new content otherwise, so concurrent writers never lose one another's mappings.
+// Reads only download the object again when its ETag changed.
+type Store struct {
+	client    *s3.Client
+	bucket    string
+	key       string
+	versionID string //set for read only Stores of a previous version
+	timeout   time.Duration
+	attempts  int
+	mu        sync.Mutex //guards the cache
+	cached    *state
+	etag      string //ETag of the cached state, empty when the object doesn't exist
+	version   string //version id of the cached state, empty when the bucket isn't versioned
+}
+
+// Option configures optional Store settings
+type Option func(*Store)
+
+// WithTimeout sets the maximum time of a call to the Store
+func WithTimeout(d time.Duration) Option {
+	return func(s *Store) {
+		s.timeout = d
+	}
+}
+
+// WithAttempts sets the number of times Set tries to write the state before failing with ErrConflict
+func WithAttempts(n int) Option {
+	return func(s *Store) {
+		s.attempts = max(n, 1)
+	}
+}
+
+// New returns a Store keeping its mappings in the object key of the bucket, created by the first Set
+func New(client *s3.Client, bucket string, key string, opts ...Option) *Store {
+	s := &Store{
+		client:   client,
+		bucket:   bucket,
+		key:      key,
+		timeout:  DefaultTimeout,
+		attempts: DefaultAttempts,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// AtVersion returns a read only Store of a previous version of the state, in a versioned bucket
+func (s *Store) AtVersion(versionID string) *Store {
+	return &Store{
+		client:    s.client,
+		bucket:    s.bucket,
+		key:       s.key,
+		versionID: versionID,
+		timeout:   s.timeout,
+		attempts:  s.attempts,
+	}
+}
+
+// Version is a version of the state object
+type Version struct {
+	VersionID    string
+	LastModified time.Time
+	IsLatest     bool
+}
+
+// Versions lists the versions of the state, newest first. Unversioned buckets have a single "null" version.
+func (s *Store) Versions(ctx context.Context) ([]Version, error) {
+	var versions []Version
+	paginator := s3.NewListObjectVersionsPaginator(s.client, &s3.ListObjectVersionsInput{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(s.key),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range page.Versions {
+			if aws.ToString(v.Key) != s.key {
+				continue
+			}
+			versions = append(versions, Version{
+				VersionID:    aws.ToString(v.VersionId),
+				LastModified: aws.ToTime(v.LastModified),
+				IsLatest:     aws.ToBool(v.IsLatest),
+			})
+		}
+	}
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].LastModified.After(versions[j].LastModified)
+	})
+	return versions, nil
+}
+
+// Exists reports whether the InternalID has an ExternalID
+func (s *Store) Exists(internalID awsinfra.InternalID) (bool, error) {
+	externalID, err := s.Get(internalID)
+	return externalID != nil, err
+}
+
+// Get returns the ExternalID of the InternalID, nil when it has none
+func (s *Store) Get(internalID awsinfra.InternalID) (awsinfra.ExternalID, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.refresh(ctx); err != nil {
+		return nil, err
+	}
+	if v, ok := s.cached.Resources[internalID]; ok {
+		return &v, nil
+	}
+	return nil, nil
+}
+
+// Set maps the InternalID to the ExternalID. A nil ExternalID removes the mapping.
+// It fails with ErrConflict when the state kept changing during all the attempts.
+func (s *Store) Set(internalID awsinfra.InternalID, externalID awsinfra.ExternalID) error {
+	if s.versionID != "" {
+		return ErrReadOnly
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for attempt := 0; attempt < s.attempts; attempt++ {
+		if err := s.refresh(ctx); err != nil {
+			return err
+		}
+		current, ok := s.cached.Resources[internalID]
+		if (!ok && externalID == nil) || (ok && externalID != nil && current == *externalID) {
+			return nil
+		}
+		next := &state{Version: SchemaVersion, Resources: make(map[awsinfra.InternalID]string, len(s.cached.Resources)+1)}
+		for k, v := range s.cached.Resources {
+			next.Resources[k] = v
+		}
+		if externalID == nil {
+			delete(next.Resources, internalID)
+		} else {
+			next.Resources[internalID] = *externalID
+		}
+		err := s.write(ctx, next)
+		if !isStatus(err, http.StatusPreconditionFailed, http.StatusConflict) {
+			return err
+		}
+		//Another writer changed the object since it was read
+	}
+	return fmt.Errorf("s3://%s/%s: %w after %d attempts", s.bucket, s.key, ErrConflict, s.attempts)
+}
+
+// refresh downloads the state unless the cached one is still current
+func (s *Store) refresh(ctx context.Context) error {
+	input := &s3.GetObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(s.key)}
+	if s.versionID != "" {
+		if s.cached != nil {
+			return nil //versions never change
+		}
+		input.VersionId = aws.String(s.versionID)
+	} else if s.cached != nil && s.etag != "" {
+		input.IfNoneMatch = aws.String(s.etag)
+	}
+	output, err := s.client.GetObject(ctx, input)
+	switch {
+	case isStatus(err, http.StatusNotModified):
+		return nil
+	case apierror.Is(err, "NoSuchKey") && s.versionID == "":
+		s.cached = &state{Version: SchemaVersion, Resources: make(map[awsinfra.InternalID]string)}
+		s.etag, s.version = "", ""
+		return nil
+	case err != nil:
+		return err
+	}
+	defer output.Body.Close()
+	raw, err := io.ReadAll(output.Body)
+	if err != nil {
+		return err
+	}
+	st := &state{}
+	if err := json.Unmarshal(raw, st); err != nil {
+		return fmt.Errorf("s3://%s/%s: %w", s.bucket, s.key, err)
+	}
+	if st.Version != SchemaVersion {
+		return fmt.Errorf("s3://%s/%s: %w %d, expected %d", s.bucket, s.key, ErrUnsupportedVersion, st.Version, SchemaVersion)
+	}
+	if st.Resources == nil {
+		st.Resources = make(map[awsinfra.InternalID]string)
+	}
+	s.cached, s.etag, s.version = st, aws.ToString(output.ETag), aws.ToString(output.VersionId)
+	return nil
+}
+
+// write uploads the state if the object is still the cached one, or still doesn't exist
+func (s *Store) write(ctx context.Context, st *state) error {
+	raw, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(s.key),
+		Body:        bytes.NewReader(raw),
+		ContentType: aws.String("application/json"),
+	}
+	if s.etag == "" {
+		input.IfNoneMatch = aws.String("*")
+	} else {
+		input.IfMatch = aws.String(s.etag)
+	}
+	output, err := s.client.PutObject(ctx, input)
+	if err != nil {
+		return err
+	}
+	s.cached, s.etag, s.version = st, aws.ToString(output.ETag), aws.ToString(output.VersionId)
+	return nil
+}
+
+// VersionID returns the version id of the state last read or written, empty for unversioned buckets
+func (s *Store) VersionID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.version
+}
+
+// isStatus reports whether err is an HTTP response error with one of the status codes
+func isStatus(err error, codes ...int) bool {
+	var re interface{ HTTPStatusCode() int }
+	if err == nil || !errors.As(err, &re) {
+		return false
+	}
+	for _, code := range codes {
+		if re.HTTPStatusCode() == code {
+			return true
+		}
+	}
+	return false
+}
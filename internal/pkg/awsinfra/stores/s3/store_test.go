@@ -0,0 +1,248 @@
+package s3store
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeS3 is a versioned bucket served over HTTP, standing in for S3 with the conditional
+// requests the Store relies on
+type fakeS3 struct {
+	mu       sync.Mutex
+	versions map[string][]objectVersion //by key, oldest first
+	gets     int
+	puts     int
+	// beforePut runs before a PUT is checked, to simulate concurrent writers
+	beforePut func()
+}
+
+type objectVersion struct {
+	id       string
+	etag     string
+	body     []byte
+	modified time.Time
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{versions: make(map[string][]objectVersion)}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	//Path style: /bucket/key
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	if len(parts) == 1 || parts[1] == "" {
+		f.list(w, r)
+		return
+	}
+	key := parts[1]
+	switch r.Method {
+	case http.MethodGet:
+		f.get(w, r, key)
+	case http.MethodPut:
+		if f.beforePut != nil {
+			f.beforePut()
+		}
+		f.put(w, r, key)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeS3) put(w http.ResponseWriter, r *http.Request, key string) {
+	body, _ := io.ReadAll(r.Body)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.puts++
+	versions := f.versions[key]
+	ifMatch, ifNoneMatch := r.Header.Get("If-Match"), r.Header.Get("If-None-Match")
+	if (ifNoneMatch == "*" && len(versions) > 0) ||
+		(ifMatch != "" && (len(versions) == 0 || versions[len(versions)-1].etag != ifMatch)) {
+		writeError(w, http.StatusPreconditionFailed, "PreconditionFailed")
+		return
+	}
+	v := objectVersion{
+		id:       fmt.Sprintf("v%d", len(versions)+1),
+		etag:     fmt.Sprintf(`"etag-%d"`, len(versions)+1),
+		body:     body,
+		modified: time.Date(2024, 4, 1, 0, len(versions), 0, 0, time.UTC),
+	}
+	f.versions[key] = append(versions, v)
+	w.Header().Set("ETag", v.etag)
+	w.Header().Set("x-amz-version-id", v.id)
+}
+
+func (f *fakeS3) get(w http.ResponseWriter, r *http.Request, key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.gets++
+	versions := f.versions[key]
+	if len(versions) == 0 {
+		writeError(w, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+	v := versions[len(versions)-1]
+	if id := r.URL.Query().Get("versionId"); id != "" {
+		found := false
+		for _, version := range versions {
+			if version.id == id {
+				v, found = version, true
+			}
+		}
+		if !found {
+			writeError(w, http.StatusNotFound, "NoSuchVersion")
+			return
+		}
+	}
+	if r.Header.Get("If-None-Match") == v.etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("ETag", v.etag)
+	w.Header().Set("x-amz-version-id", v.id)
+	w.Write(v.body)
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	type version struct {
+		Key          string
+		VersionId    string
+		IsLatest     bool
+		LastModified time.Time
+	}
+	result := struct {
+		XMLName  xml.Name  `xml:"ListVersionsResult"`
+		Versions []version `xml:"Version"`
+	}{}
+	f.mu.Lock()
+	for key, versions := range f.versions {
+		if !strings.HasPrefix(key, r.URL.Query().Get("prefix")) {
+			continue
+		}
+		for i, v := range versions {
+			result.Versions = append(result.Versions, version{Key: key, VersionId: v.id, IsLatest: i == len(versions)-1, LastModified: v.modified})
+		}
+	}
+	f.mu.Unlock()
+	xml.NewEncoder(w).Encode(result)
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+func newTestStore(t *testing.T, fake *fakeS3, opts ...Option) *Store {
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	client := s3.New(s3.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(server.URL),
+		UsePathStyle: true,
+		Credentials:  aws.AnonymousCredentials{},
+	})
+	return New(client, "infra", "prod/state.json", opts...)
+}
+
+func TestStore(t *testing.T) {
+	fake := newFakeS3()
+	store := newTestStore(t, fake)
+
+	exists, err := store.Exists("vpc")
+	assert.Nil(t, err)
+	assert.False(t, exists)
+	assert.Nil(t, store.Set("vpc", aws.String("vpc-0a1b2c")))
+	assert.Nil(t, store.Set("vpc", aws.String("vpc-0a1b2c")), "Unchanged mappings are not written")
+	assert.Equal(t, 1, fake.puts)
+	assert.Equal(t, "v1", store.VersionID())
+
+	gets := fake.gets
+	externalID, err := store.Get("vpc")
+	assert.Nil(t, err)
+	assert.Equal(t, "vpc-0a1b2c", aws.ToString(externalID))
+	assert.Equal(t, gets+1, fake.gets)
+
+	//Another Store sharing the object
+	other := New(store.client, "infra", "prod/state.json")
+	assert.Nil(t, other.Set("subnet", aws.String("subnet-0a1b2c")))
+	externalID, err = store.Get("subnet")
+	assert.Nil(t, err)
+	assert.Equal(t, "subnet-0a1b2c", aws.ToString(externalID))
+
+	assert.Nil(t, store.Set("vpc", nil))
+	exists, err = other.Exists("vpc")
+	assert.Nil(t, err)
+	assert.False(t, exists)
+}
+
+func TestStoreConflict(t *testing.T) {
+	fake := newFakeS3()
+	store := newTestStore(t, fake)
+	other := New(store.client, "infra", "prod/state.json")
+	assert.Nil(t, store.Set("vpc", aws.String("vpc-0a1b2c")))
+
+	//Another writer changes the object between the read and the write of the first attempt
+	writes := 0
+	fake.beforePut = func() {
+		writes++
+		if writes == 1 {
+			fake.beforePut = nil
+			assert.Nil(t, other.Set("subnet", aws.String("subnet-0a1b2c")))
+		}
+	}
+	assert.Nil(t, store.Set("lb", aws.String("arn:lb")))
+	for id, want := range map[string]string{"vpc": "vpc-0a1b2c", "subnet": "subnet-0a1b2c", "lb": "arn:lb"} {
+		externalID, err := other.Get(id)
+		assert.Nil(t, err)
+		assert.Equal(t, want, aws.ToString(externalID), "No mapping must be lost")
+	}
+
+	//Writers changing it every time
+	fake.beforePut = func() {
+		fake.mu.Lock()
+		versions := fake.versions["prod/state.json"]
+		versions[len(versions)-1].etag += "x"
+		fake.mu.Unlock()
+	}
+	store.attempts = 2
+	err := store.Set("asg", aws.String("asg"))
+	assert.True(t, errors.Is(err, ErrConflict))
+}
+
+func TestStoreVersions(t *testing.T) {
+	fake := newFakeS3()
+	store := newTestStore(t, fake)
+	assert.Nil(t, store.Set("asg", aws.String("asg-blue")))
+	assert.Nil(t, store.Set("asg", aws.String("asg-green")))
+
+	versions, err := store.Versions(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"v2", "v1"}, []string{versions[0].VersionID, versions[1].VersionID})
+	assert.True(t, versions[0].IsLatest)
+
+	previous := store.AtVersion("v1")
+	externalID, err := previous.Get("asg")
+	assert.Nil(t, err)
+	assert.Equal(t, "asg-blue", aws.ToString(externalID))
+	assert.True(t, errors.Is(previous.Set("asg", nil), ErrReadOnly))
+}
+
+func TestStoreVersion(t *testing.T) {
+	fake := newFakeS3()
+	fake.versions["prod/state.json"] = []objectVersion{{id: "v1", etag: `"etag-1"`, body: []byte(`{"version": 2, "resources": {}}`)}}
+	store := newTestStore(t, fake)
+	_, err := store.Get("vpc")
+	assert.True(t, errors.Is(err, ErrUnsupportedVersion))
+}
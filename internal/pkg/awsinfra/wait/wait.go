@@ -0,0 +1,35 @@
+package wait
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrTimeout is returned by Until when the condition is not met in time
+var ErrTimeout = errors.New("timed out waiting for the condition")
+
+// Until calls condition right away and then every interval, until it returns true or an error.
+// It gives up with ErrTimeout once timeout has passed, or with the context error once ctx is done.
+func Until(ctx context.Context, interval time.Duration, timeout time.Duration, condition func(ctx context.Context) (bool, error)) error {
+	deadline := time.Now().Add(timeout)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		done, err := condition(ctx)
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+		if !time.Now().Before(deadline) {
+			return ErrTimeout
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package wait
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUntil(t *testing.T) {
+	calls := 0
+	err := Until(context.Background(), time.Millisecond, time.Second, func(ctx context.Context) (bool, error) {
+		calls++
+		return calls == 3, nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, calls)
+}
+
+func TestUntilError(t *testing.T) {
+	err := Until(context.Background(), time.Millisecond, time.Second, func(ctx context.Context) (bool, error) {
+		return false, fmt.Errorf("Something bad has happened")
+	})
+	assert.EqualError(t, err, "Something bad has happened")
+}
+
+func TestUntilTimeout(t *testing.T) {
+	err := Until(context.Background(), time.Millisecond, 5*time.Millisecond, func(ctx context.Context) (bool, error) {
+		return false, nil
+	})
+	assert.ErrorIs(t, err, ErrTimeout)
+}
+
+func TestUntilCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	err := Until(ctx, time.Hour, time.Hour, func(ctx context.Context) (bool, error) {
+		cancel()
+		return false, nil
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+}
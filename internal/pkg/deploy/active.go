@@ -0,0 +1,169 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	elbv2 "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	elbv2types "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra"
+)
+
+// Tags of the main load balancer recording the active environment.
+// The load balancer manager doesn't manage deploy: tags, so applying the infrastructure keeps them.
+const (
+	TagActiveColor = "deploy:active-color"
+	TagDeployID    = "deploy:deploy-id"
+	TagUpdatedAt   = "deploy:updated-at"
+	TagRevision    = "deploy:revision"
+)
+
+// TagActiveStore keeps the ActiveState in the tags of the main load balancer,
+// so everyone reading the load balancer sees the same active colour.
+//
+// All the tags are written by a single AddTags call, so a state is never half written.
+// Tags can't be written conditionally, so SetActive compares and writes them under the lock of the store:
+// the state is only safe from concurrent writes when every writer goes through a TagActiveStore sharing the locker.
+// Each write increments a revision.
+type TagActiveStore struct {
+	client          *elbv2.Client
+	loadBalancerARN string
+	locker          awsinfra.ResourceLocker
+	mu              sync.Mutex //guards locks
+	locks           int        //number of Lock calls sharing the lock
+}
+
+// NewTagActiveStore returns an ActiveStore using the tags of the load balancer.
+// Deployments lock the state with locker, shared by everyone deploying to the load balancer,
+// such as a dynamodbstore.Store. It must not be the lock of the awsinfra resource store,
+// which the Provisioner takes while the state is locked.
+func NewTagActiveStore(client *elbv2.Client, loadBalancerARN string, locker awsinfra.ResourceLocker) *TagActiveStore {
+	return &TagActiveStore{client: client, loadBalancerARN: loadBalancerARN, locker: locker}
+}
+
+// Lock acquires the lock of locker until the returned function is called.
+// Calls nest, the lock is released by the last one.
+func (s *TagActiveStore) Lock(ctx context.Context) (func() error, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.locks == 0 {
+		if err := s.locker.Lock(ctx); err != nil {
+			return nil, err
+		}
+	}
+	s.locks++
+	return func() error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.locks--
+		if s.locks > 0 {
+			return nil
+		}
+		//Released even when ctx was cancelled, so other deployments don't wait for the lease to expire
+		return s.locker.Unlock(context.WithoutCancel(ctx))
+	}, nil
+}
+
+// Active returns the state recorded in the tags, or nil if they are missing
+func (s *TagActiveStore) Active(ctx context.Context) (*ActiveState, error) {
+	tags, err := s.tags(ctx)
+	if err != nil {
+		return nil, err
+	}
+	state, _, err := parseActiveTags(tags)
+	return state, err
+}
+
+// SetActive writes the state when the recorded state is still previous, and fails with ErrConflict otherwise.
+// The lock is held from reading the tags to writing them, nested in the lock of the deployment if any.
+func (s *TagActiveStore) SetActive(ctx context.Context, state ActiveState, previous *ActiveState) (err error) {
+	unlock, err := s.Lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if unlockErr := unlock(); unlockErr != nil {
+			err = errors.Join(err, unlockErr)
+		}
+	}()
+	tags, err := s.tags(ctx)
+	if err != nil {
+		return err
+	}
+	current, revision, err := parseActiveTags(tags)
+	if err != nil {
+		return err
+	}
+	if !sameActiveState(current, previous) {
+		return ErrConflict
+	}
+	_, err = s.client.AddTags(ctx, &elbv2.AddTagsInput{
+		ResourceArns: []string{s.loadBalancerARN},
+		Tags:         activeTags(state, revision+1),
+	})
+	return err
+}
+
+func (s *TagActiveStore) tags(ctx context.Context) (map[string]string, error) {
+	output, err := s.client.DescribeTags(ctx, &elbv2.DescribeTagsInput{ResourceArns: []string{s.loadBalancerARN}})
+	if err != nil {
+		return nil, err
+	}
+	tags := make(map[string]string)
+	for _, description := range output.TagDescriptions {
+		for _, tag := range description.Tags {
+			tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+		}
+	}
+	return tags, nil
+}
+
+// activeTags returns the tags recording the state at the revision
+func activeTags(state ActiveState, revision int64) []elbv2types.Tag {
+	return []elbv2types.Tag{
+		{Key: aws.String(TagActiveColor), Value: aws.String(string(state.Color))},
+		{Key: aws.String(TagDeployID), Value: aws.String(state.DeployID)},
+		{Key: aws.String(TagUpdatedAt), Value: aws.String(state.UpdatedAt.UTC().Format(time.RFC3339Nano))},
+		{Key: aws.String(TagRevision), Value: aws.String(strconv.FormatInt(revision, 10))},
+	}
+}
+
+// parseActiveTags returns the state and the revision recorded in the tags.
+// The state is nil and the revision 0 when no state was recorded.
+func parseActiveTags(tags map[string]string) (*ActiveState, int64, error) {
+	color, ok := tags[TagActiveColor]
+	if !ok {
+		return nil, 0, nil
+	}
+	if Color(color) != Blue && Color(color) != Green {
+		return nil, 0, fmt.Errorf("tag %s has an unknown colour %q", TagActiveColor, color)
+	}
+	state := &ActiveState{Color: Color(color), DeployID: tags[TagDeployID]}
+	if value, ok := tags[TagUpdatedAt]; ok {
+		updatedAt, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return nil, 0, fmt.Errorf("tag %s: %w", TagUpdatedAt, err)
+		}
+		state.UpdatedAt = updatedAt
+	}
+	var revision int64
+	if value, ok := tags[TagRevision]; ok {
+		var err error
+		if revision, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return nil, 0, fmt.Errorf("tag %s: %w", TagRevision, err)
+		}
+	}
+	return state, revision, nil
+}
+
+func sameActiveState(a *ActiveState, b *ActiveState) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Color == b.Color && a.DeployID == b.DeployID && a.UpdatedAt.Equal(b.UpdatedAt)
+}
@@ -0,0 +1,173 @@
+package deploy
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	elbv2 "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeTags serves DescribeTags and AddTags for a single load balancer
+type fakeTags struct {
+	mu   sync.Mutex
+	tags map[string]string
+	adds int
+}
+
+type xmlTag struct {
+	Key   string
+	Value string
+}
+
+func (f *fakeTags) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.Form.Get("Action") {
+	case "DescribeTags":
+		var tags []xmlTag
+		for key, value := range f.tags {
+			tags = append(tags, xmlTag{key, value})
+		}
+		body, _ := xml.Marshal(struct {
+			XMLName xml.Name `xml:"DescribeTagsResponse"`
+			Tags    []xmlTag `xml:"DescribeTagsResult>TagDescriptions>member>Tags>member"`
+		}{Tags: tags})
+		w.Write(body)
+	case "AddTags":
+		f.adds++
+		for i := 1; r.Form.Has(fmt.Sprintf("Tags.member.%d.Key", i)); i++ {
+			f.tags[r.Form.Get(fmt.Sprintf("Tags.member.%d.Key", i))] = r.Form.Get(fmt.Sprintf("Tags.member.%d.Value", i))
+		}
+		fmt.Fprint(w, `<AddTagsResponse><AddTagsResult/></AddTagsResponse>`)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// fakeLocker is a lock shared by several TagActiveStores, as a dynamodbstore.Store table would be
+type fakeLocker struct {
+	mu     sync.Mutex
+	locked bool
+}
+
+func (l *fakeLocker) Lock(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.locked {
+		return errors.New("locked")
+	}
+	l.locked = true
+	return nil
+}
+
+func (l *fakeLocker) Unlock(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.locked = false
+	return nil
+}
+
+func TestActiveTags(t *testing.T) {
+	state := ActiveState{Color: Green, DeployID: "d1", UpdatedAt: time.Date(2024, 3, 1, 10, 0, 0, 5, time.UTC)}
+	tags := map[string]string{}
+	for _, tag := range activeTags(state, 3) {
+		tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	parsed, revision, err := parseActiveTags(tags)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), revision)
+	assert.True(t, sameActiveState(&state, parsed))
+}
+
+func TestParseActiveTags(t *testing.T) {
+	testCases := []struct {
+		name         string
+		tags         map[string]string
+		wantState    *ActiveState
+		wantRevision int64
+		wantErr      bool
+	}{
+		{
+			name: "no state recorded",
+			tags: map[string]string{"Name": "main"},
+		},
+		{
+			name:      "without revision",
+			tags:      map[string]string{TagActiveColor: "blue", TagDeployID: "d1"},
+			wantState: &ActiveState{Color: Blue, DeployID: "d1"},
+		},
+		{
+			name:    "unknown colour",
+			tags:    map[string]string{TagActiveColor: "red"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid timestamp",
+			tags:    map[string]string{TagActiveColor: "blue", TagUpdatedAt: "yesterday"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid revision",
+			tags:    map[string]string{TagActiveColor: "blue", TagRevision: "x"},
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			state, revision, err := parseActiveTags(tc.tags)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.wantState, state)
+			assert.Equal(t, tc.wantRevision, revision)
+		})
+	}
+}
+
+func TestTagActiveStoreSetActive(t *testing.T) {
+	ctx := context.Background()
+	tags := &fakeTags{tags: map[string]string{"Name": "main"}}
+	server := httptest.NewServer(tags)
+	t.Cleanup(server.Close)
+	client := elbv2.New(elbv2.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(server.URL),
+		Credentials:  credentials.NewStaticCredentialsProvider("test", "test", ""),
+	})
+	locker := &fakeLocker{}
+	store := NewTagActiveStore(client, "arn:aws:elasticloadbalancing:us-east-1:123456789012:loadbalancer/app/main/1", locker)
+	other := NewTagActiveStore(client, "arn:aws:elasticloadbalancing:us-east-1:123456789012:loadbalancer/app/main/1", locker)
+
+	blue := ActiveState{Color: Blue, DeployID: "d1", UpdatedAt: time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)}
+	unlock, err := store.Lock(ctx)
+	assert.NoError(t, err)
+	assert.Error(t, other.SetActive(ctx, blue, nil), "Another deployment holds the lock")
+	assert.Equal(t, 0, tags.adds)
+	assert.NoError(t, store.SetActive(ctx, blue, nil), "The lock of the deployment is shared")
+	assert.True(t, locker.locked)
+	assert.NoError(t, unlock())
+	assert.False(t, locker.locked)
+
+	green := ActiveState{Color: Green, DeployID: "d2", UpdatedAt: blue.UpdatedAt.Add(time.Hour)}
+	assert.True(t, errors.Is(other.SetActive(ctx, green, nil), ErrConflict), "The state changed since it was read")
+	assert.NoError(t, other.SetActive(ctx, green, &blue))
+	assert.False(t, locker.locked)
+	assert.Equal(t, 2, tags.adds)
+	state, err := store.Active(ctx)
+	assert.NoError(t, err)
+	assert.True(t, sameActiveState(&green, state))
+	assert.Equal(t, "2", tags.tags[TagRevision])
+}
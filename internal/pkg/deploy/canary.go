@@ -0,0 +1,75 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	elbv2 "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	elbv2types "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+)
+
+// CanarySwitcher moves the traffic of a listener progressively, by weighting the forward action
+// between the target groups of both colours. After each step it waits for the bake time and checks
+// the health of the new colour; when the check fails all the traffic is sent back to the old colour.
+type CanarySwitcher struct {
+	shifter
+	client      *elbv2.Client
+	listenerARN string
+}
+
+// NewCanarySwitcher returns a Switcher shifting the traffic of the listener in steps,
+// gating each step with health
+func NewCanarySwitcher(client *elbv2.Client, listenerARN string, health HealthChecker, opts ...ShiftOption) *CanarySwitcher {
+	return &CanarySwitcher{
+		shifter:     newShifter(health, opts),
+		client:      client,
+		listenerARN: listenerARN,
+	}
+}
+
+// Switch shifts the traffic from the from environment to the to environment step by step.
+// On the first deployment there is no traffic to shift, so it is all sent to the new colour at once.
+func (s *CanarySwitcher) Switch(ctx context.Context, from *Environment, to *Environment) error {
+	if len(to.TargetGroupARNs) == 0 {
+		return fmt.Errorf("the %s environment has no target groups", to.Color)
+	}
+	return s.run(ctx, from, to, 0, s.shift)
+}
+
+// Restore sends all the traffic of the listener to the to environment in a single step
+func (s *CanarySwitcher) Restore(ctx context.Context, from *Environment, to *Environment) error {
+	if len(to.TargetGroupARNs) == 0 {
+		return fmt.Errorf("the %s environment has no target groups", to.Color)
+	}
+	return s.shift(ctx, from, to, 100)
+}
+
+// shift sends percent of the traffic to the to environment and the rest to the from environment
+func (s *CanarySwitcher) shift(ctx context.Context, from *Environment, to *Environment, percent int32) error {
+	arns, weights := canaryWeights(from, to, percent)
+	_, err := s.client.ModifyListener(ctx, &elbv2.ModifyListenerInput{
+		ListenerArn:    aws.String(s.listenerARN),
+		DefaultActions: []elbv2types.Action{forwardAction(arns, weights)},
+	})
+	return err
+}
+
+// canaryWeights returns the target groups of both colours with their weights.
+// Each target group of a colour gets the percentage of its colour, so the colours are
+// expected to have the same number of target groups.
+func canaryWeights(from *Environment, to *Environment, percent int32) ([]string, map[string]int32) {
+	var arns []string
+	weights := make(map[string]int32)
+	if from != nil {
+		for _, arn := range from.TargetGroupARNs {
+			arns = append(arns, arn)
+			weights[arn] = 100 - percent
+		}
+	}
+	for _, arn := range to.TargetGroupARNs {
+		arns = append(arns, arn)
+		weights[arn] = percent
+	}
+	return arns, weights
+}
@@ -0,0 +1,20 @@
+package deploy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanaryWeights(t *testing.T) {
+	from := &Environment{Color: Blue, TargetGroupARNs: []string{"blue"}}
+	to := &Environment{Color: Green, TargetGroupARNs: []string{"green"}}
+
+	arns, weights := canaryWeights(from, to, 25)
+	assert.Equal(t, []string{"blue", "green"}, arns)
+	assert.Equal(t, map[string]int32{"blue": 75, "green": 25}, weights)
+
+	arns, weights = canaryWeights(nil, to, 100)
+	assert.Equal(t, []string{"green"}, arns)
+	assert.Equal(t, map[string]int32{"green": 100}, weights)
+}
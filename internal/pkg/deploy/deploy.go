@@ -0,0 +1,372 @@
+// Package deploy performs zero downtime blue/green deployments on top of awsinfra.
+// Two identical environments, blue and green, run the service; a deployment provisions
+// the idle one with a new image, checks it and then moves the traffic to it.
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/wait"
+)
+
+// Color names one of the two environments
+type Color string
+
+const (
+	// Blue is the environment deployed first
+	Blue Color = "blue"
+	// Green is the environment deployed while blue is active
+	Green Color = "green"
+)
+
+// Other returns the opposite colour
+func (c Color) Other() Color {
+	if c == Blue {
+		return Green
+	}
+	return Blue
+}
+
+// Environment is the part of the service running on one colour
+type Environment struct {
+	Color                Color
+	AutoScalingGroupName string
+	DesiredCapacity      int32
+	TargetGroupARNs      []string //target groups the instances of the colour are registered with
+	Endpoint             string   //DNS name reaching only this colour, used by smoke tests
+}
+
+// Provisioner creates and updates the resources of the colours
+type Provisioner interface {
+	// Provision creates or updates the environment of the colour so it runs the image
+	Provision(ctx context.Context, color Color, imageID string) (*Environment, error)
+	// Environment returns the environment of the colour as it is, without changing it
+	Environment(ctx context.Context, color Color) (*Environment, error)
+	// ScaleDown stops the instances of the environment, keeping its resources for the next deployment
+	ScaleDown(ctx context.Context, env *Environment) error
+}
+
+// HealthChecker tells whether an environment is ready to receive traffic
+type HealthChecker interface {
+	Healthy(ctx context.Context, env *Environment) (bool, error)
+}
+
+// SmokeTester checks an environment works before it receives traffic
+type SmokeTester interface {
+	Test(ctx context.Context, env *Environment) error
+}
+
+// Switcher moves the traffic from one environment to the other.
+// from is nil on the first deployment, when there is no traffic to move yet.
+type Switcher interface {
+	Switch(ctx context.Context, from *Environment, to *Environment) error
+	// Restore sends all the traffic to the to environment at once, without baking or checking it.
+	// Rollbacks use it to go back to the environment that was running fine before the switch.
+	Restore(ctx context.Context, from *Environment, to *Environment) error
+}
+
+// ActiveState records which colour receives the traffic
+type ActiveState struct {
+	Color     Color
+	DeployID  string //deployment that switched the traffic to Color
+	UpdatedAt time.Time
+}
+
+// ActiveStore persists the ActiveState
+type ActiveStore interface {
+	// Lock keeps other deployments from changing the state until the returned function is called.
+	// It fails when another deployment holds the lock. Calls nest, the lock is released by the last one.
+	Lock(ctx context.Context) (func() error, error)
+	// Active returns the current state, or nil if no deployment switched traffic yet
+	Active(ctx context.Context) (*ActiveState, error)
+	// SetActive replaces the state. It fails with ErrConflict when the current state
+	// is not previous anymore, because another deployment changed it.
+	SetActive(ctx context.Context, state ActiveState, previous *ActiveState) error
+}
+
+// ErrConflict is returned by ActiveStore.SetActive when another deployment changed the active state
+var ErrConflict = errors.New("the active environment was changed by another deployment")
+
+// Status is the outcome of a deployment
+type Status string
+
+const (
+	// StatusSucceeded means the new colour receives the traffic
+	StatusSucceeded Status = "succeeded"
+	// StatusFailed means a step failed, Reason tells which
+	StatusFailed Status = "failed"
+	// StatusRolledBack means a Guard caught a regression after the switch and the traffic
+	// was sent back to From, Reason tells which
+	StatusRolledBack Status = "rolled back"
+)
+
+// Deployment is the record of a deployment
+type Deployment struct {
+	ID           string
+	ImageID      string
+	From         Color //empty on the first deployment
+	To           Color
+	Status       Status
+	Reason       string //why the deployment failed or was rolled back
+	StartedAt    time.Time
+	SwitchedAt   time.Time //set once traffic reached To, even if a later step failed
+	RolledBackAt time.Time //set once traffic is back to From
+	FinishedAt   time.Time
+}
+
+// Default settings of a Deployer
+const (
+	DefaultWarmWindow    = 15 * time.Minute
+	DefaultHealthTimeout = 10 * time.Minute
+	DefaultPollInterval  = 15 * time.Second
+	DefaultGuardFailures = 3
+)
+
+// Deployer runs blue/green deployments
+type Deployer struct {
+	provisioner   Provisioner
+	health        HealthChecker
+	switcher      Switcher
+	active        ActiveStore
+	smoke         SmokeTester
+	guards        []Guard
+	warmWindow    time.Duration //time the previous colour keeps running after the switch
+	healthTimeout time.Duration //maximum time to wait for the new colour to be healthy
+	pollInterval  time.Duration //time between health checks
+	guardFailures int           //consecutive errors of a guard taken as a regression
+	now           func() time.Time
+}
+
+// Option configures optional Deployer settings
+type Option func(*Deployer)
+
+// WithSmokeTests runs the tests against the new colour before switching the traffic to it
+func WithSmokeTests(smoke SmokeTester) Option {
+	return func(d *Deployer) {
+		d.smoke = smoke
+	}
+}
+
+// WithGuards watches the new colour with the guards during the warm window. When one of them
+// reports a regression, the traffic is switched back to the previous colour, which is kept running.
+func WithGuards(guards ...Guard) Option {
+	return func(d *Deployer) {
+		d.guards = append(d.guards, guards...)
+	}
+}
+
+// WithGuardFailures sets how many checks in a row a guard can fail, with an error other than a *Regression,
+// before the new colour is taken as regressed: a guard that can't tell must not keep the traffic on it.
+func WithGuardFailures(failures int) Option {
+	return func(d *Deployer) {
+		d.guardFailures = failures
+	}
+}
+
+// WithWarmWindow sets how long the previous colour keeps running after the switch,
+// so traffic can be sent back to it right away. It is then scaled down.
+func WithWarmWindow(window time.Duration) Option {
+	return func(d *Deployer) {
+		d.warmWindow = window
+	}
+}
+
+// WithHealthTimeout sets the maximum time to wait for the new colour to be healthy
+func WithHealthTimeout(timeout time.Duration) Option {
+	return func(d *Deployer) {
+		d.healthTimeout = timeout
+	}
+}
+
+// WithPollInterval sets the time between health checks, and between guard checks
+func WithPollInterval(interval time.Duration) Option {
+	return func(d *Deployer) {
+		d.pollInterval = interval
+	}
+}
+
+// New returns a Deployer provisioning the colours with provisioner, checking them with health,
+// moving traffic with switcher and recording the active colour in active.
+func New(provisioner Provisioner, health HealthChecker, switcher Switcher, active ActiveStore, opts ...Option) *Deployer {
+	d := &Deployer{
+		provisioner:   provisioner,
+		health:        health,
+		switcher:      switcher,
+		active:        active,
+		warmWindow:    DefaultWarmWindow,
+		healthTimeout: DefaultHealthTimeout,
+		pollInterval:  DefaultPollInterval,
+		guardFailures: DefaultGuardFailures,
+		now:           time.Now,
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// Deploy runs the image on the idle colour and moves the traffic to it:
+//  1. the active state is locked until Deploy returns, so concurrent deployments fail right away
+//     instead of provisioning or switching the same colour; the active colour is read,
+//     the idle colour is the other one, or blue on the first deployment
+//  2. the idle colour is provisioned with the image
+//  3. Deploy waits for it to be healthy and runs the smoke tests
+//  4. the traffic is switched and the new active colour recorded; when it can't be recorded,
+//     the traffic is sent back to the previous colour
+//  5. the previous colour is kept warm for the warm window and then scaled down.
+//     During the window the guards watch the new colour; on a regression the traffic is switched back.
+//
+// The returned Deployment records the outcome, also when an error is returned.
+func (d *Deployer) Deploy(ctx context.Context, id string, imageID string) (_ *Deployment, err error) {
+	deployment := &Deployment{ID: id, ImageID: imageID, StartedAt: d.now()}
+	fail := func(err error) (*Deployment, error) {
+		deployment.Status = StatusFailed
+		deployment.Reason = err.Error()
+		deployment.FinishedAt = d.now()
+		return deployment, err
+	}
+	unlock, err := d.active.Lock(ctx)
+	if err != nil {
+		return fail(fmt.Errorf("locking the active environment: %w", err))
+	}
+	defer func() {
+		if unlockErr := unlock(); unlockErr != nil {
+			err = errors.Join(err, fmt.Errorf("unlocking the active environment: %w", unlockErr))
+		}
+	}()
+	state, err := d.active.Active(ctx)
+	if err != nil {
+		return fail(fmt.Errorf("reading the active environment: %w", err))
+	}
+	deployment.To = Blue
+	var from *Environment
+	if state != nil {
+		deployment.From = state.Color
+		deployment.To = state.Color.Other()
+		if from, err = d.provisioner.Environment(ctx, state.Color); err != nil {
+			return fail(fmt.Errorf("loading the %s environment: %w", state.Color, err))
+		}
+	}
+	to, err := d.provisioner.Provision(ctx, deployment.To, imageID)
+	if err != nil {
+		return fail(fmt.Errorf("provisioning the %s environment: %w", deployment.To, err))
+	}
+	if err := d.waitHealthy(ctx, to); err != nil {
+		return fail(fmt.Errorf("waiting for the %s environment to be healthy: %w", to.Color, err))
+	}
+	if d.smoke != nil {
+		if err := d.smoke.Test(ctx, to); err != nil {
+			return fail(fmt.Errorf("smoke testing the %s environment: %w", to.Color, err))
+		}
+	}
+	if err := d.switcher.Switch(ctx, from, to); err != nil {
+		return fail(fmt.Errorf("switching traffic to the %s environment: %w", to.Color, err))
+	}
+	deployment.SwitchedAt = d.now()
+	if err := d.active.SetActive(ctx, ActiveState{Color: to.Color, DeployID: id, UpdatedAt: deployment.SwitchedAt}, state); err != nil {
+		err = fmt.Errorf("recording the %s environment as active: %w", to.Color, err)
+		if from == nil {
+			return fail(err)
+		}
+		//The recorded colour is still from, so it must get the traffic back: the next deployment provisions to
+		if restoreErr := d.switcher.Restore(context.WithoutCancel(ctx), to, from); restoreErr != nil {
+			return fail(errors.Join(err, fmt.Errorf("switching traffic back to the %s environment: %w", from.Color, restoreErr)))
+		}
+		deployment.RolledBackAt = d.now()
+		return fail(err)
+	}
+	if from != nil {
+		if err := d.watch(ctx, to, deployment.SwitchedAt); err != nil {
+			var regression *Regression
+			if errors.As(err, &regression) {
+				return d.rollback(ctx, deployment, from, to, regression)
+			}
+			return fail(fmt.Errorf("keeping the %s environment warm: %w", from.Color, err))
+		}
+		if err := d.provisioner.ScaleDown(ctx, from); err != nil {
+			return fail(fmt.Errorf("scaling down the %s environment: %w", from.Color, err))
+		}
+	}
+	deployment.Status = StatusSucceeded
+	deployment.FinishedAt = d.now()
+	return deployment, nil
+}
+
+// watch runs the guards against the new environment until the end of the warm window.
+// It returns the first *Regression reported, or a *Regression once a guard failed guardFailures checks in a row.
+func (d *Deployer) watch(ctx context.Context, env *Environment, since time.Time) error {
+	if len(d.guards) == 0 {
+		return sleep(ctx, d.warmWindow)
+	}
+	failures := make([]int, len(d.guards))
+	err := wait.Until(ctx, d.pollInterval, d.warmWindow, func(ctx context.Context) (bool, error) {
+		for i, guard := range d.guards {
+			err := guard.Check(ctx, env, since)
+			var regression *Regression
+			if errors.As(err, &regression) {
+				return false, err
+			}
+			if err == nil {
+				failures[i] = 0
+				continue
+			}
+			if failures[i]++; failures[i] >= d.guardFailures {
+				return false, &Regression{fmt.Sprintf("the %s environment can't be checked, a guard failed %d times in a row: %v", env.Color, failures[i], err)}
+			}
+		}
+		return false, nil
+	})
+	if errors.Is(err, wait.ErrTimeout) {
+		//The warm window ended without regression
+		return nil
+	}
+	return err
+}
+
+// rollback sends all the traffic back to the from environment at once and records it as active again.
+// The to environment is left running, so the regression can be investigated.
+func (d *Deployer) rollback(ctx context.Context, deployment *Deployment, from *Environment, to *Environment, regression *Regression) (*Deployment, error) {
+	deployment.Status = StatusRolledBack
+	deployment.Reason = regression.Reason
+	//Roll back even when ctx is done, the traffic is going to a regressed environment
+	ctx = context.WithoutCancel(ctx)
+	err := fmt.Errorf("the %s environment regressed: %w", to.Color, regression)
+	if switchErr := d.switcher.Restore(ctx, to, from); switchErr != nil {
+		deployment.Status = StatusFailed
+		deployment.Reason = fmt.Sprintf("%s; switching traffic back to the %s environment: %s", regression.Reason, from.Color, switchErr)
+		deployment.FinishedAt = d.now()
+		return deployment, errors.Join(err, switchErr)
+	}
+	deployment.RolledBackAt = d.now()
+	current := ActiveState{Color: to.Color, DeployID: deployment.ID, UpdatedAt: deployment.SwitchedAt}
+	if setErr := d.active.SetActive(ctx, ActiveState{Color: from.Color, DeployID: deployment.ID, UpdatedAt: deployment.RolledBackAt}, &current); setErr != nil {
+		deployment.Reason = fmt.Sprintf("%s; recording the %s environment as active: %s", regression.Reason, from.Color, setErr)
+		err = errors.Join(err, setErr)
+	}
+	deployment.FinishedAt = d.now()
+	return deployment, err
+}
+
+func (d *Deployer) waitHealthy(ctx context.Context, env *Environment) error {
+	return wait.Until(ctx, d.pollInterval, d.healthTimeout, func(ctx context.Context) (bool, error) {
+		return d.health.Healthy(ctx, env)
+	})
+}
+
+// sleep waits for the duration or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,341 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/wait"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeProvisioner struct {
+	images     map[Color]string
+	scaledDown []Color
+}
+
+func (p *fakeProvisioner) Provision(ctx context.Context, color Color, imageID string) (*Environment, error) {
+	p.images[color] = imageID
+	return p.Environment(ctx, color)
+}
+
+func (p *fakeProvisioner) Environment(ctx context.Context, color Color) (*Environment, error) {
+	return &Environment{Color: color, AutoScalingGroupName: string(color), DesiredCapacity: 2, TargetGroupARNs: []string{string(color)}, Endpoint: string(color) + ".elb"}, nil
+}
+
+func (p *fakeProvisioner) ScaleDown(ctx context.Context, env *Environment) error {
+	p.scaledDown = append(p.scaledDown, env.Color)
+	return nil
+}
+
+type fakeHealth struct {
+	healthy bool
+}
+
+func (h *fakeHealth) Healthy(ctx context.Context, env *Environment) (bool, error) {
+	return h.healthy, nil
+}
+
+type fakeSmoke struct {
+	err error
+}
+
+func (s *fakeSmoke) Test(ctx context.Context, env *Environment) error {
+	return s.err
+}
+
+type fakeSwitcher struct {
+	switches [][2]Color
+	restores [][2]Color
+}
+
+func (s *fakeSwitcher) Switch(ctx context.Context, from *Environment, to *Environment) error {
+	var fromColor Color
+	if from != nil {
+		fromColor = from.Color
+	}
+	s.switches = append(s.switches, [2]Color{fromColor, to.Color})
+	return nil
+}
+
+func (s *fakeSwitcher) Restore(ctx context.Context, from *Environment, to *Environment) error {
+	s.restores = append(s.restores, [2]Color{from.Color, to.Color})
+	return nil
+}
+
+type fakeActiveStore struct {
+	state  *ActiveState
+	locked bool
+	setErr error
+}
+
+func (s *fakeActiveStore) Lock(ctx context.Context) (func() error, error) {
+	if s.locked {
+		return nil, errors.New("locked by another deployment")
+	}
+	s.locked = true
+	return func() error {
+		s.locked = false
+		return nil
+	}, nil
+}
+
+func (s *fakeActiveStore) Active(ctx context.Context) (*ActiveState, error) {
+	return s.state, nil
+}
+
+func (s *fakeActiveStore) SetActive(ctx context.Context, state ActiveState, previous *ActiveState) error {
+	if s.setErr != nil {
+		return s.setErr
+	}
+	if (previous == nil) != (s.state == nil) || (previous != nil && *previous != *s.state) {
+		return ErrConflict
+	}
+	s.state = &state
+	return nil
+}
+
+func TestDeploy(t *testing.T) {
+	testCases := []struct {
+		name           string
+		active         *ActiveState
+		healthy        bool
+		smokeErr       error
+		wantErr        error
+		wantFrom       Color
+		wantTo         Color
+		wantStatus     Status
+		wantSwitches   [][2]Color
+		wantScaledDown []Color
+		wantActive     Color
+	}{
+		{
+			name:         "first deployment goes to blue",
+			healthy:      true,
+			wantTo:       Blue,
+			wantStatus:   StatusSucceeded,
+			wantSwitches: [][2]Color{{"", Blue}},
+			wantActive:   Blue,
+		},
+		{
+			name:           "blue to green",
+			active:         &ActiveState{Color: Blue, DeployID: "d0"},
+			healthy:        true,
+			wantFrom:       Blue,
+			wantTo:         Green,
+			wantStatus:     StatusSucceeded,
+			wantSwitches:   [][2]Color{{Blue, Green}},
+			wantScaledDown: []Color{Blue},
+			wantActive:     Green,
+		},
+		{
+			name:       "failing smoke tests keep the traffic",
+			active:     &ActiveState{Color: Green, DeployID: "d0"},
+			healthy:    true,
+			smokeErr:   errors.New("smoke"),
+			wantErr:    errors.New("smoke"),
+			wantFrom:   Green,
+			wantTo:     Blue,
+			wantStatus: StatusFailed,
+			wantActive: Green,
+		},
+		{
+			name:       "unhealthy environment times out",
+			active:     &ActiveState{Color: Blue, DeployID: "d0"},
+			wantErr:    wait.ErrTimeout,
+			wantFrom:   Blue,
+			wantTo:     Green,
+			wantStatus: StatusFailed,
+			wantActive: Blue,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			provisioner := &fakeProvisioner{images: map[Color]string{}}
+			switcher := &fakeSwitcher{}
+			active := &fakeActiveStore{state: tc.active}
+			deployer := New(provisioner, &fakeHealth{tc.healthy}, switcher, active,
+				WithSmokeTests(&fakeSmoke{tc.smokeErr}),
+				WithWarmWindow(0),
+				WithHealthTimeout(10*time.Millisecond),
+				WithPollInterval(time.Millisecond),
+			)
+			deployment, err := deployer.Deploy(context.Background(), "d1", "ami-1")
+			if tc.wantErr != nil {
+				assert.ErrorContains(t, err, tc.wantErr.Error())
+				assert.NotEmpty(t, deployment.Reason)
+			} else {
+				assert.NoError(t, err)
+				assert.False(t, deployment.SwitchedAt.IsZero())
+			}
+			assert.Equal(t, tc.wantFrom, deployment.From)
+			assert.Equal(t, tc.wantTo, deployment.To)
+			assert.Equal(t, tc.wantStatus, deployment.Status)
+			assert.Equal(t, "ami-1", provisioner.images[tc.wantTo])
+			assert.Equal(t, tc.wantSwitches, switcher.switches)
+			assert.Equal(t, tc.wantScaledDown, provisioner.scaledDown)
+			assert.Equal(t, tc.wantActive, active.state.Color)
+			assert.False(t, active.locked, "The lock is released, also when the deployment failed")
+		})
+	}
+}
+
+func TestDeployLocksTheActiveState(t *testing.T) {
+	provisioner := &fakeProvisioner{images: map[Color]string{}}
+	switcher := &fakeSwitcher{}
+	active := &fakeActiveStore{state: &ActiveState{Color: Blue, DeployID: "d0"}, locked: true}
+	deployer := New(provisioner, &fakeHealth{true}, switcher, active, WithWarmWindow(0))
+
+	deployment, err := deployer.Deploy(context.Background(), "d1", "ami-1")
+	assert.ErrorContains(t, err, "locked by another deployment")
+	assert.Equal(t, StatusFailed, deployment.Status)
+	assert.Empty(t, provisioner.images, "Nothing is provisioned while another deployment holds the lock")
+	assert.Empty(t, switcher.switches)
+
+	active.locked = false
+	_, err = deployer.Deploy(context.Background(), "d1", "ami-1")
+	assert.NoError(t, err)
+	assert.False(t, active.locked, "The lock is released once the deployment is done")
+}
+
+func TestDeployRestoresTheTrafficWhenTheActiveStateIsNotRecorded(t *testing.T) {
+	provisioner := &fakeProvisioner{images: map[Color]string{}}
+	switcher := &fakeSwitcher{}
+	active := &fakeActiveStore{state: &ActiveState{Color: Blue, DeployID: "d0"}, setErr: errors.New("throttled")}
+	deployer := New(provisioner, &fakeHealth{true}, switcher, active, WithWarmWindow(0))
+
+	deployment, err := deployer.Deploy(context.Background(), "d1", "ami-1")
+	assert.ErrorContains(t, err, "recording the green environment as active: throttled")
+	assert.Equal(t, StatusFailed, deployment.Status)
+	assert.Equal(t, [][2]Color{{Blue, Green}}, switcher.switches)
+	assert.Equal(t, [][2]Color{{Green, Blue}}, switcher.restores, "Blue, still recorded as active, gets the traffic back")
+	assert.False(t, deployment.RolledBackAt.IsZero())
+	assert.Empty(t, provisioner.scaledDown)
+	assert.Equal(t, Blue, active.state.Color)
+}
+
+func TestColorOther(t *testing.T) {
+	assert.Equal(t, Green, Blue.Other())
+	assert.Equal(t, Blue, Green.Other())
+}
+
+type fakeGuard struct {
+	err error
+}
+
+func (g *fakeGuard) Check(ctx context.Context, env *Environment, since time.Time) error {
+	return g.err
+}
+
+// flakyGuard fails the first checks
+type flakyGuard struct {
+	failures int
+}
+
+func (g *flakyGuard) Check(ctx context.Context, env *Environment, since time.Time) error {
+	if g.failures > 0 {
+		g.failures--
+		return errors.New("throttled")
+	}
+	return nil
+}
+
+func TestDeployRollback(t *testing.T) {
+	testCases := []struct {
+		name           string
+		guard          Guard
+		wantReason     string
+		wantStatus     Status
+		wantSwitches   [][2]Color
+		wantRestores   [][2]Color
+		wantScaledDown []Color
+		wantActive     Color
+	}{
+		{
+			name:           "no regression",
+			guard:          &fakeGuard{},
+			wantStatus:     StatusSucceeded,
+			wantSwitches:   [][2]Color{{Blue, Green}},
+			wantScaledDown: []Color{Blue},
+			wantActive:     Green,
+		},
+		{
+			name:           "guard errors are retried",
+			guard:          &flakyGuard{failures: 2},
+			wantStatus:     StatusSucceeded,
+			wantSwitches:   [][2]Color{{Blue, Green}},
+			wantScaledDown: []Color{Blue},
+			wantActive:     Green,
+		},
+		{
+			name:         "guard errors in a row switch back",
+			guard:        &fakeGuard{errors.New("throttled")},
+			wantReason:   "the green environment can't be checked, a guard failed 3 times in a row: throttled",
+			wantStatus:   StatusRolledBack,
+			wantSwitches: [][2]Color{{Blue, Green}},
+			wantRestores: [][2]Color{{Green, Blue}},
+			wantActive:   Blue,
+		},
+		{
+			name:         "regression switches back",
+			guard:        &fakeGuard{&Regression{"5xx rate"}},
+			wantReason:   "5xx rate",
+			wantStatus:   StatusRolledBack,
+			wantSwitches: [][2]Color{{Blue, Green}},
+			wantRestores: [][2]Color{{Green, Blue}},
+			wantActive:   Blue,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			provisioner := &fakeProvisioner{images: map[Color]string{}}
+			switcher := &fakeSwitcher{}
+			active := &fakeActiveStore{state: &ActiveState{Color: Blue, DeployID: "d0"}}
+			deployer := New(provisioner, &fakeHealth{true}, switcher, active,
+				WithGuards(tc.guard),
+				WithWarmWindow(50*time.Millisecond),
+				WithPollInterval(time.Millisecond),
+			)
+			deployment, err := deployer.Deploy(context.Background(), "d1", "ami-1")
+			if tc.wantReason != "" {
+				var regression *Regression
+				assert.True(t, errors.As(err, &regression))
+				assert.Equal(t, tc.wantReason, deployment.Reason)
+				assert.False(t, deployment.RolledBackAt.IsZero())
+				assert.Equal(t, "d1", active.state.DeployID)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.wantStatus, deployment.Status)
+			assert.Equal(t, tc.wantSwitches, switcher.switches)
+			assert.Equal(t, tc.wantRestores, switcher.restores)
+			assert.Equal(t, tc.wantScaledDown, provisioner.scaledDown)
+			assert.Equal(t, tc.wantActive, active.state.Color)
+		})
+	}
+}
+
+func TestDeployRollbackSkipsTheShiftChecks(t *testing.T) {
+	provisioner := &fakeProvisioner{images: map[Color]string{}}
+	records := &fakeRecords{}
+	//Healthy for the two steps of the switch, failing any check made during the rollback
+	switcher := NewDNSSwitcher(records, "Z1", "app.example.com", 0, &unhealthyAfter{2}, WithShiftSteps(50), WithBakeTime(0))
+	active := &fakeActiveStore{state: &ActiveState{Color: Blue, DeployID: "d0"}}
+	deployer := New(provisioner, &fakeHealth{true}, switcher, active,
+		WithGuards(&fakeGuard{&Regression{"5xx rate"}}),
+		WithWarmWindow(50*time.Millisecond),
+		WithPollInterval(time.Millisecond),
+	)
+
+	deployment, err := deployer.Deploy(context.Background(), "d1", "ami-1")
+	var regression *Regression
+	assert.True(t, errors.As(err, &regression))
+	assert.Equal(t, StatusRolledBack, deployment.Status)
+	assert.Equal(t, []string{
+		"green=green.elb:50", "blue=blue.elb:50",
+		"green=green.elb:100", "blue=blue.elb:0",
+		"blue=blue.elb:100", "green=green.elb:0",
+	}, records.weights, "All the traffic goes back to blue at once")
+	assert.Equal(t, Blue, active.state.Color)
+}
@@ -0,0 +1,86 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	route53types "github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra"
+)
+
+// DNSSwitcher moves the traffic between colours fronted by their own load balancers, with a pair of
+// weighted CNAME records of the same name pointing to the Endpoint of each colour. The set identifier
+// of a record is its colour. The weights are shifted in steps like the CanarySwitcher; each step
+// bakes for at least the TTL so resolvers caching the previous answer have picked the new weights.
+type DNSSwitcher struct {
+	shifter
+	records      awsinfra.ResourceManager[*awsinfra.DNSRecordInput, *route53types.ResourceRecordSet]
+	hostedZoneID string
+	name         string
+	ttl          int64
+}
+
+// NewDNSSwitcher returns a Switcher shifting the weights of the records named name in the hosted zone.
+// records is the DNSRecordSet manager of the provider, which waits for the changes to be INSYNC.
+func NewDNSSwitcher(records awsinfra.ResourceManager[*awsinfra.DNSRecordInput, *route53types.ResourceRecordSet], hostedZoneID string, name string, ttl int64, health HealthChecker, opts ...ShiftOption) *DNSSwitcher {
+	return &DNSSwitcher{
+		shifter:      newShifter(health, opts),
+		records:      records,
+		hostedZoneID: hostedZoneID,
+		name:         name,
+		ttl:          ttl,
+	}
+}
+
+// Switch shifts the weights of the records from the from environment to the to environment step by step
+func (s *DNSSwitcher) Switch(ctx context.Context, from *Environment, to *Environment) error {
+	if to.Endpoint == "" {
+		return fmt.Errorf("the %s environment has no endpoint", to.Color)
+	}
+	return s.run(ctx, from, to, time.Duration(s.ttl)*time.Second, s.shift)
+}
+
+// Restore gives all the weight to the record of the to environment in a single step
+func (s *DNSSwitcher) Restore(ctx context.Context, from *Environment, to *Environment) error {
+	if to.Endpoint == "" {
+		return fmt.Errorf("the %s environment has no endpoint", to.Color)
+	}
+	return s.shift(ctx, from, to, 100)
+}
+
+// shift upserts the records of both colours with their weights. The record gaining weight is
+// written first, so the name keeps resolving to a weighted record while both change.
+func (s *DNSSwitcher) shift(ctx context.Context, from *Environment, to *Environment, percent int32) error {
+	records := []*awsinfra.DNSRecordInput{s.record(to, percent)}
+	if from != nil {
+		records = append(records, s.record(from, 100-percent))
+	}
+	if percent == 0 {
+		//Reverting, the from environment is gaining the traffic back
+		slices.Reverse(records)
+	}
+	for _, record := range records {
+		if _, _, err := s.records.Update(ctx, record, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// record returns the weighted record of the environment
+func (s *DNSSwitcher) record(env *Environment, weight int32) *awsinfra.DNSRecordInput {
+	return &awsinfra.DNSRecordInput{
+		HostedZoneId: aws.String(s.hostedZoneID),
+		ResourceRecordSet: &route53types.ResourceRecordSet{
+			Name:            aws.String(s.name),
+			Type:            route53types.RRTypeCname,
+			SetIdentifier:   aws.String(string(env.Color)),
+			Weight:          aws.Int64(int64(weight)),
+			TTL:             aws.Int64(s.ttl),
+			ResourceRecords: []route53types.ResourceRecord{{Value: aws.String(env.Endpoint)}},
+		},
+	}
+}
@@ -0,0 +1,92 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	route53types "github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeRecords struct {
+	awsinfra.ResourceManager[*awsinfra.DNSRecordInput, *route53types.ResourceRecordSet]
+	weights []string
+}
+
+func (r *fakeRecords) Update(ctx context.Context, input *awsinfra.DNSRecordInput, last *route53types.ResourceRecordSet) (awsinfra.ExternalID, *route53types.ResourceRecordSet, error) {
+	r.weights = append(r.weights, fmt.Sprintf("%s=%s:%d", aws.ToString(input.SetIdentifier), aws.ToString(input.ResourceRecords[0].Value), aws.ToInt64(input.Weight)))
+	return aws.String(aws.ToString(input.SetIdentifier)), input.ResourceRecordSet, nil
+}
+
+// unhealthyAfter reports the environment healthy for the first checks
+type unhealthyAfter struct {
+	checks int
+}
+
+func (h *unhealthyAfter) Healthy(ctx context.Context, env *Environment) (bool, error) {
+	h.checks--
+	return h.checks >= 0, nil
+}
+
+func TestDNSSwitcher(t *testing.T) {
+	blue := &Environment{Color: Blue, Endpoint: "blue.elb"}
+	green := &Environment{Color: Green, Endpoint: "green.elb"}
+	testCases := []struct {
+		name        string
+		from        *Environment
+		healthy     int
+		wantErr     bool
+		wantWeights []string
+	}{
+		{
+			name:        "first deployment",
+			healthy:     0,
+			wantWeights: []string{"green=green.elb:100"},
+		},
+		{
+			name:    "shift in steps",
+			from:    blue,
+			healthy: 2,
+			wantWeights: []string{
+				"green=green.elb:50", "blue=blue.elb:50",
+				"green=green.elb:100", "blue=blue.elb:0",
+			},
+		},
+		{
+			name:    "revert when unhealthy",
+			from:    blue,
+			healthy: 0,
+			wantErr: true,
+			wantWeights: []string{
+				"green=green.elb:50", "blue=blue.elb:50",
+				"blue=blue.elb:100", "green=green.elb:0",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			records := &fakeRecords{}
+			switcher := NewDNSSwitcher(records, "Z1", "app.example.com", 0, &unhealthyAfter{tc.healthy}, WithShiftSteps(50), WithBakeTime(0))
+			err := switcher.Switch(context.Background(), tc.from, green)
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.wantWeights, records.weights)
+		})
+	}
+}
+
+func TestDNSSwitcherRecord(t *testing.T) {
+	switcher := NewDNSSwitcher(nil, "Z1", "app.example.com", 60, nil)
+	record := switcher.record(&Environment{Color: Blue, Endpoint: "blue.elb"}, 25)
+	assert.Equal(t, "Z1", aws.ToString(record.HostedZoneId))
+	assert.Equal(t, "blue", aws.ToString(record.SetIdentifier))
+	assert.Equal(t, int64(25), aws.ToInt64(record.Weight))
+	assert.Equal(t, int64(60), aws.ToInt64(record.TTL))
+	assert.Equal(t, route53types.RRTypeCname, record.Type)
+}
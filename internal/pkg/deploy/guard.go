@@ -0,0 +1,157 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	cloudwatchtypes "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// Guard watches the new colour during the warm window, while the previous colour can still take the traffic back
+type Guard interface {
+	// Check returns a *Regression when the environment regressed since the switch.
+	// Other errors, such as throttled API calls, are retried at the next poll; after a few in a row
+	// the Deployer takes the environment as regressed, see WithGuardFailures.
+	Check(ctx context.Context, env *Environment, since time.Time) error
+}
+
+// Regression is returned by a Guard when the new colour breached a threshold
+type Regression struct {
+	Reason string
+}
+
+func (r *Regression) Error() string {
+	return r.Reason
+}
+
+// HealthGuard reports a regression as soon as the environment is unhealthy
+type HealthGuard struct {
+	health HealthChecker
+}
+
+// NewHealthGuard returns a Guard checking the environment with health
+func NewHealthGuard(health HealthChecker) *HealthGuard {
+	return &HealthGuard{health}
+}
+
+// Check reports a regression when the environment is unhealthy
+func (g *HealthGuard) Check(ctx context.Context, env *Environment, since time.Time) error {
+	healthy, err := g.health.Healthy(ctx, env)
+	if err != nil {
+		return err
+	}
+	if !healthy {
+		return &Regression{fmt.Sprintf("the targets of the %s environment are unhealthy", env.Color)}
+	}
+	return nil
+}
+
+// MetricsThresholds are the limits of a MetricsGuard
+type MetricsThresholds struct {
+	Max5xxRate  float64       //maximum ratio of requests answered with a 5xx by the targets, unchecked when 0
+	MaxLatency  time.Duration //maximum p99 of the target response time over a minute, unchecked when 0
+	MinRequests float64       //requests needed before the 5xx rate is checked, so a few errors don't roll back
+}
+
+// metricsPeriod is the period of the load balancer metrics
+const metricsPeriod = 60
+
+// MetricsGuard watches the CloudWatch metrics the main load balancer publishes for the target groups
+// of the environment. Only the errors of the targets are counted; the 5xx the load balancer answers
+// itself are published for the whole load balancer, so they can't be told apart between colours.
+type MetricsGuard struct {
+	client           *cloudwatch.Client
+	loadBalancerName string //the LoadBalancer dimension, app/name/id
+	thresholds       MetricsThresholds
+}
+
+// NewMetricsGuard returns a Guard checking the metrics of the main load balancer against the thresholds
+func NewMetricsGuard(client *cloudwatch.Client, loadBalancerARN string, thresholds MetricsThresholds) *MetricsGuard {
+	return &MetricsGuard{
+		client:           client,
+		loadBalancerName: arnResource(loadBalancerARN, "loadbalancer/"),
+		thresholds:       thresholds,
+	}
+}
+
+// Check reports a regression when the 5xx rate or the latency of the targets since the switch breached a threshold
+func (g *MetricsGuard) Check(ctx context.Context, env *Environment, since time.Time) error {
+	var queries []cloudwatchtypes.MetricDataQuery
+	for i, arn := range env.TargetGroupARNs {
+		dimensions := []cloudwatchtypes.Dimension{
+			{Name: aws.String("LoadBalancer"), Value: aws.String(g.loadBalancerName)},
+			{Name: aws.String("TargetGroup"), Value: aws.String(arnResource(arn, ""))},
+		}
+		queries = append(queries,
+			metricQuery(fmt.Sprintf("requests%d", i), "RequestCount", "Sum", dimensions),
+			metricQuery(fmt.Sprintf("errors%d", i), "HTTPCode_Target_5XX_Count", "Sum", dimensions),
+			metricQuery(fmt.Sprintf("latency%d", i), "TargetResponseTime", "p99", dimensions),
+		)
+	}
+	var requests, errors5xx, latency float64
+	paginator := cloudwatch.NewGetMetricDataPaginator(g.client, &cloudwatch.GetMetricDataInput{
+		StartTime:         aws.Time(since.Truncate(time.Minute)),
+		EndTime:           aws.Time(time.Now()),
+		MetricDataQueries: queries,
+	})
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, result := range output.MetricDataResults {
+			id := aws.ToString(result.Id)
+			for _, value := range result.Values {
+				switch {
+				case strings.HasPrefix(id, "requests"):
+					requests += value
+				case strings.HasPrefix(id, "errors"):
+					errors5xx += value
+				case strings.HasPrefix(id, "latency"):
+					latency = max(latency, value)
+				}
+			}
+		}
+	}
+	return g.thresholds.evaluate(env.Color, requests, errors5xx, time.Duration(latency*float64(time.Second)))
+}
+
+// evaluate returns a *Regression when the metrics breach the thresholds
+func (t MetricsThresholds) evaluate(color Color, requests float64, errors5xx float64, latency time.Duration) error {
+	if t.Max5xxRate > 0 && requests > 0 && requests >= t.MinRequests {
+		if rate := errors5xx / requests; rate > t.Max5xxRate {
+			return &Regression{fmt.Sprintf("the %s environment answered %.2f%% of %.0f requests with a 5xx, over the %.2f%% threshold", color, rate*100, requests, t.Max5xxRate*100)}
+		}
+	}
+	if t.MaxLatency > 0 && latency > t.MaxLatency {
+		return &Regression{fmt.Sprintf("the p99 latency of the %s environment is %s, over the %s threshold", color, latency.Round(time.Millisecond), t.MaxLatency)}
+	}
+	return nil
+}
+
+func metricQuery(id string, metric string, stat string, dimensions []cloudwatchtypes.Dimension) cloudwatchtypes.MetricDataQuery {
+	return cloudwatchtypes.MetricDataQuery{
+		Id: aws.String(id),
+		MetricStat: &cloudwatchtypes.MetricStat{
+			Metric: &cloudwatchtypes.Metric{
+				Namespace:  aws.String("AWS/ApplicationELB"),
+				MetricName: aws.String(metric),
+				Dimensions: dimensions,
+			},
+			Period: aws.Int32(metricsPeriod),
+			Stat:   aws.String(stat),
+		},
+	}
+}
+
+// arnResource returns the resource part of an elasticloadbalancing ARN, after the prefix.
+// arn:aws:elasticloadbalancing:region:account:loadbalancer/app/name/id with the loadbalancer/ prefix
+// is app/name/id, the value of the metrics dimensions.
+func arnResource(arn string, prefix string) string {
+	parts := strings.SplitN(arn, ":", 6)
+	return strings.TrimPrefix(parts[len(parts)-1], prefix)
+}
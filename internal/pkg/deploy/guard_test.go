@@ -0,0 +1,40 @@
+package deploy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsThresholds(t *testing.T) {
+	thresholds := MetricsThresholds{Max5xxRate: 0.01, MaxLatency: time.Second, MinRequests: 100}
+	testCases := []struct {
+		name       string
+		requests   float64
+		errors5xx  float64
+		latency    time.Duration
+		wantReason string
+	}{
+		{name: "within thresholds", requests: 1000, errors5xx: 5, latency: 200 * time.Millisecond},
+		{name: "too few requests", requests: 10, errors5xx: 5},
+		{name: "no traffic yet"},
+		{name: "5xx rate", requests: 1000, errors5xx: 50, wantReason: "the green environment answered 5.00% of 1000 requests with a 5xx, over the 1.00% threshold"},
+		{name: "latency", requests: 1000, latency: 1500 * time.Millisecond, wantReason: "the p99 latency of the green environment is 1.5s, over the 1s threshold"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := thresholds.evaluate(Green, tc.requests, tc.errors5xx, tc.latency)
+			if tc.wantReason == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.Equal(t, &Regression{tc.wantReason}, err)
+		})
+	}
+}
+
+func TestARNResource(t *testing.T) {
+	assert.Equal(t, "app/main/50dc6c495c0c9188", arnResource("arn:aws:elasticloadbalancing:us-east-1:123456789012:loadbalancer/app/main/50dc6c495c0c9188", "loadbalancer/"))
+	assert.Equal(t, "targetgroup/blue/73e2d6bc24d8a067", arnResource("arn:aws:elasticloadbalancing:us-east-1:123456789012:targetgroup/blue/73e2d6bc24d8a067", ""))
+}
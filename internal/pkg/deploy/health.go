@@ -0,0 +1,35 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+
+	elbv2 "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	elbv2targetgroupmanager "github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra/managers/elasticloadbalacingv2/targetgroup"
+)
+
+// TargetHealthChecker checks the health of an environment through the health checks of its target groups
+type TargetHealthChecker struct {
+	client *elbv2.Client
+}
+
+// NewTargetHealthChecker returns a HealthChecker using the target groups of the environments
+func NewTargetHealthChecker(client *elbv2.Client) *TargetHealthChecker {
+	return &TargetHealthChecker{client}
+}
+
+// Healthy reports an environment healthy once every target group of the environment has
+// as many healthy targets as desired instances, and no target failing its health checks.
+// Targets being drained are leaving the group, so they are not taken into account.
+func (h *TargetHealthChecker) Healthy(ctx context.Context, env *Environment) (bool, error) {
+	if len(env.TargetGroupARNs) == 0 {
+		return false, fmt.Errorf("the %s environment has no target groups", env.Color)
+	}
+	desired := max(env.DesiredCapacity, 1)
+	for _, arn := range env.TargetGroupARNs {
+		if healthy, err := elbv2targetgroupmanager.Healthy(ctx, h.client, arn, desired); err != nil || !healthy {
+			return false, err
+		}
+	}
+	return true, nil
+}
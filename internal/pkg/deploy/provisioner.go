@@ -0,0 +1,85 @@
+package deploy
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/awsinfra"
+)
+
+// Blueprint describes the resources of the colours to awsinfra
+type Blueprint interface {
+	// Declare declares on infra the resources of the colour, running the image.
+	// Resources shared by both colours, such as the VPC, may be declared as well.
+	Declare(infra *awsinfra.Infra, color Color, imageID string) error
+	// AutoScalingGroupID returns the id the AutoScalingGroup of the colour is declared with
+	AutoScalingGroupID(color Color) awsinfra.InternalID
+	// LoadBalancerID returns the id of the load balancer reaching only the colour, or an empty id if there isn't one
+	LoadBalancerID(color Color) awsinfra.InternalID
+}
+
+// InfraProvisioner provisions the colours by applying a Blueprint with awsinfra.Infra
+type InfraProvisioner struct {
+	newInfra  func() *awsinfra.Infra
+	blueprint Blueprint
+	client    *autoscaling.Client
+}
+
+// NewInfraProvisioner returns a Provisioner applying the blueprint. An Infra only applies
+// a resource once, so newInfra is called to get a new one for each provisioning.
+func NewInfraProvisioner(newInfra func() *awsinfra.Infra, blueprint Blueprint, client *autoscaling.Client) *InfraProvisioner {
+	return &InfraProvisioner{
+		newInfra:  newInfra,
+		blueprint: blueprint,
+		client:    client,
+	}
+}
+
+// Provision declares the resources of the colour and applies them
+func (p *InfraProvisioner) Provision(ctx context.Context, color Color, imageID string) (*Environment, error) {
+	infra := p.newInfra()
+	if err := p.blueprint.Declare(infra, color, imageID); err != nil {
+		return nil, err
+	}
+	if err := infra.Apply(ctx); err != nil {
+		return nil, err
+	}
+	return p.environment(ctx, infra, color)
+}
+
+// Environment loads the AutoScalingGroup and the load balancer of the colour
+func (p *InfraProvisioner) Environment(ctx context.Context, color Color) (*Environment, error) {
+	return p.environment(ctx, p.newInfra(), color)
+}
+
+func (p *InfraProvisioner) environment(ctx context.Context, infra *awsinfra.Infra, color Color) (*Environment, error) {
+	asg, err := infra.LoadAutoScale(ctx, p.blueprint.AutoScalingGroupID(color))
+	if err != nil {
+		return nil, err
+	}
+	env := &Environment{
+		Color:                color,
+		AutoScalingGroupName: aws.ToString(asg.AutoScalingGroupName),
+		DesiredCapacity:      aws.ToInt32(asg.DesiredCapacity),
+		TargetGroupARNs:      asg.TargetGroupARNs,
+	}
+	if id := p.blueprint.LoadBalancerID(color); id != "" {
+		lb, err := infra.LoadLoadBalancer(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		env.Endpoint = aws.ToString(lb.DNSName)
+	}
+	return env, nil
+}
+
+// ScaleDown sets the capacity of the AutoScalingGroup of the environment to zero
+func (p *InfraProvisioner) ScaleDown(ctx context.Context, env *Environment) error {
+	_, err := p.client.UpdateAutoScalingGroup(ctx, &autoscaling.UpdateAutoScalingGroupInput{
+		AutoScalingGroupName: aws.String(env.AutoScalingGroupName),
+		MinSize:              aws.Int32(0),
+		DesiredCapacity:      aws.Int32(0),
+	})
+	return err
+}
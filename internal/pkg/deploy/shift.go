@@ -0,0 +1,110 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultShiftSteps are the percentages of traffic sent to the new colour by the progressive switchers
+var DefaultShiftSteps = []int32{5, 25, 50, 100}
+
+// DefaultBakeTime is the time a progressive switcher waits at each step before checking the new colour
+const DefaultBakeTime = 5 * time.Minute
+
+// ShiftOption configures optional settings of the progressive switchers
+type ShiftOption func(*shifter)
+
+// WithShiftSteps sets the increasing percentages of traffic sent to the new colour.
+// 100 is added when the last step is lower.
+func WithShiftSteps(steps ...int32) ShiftOption {
+	return func(s *shifter) {
+		s.steps = steps
+	}
+}
+
+// WithBakeTime sets the time to wait at each step before checking the new colour
+func WithBakeTime(bakeTime time.Duration) ShiftOption {
+	return func(s *shifter) {
+		s.bakeTime = bakeTime
+	}
+}
+
+// shiftFunc sends percent of the traffic to the to environment and the rest to the from environment
+type shiftFunc func(ctx context.Context, from *Environment, to *Environment, percent int32) error
+
+// shifter runs the steps of the progressive switchers, gating each step with health
+type shifter struct {
+	health   HealthChecker
+	steps    []int32
+	bakeTime time.Duration
+}
+
+func newShifter(health HealthChecker, opts []ShiftOption) shifter {
+	s := shifter{
+		health:   health,
+		steps:    DefaultShiftSteps,
+		bakeTime: DefaultBakeTime,
+	}
+	for _, opt := range opts {
+		opt(&s)
+	}
+	return s
+}
+
+// run shifts the traffic step by step, baking each step for at least minBake.
+// When a step fails all the traffic is sent back to the from environment.
+// On the first deployment there is no traffic to shift, so it is all sent to the new colour at once.
+func (s *shifter) run(ctx context.Context, from *Environment, to *Environment, minBake time.Duration, shift shiftFunc) error {
+	if from == nil {
+		return shift(ctx, nil, to, 100)
+	}
+	steps, err := shiftSteps(s.steps)
+	if err != nil {
+		return err
+	}
+	bakeTime := max(s.bakeTime, minBake)
+	for _, percent := range steps {
+		if err := s.step(ctx, from, to, percent, bakeTime, shift); err != nil {
+			//Revert even when ctx is done, the old colour must get its traffic back
+			if revertErr := shift(context.WithoutCancel(ctx), from, to, 0); revertErr != nil {
+				return errors.Join(err, fmt.Errorf("reverting the traffic to the %s environment: %w", from.Color, revertErr))
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *shifter) step(ctx context.Context, from *Environment, to *Environment, percent int32, bakeTime time.Duration, shift shiftFunc) error {
+	if err := shift(ctx, from, to, percent); err != nil {
+		return fmt.Errorf("shifting %d%% of the traffic to the %s environment: %w", percent, to.Color, err)
+	}
+	if err := sleep(ctx, bakeTime); err != nil {
+		return err
+	}
+	healthy, err := s.health.Healthy(ctx, to)
+	if err != nil {
+		return fmt.Errorf("checking the %s environment at %d%%: %w", to.Color, percent, err)
+	}
+	if !healthy {
+		return fmt.Errorf("the %s environment is unhealthy at %d%% of the traffic", to.Color, percent)
+	}
+	return nil
+}
+
+// shiftSteps validates the steps and ends them with 100
+func shiftSteps(steps []int32) ([]int32, error) {
+	var last int32
+	for _, percent := range steps {
+		if percent <= last || percent > 100 {
+			return nil, fmt.Errorf("shift steps must increase between 1 and 100, got %v", steps)
+		}
+		last = percent
+	}
+	if last < 100 {
+		steps = append(steps[:len(steps):len(steps)], 100)
+	}
+	return steps, nil
+}
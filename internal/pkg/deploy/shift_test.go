@@ -0,0 +1,35 @@
+package deploy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShiftSteps(t *testing.T) {
+	testCases := []struct {
+		name    string
+		steps   []int32
+		want    []int32
+		wantErr bool
+	}{
+		{name: "default", steps: DefaultShiftSteps, want: []int32{5, 25, 50, 100}},
+		{name: "100 is added", steps: []int32{10, 50}, want: []int32{10, 50, 100}},
+		{name: "no steps", want: []int32{100}},
+		{name: "decreasing", steps: []int32{50, 10}, wantErr: true},
+		{name: "over 100", steps: []int32{50, 150}, wantErr: true},
+		{name: "zero", steps: []int32{0, 50}, wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			steps, err := shiftSteps(tc.steps)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, steps)
+		})
+	}
+	assert.Equal(t, []int32{5, 25, 50, 100}, DefaultShiftSteps)
+}
@@ -0,0 +1,145 @@
+package smoke
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout is the timeout of a check attempt when none is set
+const DefaultTimeout = 10 * time.Second
+
+// maxBodySize is the size of the response body an HTTPCheck reads
+const maxBodySize = 1 << 20
+
+// HTTPCheck sends a request and checks the response
+type HTTPCheck struct {
+	CheckName     string
+	Method        string //GET when empty
+	Scheme        string //http when empty
+	Port          int    //port of the host when 0
+	Path          string
+	Header        map[string]string //request headers, Host overrides the request host
+	Body          string            //request body
+	Status        int               //expected status code, 200 when 0
+	BodyPattern   *regexp.Regexp    //the response body must match when set
+	HeaderPattern map[string]*regexp.Regexp
+	LatencyBudget time.Duration //maximum time to get the response, unchecked when 0
+	Timeout       time.Duration //DefaultTimeout when 0
+	Client        *http.Client  //http.DefaultClient when nil
+}
+
+// Name returns the name of the check, or the method and path when not set
+func (c *HTTPCheck) Name() string {
+	if c.CheckName != "" {
+		return c.CheckName
+	}
+	return fmt.Sprintf("%s %s", c.method(), c.path())
+}
+
+// Run sends the request to host and checks the status, body, headers and latency of the response
+func (c *HTTPCheck) Run(ctx context.Context, host string) error {
+	ctx, cancel := context.WithTimeout(ctx, orDefault(c.Timeout, DefaultTimeout))
+	defer cancel()
+	scheme := c.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	url := fmt.Sprintf("%s://%s%s", scheme, address(host, c.Port), c.path())
+	request, err := http.NewRequestWithContext(ctx, c.method(), url, strings.NewReader(c.Body))
+	if err != nil {
+		return err
+	}
+	for k, v := range c.Header {
+		if http.CanonicalHeaderKey(k) == "Host" {
+			request.Host = v
+			continue
+		}
+		request.Header.Set(k, v)
+	}
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	start := time.Now()
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	latency := time.Since(start)
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxBodySize))
+	if err != nil {
+		return fmt.Errorf("reading the response: %w", err)
+	}
+	status := c.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if response.StatusCode != status {
+		return fmt.Errorf("status %d, expected %d", response.StatusCode, status)
+	}
+	if c.BodyPattern != nil && !c.BodyPattern.Match(body) {
+		return fmt.Errorf("body doesn't match %q", c.BodyPattern)
+	}
+	for k, pattern := range c.HeaderPattern {
+		if v := response.Header.Get(k); !pattern.MatchString(v) {
+			return fmt.Errorf("header %s %q doesn't match %q", k, v, pattern)
+		}
+	}
+	if c.LatencyBudget > 0 && latency > c.LatencyBudget {
+		return fmt.Errorf("latency %s over the budget of %s", latency.Round(time.Millisecond), c.LatencyBudget)
+	}
+	return nil
+}
+
+func (c *HTTPCheck) method() string {
+	if c.Method == "" {
+		return http.MethodGet
+	}
+	return c.Method
+}
+
+func (c *HTTPCheck) path() string {
+	if !strings.HasPrefix(c.Path, "/") {
+		return "/" + c.Path
+	}
+	return c.Path
+}
+
+// TCPCheck checks a connection can be opened
+type TCPCheck struct {
+	CheckName string
+	Port      int           //port of the host when 0
+	Timeout   time.Duration //DefaultTimeout when 0
+}
+
+// Name returns the name of the check, or the port when not set
+func (c *TCPCheck) Name() string {
+	if c.CheckName != "" {
+		return c.CheckName
+	}
+	return fmt.Sprintf("tcp %d", c.Port)
+}
+
+// Run opens a connection to host and closes it
+func (c *TCPCheck) Run(ctx context.Context, host string) error {
+	dialer := net.Dialer{Timeout: orDefault(c.Timeout, DefaultTimeout)}
+	conn, err := dialer.DialContext(ctx, "tcp", address(host, c.Port))
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func orDefault(d time.Duration, def time.Duration) time.Duration {
+	if d == 0 {
+		return def
+	}
+	return d
+}
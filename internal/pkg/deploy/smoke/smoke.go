@@ -0,0 +1,187 @@
+// Package smoke checks an environment works before it receives traffic.
+// A Tester runs HTTP and TCP checks against the endpoint of the idle environment,
+// retrying failed checks, and reports their results.
+package smoke
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/deploy"
+)
+
+// Check is a single smoke test
+type Check interface {
+	// Name identifies the check in the report
+	Name() string
+	// Run checks the service reachable at host, a host name or host:port
+	Run(ctx context.Context, host string) error
+}
+
+// Result is the outcome of a check
+type Result struct {
+	Name     string
+	Attempts int
+	Duration time.Duration //time spent on all the attempts
+	Err      error         //error of the last attempt, nil when the check passed
+}
+
+// Report is the outcome of all the checks, in the order they were declared
+type Report struct {
+	Results []Result
+}
+
+// Failed returns the results of the failed checks
+func (r *Report) Failed() []Result {
+	var failed []Result
+	for _, result := range r.Results {
+		if result.Err != nil {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// String summarizes the report, one line per check
+func (r *Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d/%d smoke checks passed", len(r.Results)-len(r.Failed()), len(r.Results))
+	for _, result := range r.Results {
+		status := "ok"
+		if result.Err != nil {
+			status = "FAIL: " + result.Err.Error()
+		}
+		fmt.Fprintf(&b, "\n%s: %s (%d attempts, %s)", result.Name, status, result.Attempts, result.Duration.Round(time.Millisecond))
+	}
+	return b.String()
+}
+
+// Error is returned by Tester.Test when checks failed
+type Error struct {
+	Report *Report
+}
+
+func (e *Error) Error() string {
+	var names []string
+	for _, result := range e.Report.Failed() {
+		names = append(names, result.Name)
+	}
+	return fmt.Sprintf("smoke checks failed: %s", strings.Join(names, ", "))
+}
+
+// Default settings of a Tester
+const (
+	DefaultRetries       = 3
+	DefaultRetryInterval = 5 * time.Second
+)
+
+// Tester runs the checks against an environment
+type Tester struct {
+	checks        []Check
+	retries       int           //attempts after the first failed one
+	retryInterval time.Duration //time between attempts
+	reports       func(*Report)
+}
+
+// Option configures optional Tester settings
+type Option func(*Tester)
+
+// WithRetries sets how many times a failed check is attempted again
+func WithRetries(retries int) Option {
+	return func(t *Tester) {
+		t.retries = retries
+	}
+}
+
+// WithRetryInterval sets the time between attempts of a failed check
+func WithRetryInterval(interval time.Duration) Option {
+	return func(t *Tester) {
+		t.retryInterval = interval
+	}
+}
+
+// WithReport calls report with the report of each Test, whether it passed or not
+func WithReport(report func(*Report)) Option {
+	return func(t *Tester) {
+		t.reports = report
+	}
+}
+
+// New returns a Tester running the checks
+func New(checks []Check, opts ...Option) *Tester {
+	t := &Tester{
+		checks:        checks,
+		retries:       DefaultRetries,
+		retryInterval: DefaultRetryInterval,
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// Test runs the checks against the endpoint of the environment, so it gates the switch of a deploy.Deployer
+func (t *Tester) Test(ctx context.Context, env *deploy.Environment) error {
+	if env.Endpoint == "" {
+		return fmt.Errorf("the %s environment has no endpoint to smoke test", env.Color)
+	}
+	report := t.Run(ctx, env.Endpoint)
+	if t.reports != nil {
+		t.reports(report)
+	}
+	if len(report.Failed()) > 0 {
+		return &Error{report}
+	}
+	return nil
+}
+
+// Run runs every check against host, one after the other, and reports all the results
+func (t *Tester) Run(ctx context.Context, host string) *Report {
+	report := &Report{Results: make([]Result, 0, len(t.checks))}
+	for _, check := range t.checks {
+		report.Results = append(report.Results, t.run(ctx, check, host))
+	}
+	return report
+}
+
+func (t *Tester) run(ctx context.Context, check Check, host string) Result {
+	result := Result{Name: check.Name()}
+	start := time.Now()
+	for {
+		result.Attempts++
+		result.Err = check.Run(ctx, host)
+		if result.Err == nil || result.Attempts > t.retries {
+			break
+		}
+		if err := sleep(ctx, t.retryInterval); err != nil {
+			break
+		}
+	}
+	result.Duration = time.Since(start)
+	return result
+}
+
+// address returns host with the port, unless the port is 0
+func address(host string, port int) string {
+	if port == 0 {
+		return host
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return net.JoinHostPort(host, fmt.Sprint(port))
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,129 @@
+package smoke
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/livecodeforlife/go-simple-aws/internal/pkg/deploy"
+	"github.com/stretchr/testify/assert"
+)
+
+func newServer(t *testing.T) *httptest.Server {
+	failures := 2
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Version", "1.2.3")
+		w.Write([]byte(`{"status":"ok","host":"` + r.Host + `"}`))
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+	})
+	mux.HandleFunc("/flaky", func(w http.ResponseWriter, r *http.Request) {
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestHTTPCheck(t *testing.T) {
+	server := newServer(t)
+	host := strings.TrimPrefix(server.URL, "http://")
+	testCases := []struct {
+		name    string
+		check   HTTPCheck
+		wantErr string
+	}{
+		{
+			name:  "status, body and headers",
+			check: HTTPCheck{Path: "/health", BodyPattern: regexp.MustCompile(`"status":"ok"`), HeaderPattern: map[string]*regexp.Regexp{"X-Version": regexp.MustCompile(`^1\.`)}},
+		},
+		{
+			name:  "host header",
+			check: HTTPCheck{Path: "/health", Header: map[string]string{"Host": "app.example.com"}, BodyPattern: regexp.MustCompile(`app\.example\.com`)},
+		},
+		{
+			name:    "unexpected status",
+			check:   HTTPCheck{Path: "/missing"},
+			wantErr: "status 404, expected 200",
+		},
+		{
+			name:    "body mismatch",
+			check:   HTTPCheck{Path: "/health", BodyPattern: regexp.MustCompile(`"status":"down"`)},
+			wantErr: "body doesn't match",
+		},
+		{
+			name:    "header mismatch",
+			check:   HTTPCheck{Path: "/health", HeaderPattern: map[string]*regexp.Regexp{"X-Version": regexp.MustCompile(`^2\.`)}},
+			wantErr: "header X-Version",
+		},
+		{
+			name:    "latency budget",
+			check:   HTTPCheck{Path: "/slow", LatencyBudget: 10 * time.Millisecond},
+			wantErr: "over the budget",
+		},
+		{
+			name:    "timeout",
+			check:   HTTPCheck{Path: "/slow", Timeout: 10 * time.Millisecond},
+			wantErr: "deadline exceeded",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.check.Run(context.Background(), host)
+			if tc.wantErr != "" {
+				assert.ErrorContains(t, err, tc.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestTCPCheck(t *testing.T) {
+	server := newServer(t)
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	assert.NoError(t, (&TCPCheck{}).Run(context.Background(), "127.0.0.1:"+port))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	closed := listener.Addr().String()
+	listener.Close()
+	assert.Error(t, (&TCPCheck{Timeout: time.Second}).Run(context.Background(), closed))
+}
+
+func TestTester(t *testing.T) {
+	server := newServer(t)
+	env := &deploy.Environment{Color: deploy.Green, Endpoint: strings.TrimPrefix(server.URL, "http://")}
+	checks := []Check{
+		&HTTPCheck{CheckName: "health", Path: "/health"},
+		&HTTPCheck{CheckName: "flaky", Path: "/flaky"},
+		&TCPCheck{CheckName: "tcp"},
+	}
+
+	var report *Report
+	tester := New(checks, WithRetries(2), WithRetryInterval(time.Millisecond), WithReport(func(r *Report) { report = r }))
+	assert.NoError(t, tester.Test(context.Background(), env))
+	assert.Len(t, report.Results, 3)
+	assert.Equal(t, 3, report.Results[1].Attempts)
+	assert.Contains(t, report.String(), "3/3 smoke checks passed")
+
+	checks = append(checks, &HTTPCheck{CheckName: "missing", Path: "/missing"})
+	tester = New(checks, WithRetries(1), WithRetryInterval(time.Millisecond), WithReport(func(r *Report) { report = r }))
+	err := tester.Test(context.Background(), env)
+	var smokeErr *Error
+	assert.True(t, errors.As(err, &smokeErr))
+	assert.EqualError(t, err, "smoke checks failed: missing")
+	assert.Equal(t, 2, report.Results[3].Attempts)
+	assert.Contains(t, report.String(), "3/4 smoke checks passed")
+}
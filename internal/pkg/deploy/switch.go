@@ -0,0 +1,57 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	elbv2 "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	elbv2types "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+)
+
+// ListenerSwitcher moves all the traffic of a listener of the main load balancer at once
+type ListenerSwitcher struct {
+	client      *elbv2.Client
+	listenerARN string
+}
+
+// NewListenerSwitcher returns a Switcher forwarding the traffic of the listener to the new colour
+func NewListenerSwitcher(client *elbv2.Client, listenerARN string) *ListenerSwitcher {
+	return &ListenerSwitcher{client, listenerARN}
+}
+
+// Switch forwards the traffic of the listener to the target groups of the to environment
+func (s *ListenerSwitcher) Switch(ctx context.Context, from *Environment, to *Environment) error {
+	if len(to.TargetGroupARNs) == 0 {
+		return fmt.Errorf("the %s environment has no target groups", to.Color)
+	}
+	weights := make(map[string]int32, len(to.TargetGroupARNs))
+	for _, arn := range to.TargetGroupARNs {
+		weights[arn] = 1
+	}
+	_, err := s.client.ModifyListener(ctx, &elbv2.ModifyListenerInput{
+		ListenerArn:    aws.String(s.listenerARN),
+		DefaultActions: []elbv2types.Action{forwardAction(to.TargetGroupARNs, weights)},
+	})
+	return err
+}
+
+// Restore forwards the traffic of the listener to the target groups of the to environment, as Switch does
+func (s *ListenerSwitcher) Restore(ctx context.Context, from *Environment, to *Environment) error {
+	return s.Switch(ctx, from, to)
+}
+
+// forwardAction forwards to the target groups in arns order, with the given weights
+func forwardAction(arns []string, weights map[string]int32) elbv2types.Action {
+	targetGroups := make([]elbv2types.TargetGroupTuple, 0, len(arns))
+	for _, arn := range arns {
+		targetGroups = append(targetGroups, elbv2types.TargetGroupTuple{
+			TargetGroupArn: aws.String(arn),
+			Weight:         aws.Int32(weights[arn]),
+		})
+	}
+	return elbv2types.Action{
+		Type:          elbv2types.ActionTypeEnumForward,
+		ForwardConfig: &elbv2types.ForwardActionConfig{TargetGroups: targetGroups},
+	}
+}